@@ -1,6 +1,7 @@
 package main
 
 import (
+    "bufio"
     "bytes"
     "crypto/sha256"
     "encoding/json"
@@ -57,6 +58,19 @@ func main() {
         token, err := resolveToken(*tokenFlag)
         if err != nil { fail(err) }
         if err := cmdGetPort(client, baseURL, token); err != nil { fail(err) }
+    case "cert":
+        token, err := resolveToken(*tokenFlag)
+        if err != nil { fail(err) }
+        if err := cmdCert(client, baseURL, token, flag.Arg(1), flag.Arg(2)); err != nil { fail(err) }
+    case "jobs":
+        token, err := resolveToken(*tokenFlag)
+        if err != nil { fail(err) }
+        if err := cmdJobs(client, baseURL, token); err != nil { fail(err) }
+    case "follow":
+        token, err := resolveToken(*tokenFlag)
+        if err != nil { fail(err) }
+        // the stream stays open for as long as the job runs
+        if err := cmdFollow(&http.Client{}, baseURL, token, flag.Arg(1)); err != nil { fail(err) }
     case "update":
         if err := cmdUpdate(client, baseURL); err != nil { fail(err) }
     default:
@@ -74,9 +88,13 @@ func usage() {
     fmt.Println("  den [--token TOKEN] [--url BASE_URL] start|stop|restart")
     fmt.Println("  den [--token TOKEN] [--url BASE_URL] ports")
     fmt.Println("  den [--token TOKEN] [--url BASE_URL] get_port")
+    fmt.Println("  den [--token TOKEN] [--url BASE_URL] cert [PUBLIC_KEY_FILE] [HOURS]")
+    fmt.Println("  den [--token TOKEN] [--url BASE_URL] jobs")
+    fmt.Println("  den [--token TOKEN] [--url BASE_URL] follow JOB_ID")
     fmt.Println("  den [--url BASE_URL] update")
     fmt.Println()
     fmt.Println("Token resolution order: --token, DEN_CONTAINER_TOKEN, /etc/den/container_token, $HOME/.config/den/token")
+    fmt.Println("Master URL resolution order: --url, DEN_MASTER_URL, /etc/den/master_url")
 }
 
 func fail(err error) {
@@ -91,6 +109,10 @@ func resolveBaseURL(flagVal string) string {
     if v := os.Getenv("DEN_MASTER_URL"); strings.TrimSpace(v) != "" {
         return strings.TrimRight(v, "/")
     }
+    // written by the node when the container was set up
+    if b, err := os.ReadFile("/etc/den/master_url"); err == nil {
+        if v := strings.TrimSpace(string(b)); v != "" { return strings.TrimRight(v, "/") }
+    }
     return defaultBaseURL
 }
 
@@ -202,6 +224,147 @@ func cmdGetPort(client httpClient, baseURL, token string) error {
     return nil
 }
 
+// cmdCert asks the master to sign a public key and writes the certificate next
+// to it (id_ed25519.pub -> id_ed25519-cert.pub), where ssh picks it up automatically.
+func cmdCert(client httpClient, baseURL, token, keyPath, hours string) error {
+    if keyPath == "" { keyPath = filepath.Join(os.Getenv("HOME"), ".ssh/id_ed25519.pub") }
+    pub, err := os.ReadFile(keyPath)
+    if err != nil { return fmt.Errorf("cannot read public key: %w", err) }
+    payload := map[string]interface{}{"public_key": strings.TrimSpace(string(pub))}
+    if hours != "" {
+        var h int
+        if _, err := fmt.Sscanf(hours, "%d", &h); err != nil || h <= 0 { return fmt.Errorf("invalid hours: %s", hours) }
+        payload["hours"] = h
+    }
+    body, _ := json.Marshal(payload)
+    req, err := newRequest(http.MethodPost, baseURL+"/cli/ssh/certificate", token, bytes.NewBuffer(body))
+    if err != nil { return err }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := client.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        b, _ := io.ReadAll(resp.Body)
+        return fmt.Errorf("%s", strings.TrimSpace(string(b)))
+    }
+    var out struct {
+        Certificate string    `json:"certificate"`
+        ValidBefore time.Time `json:"valid_before"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return err }
+    certPath := strings.TrimSuffix(keyPath, ".pub") + "-cert.pub"
+    if err := os.WriteFile(certPath, []byte(out.Certificate), 0644); err != nil { return err }
+    fmt.Printf("wrote %s (valid until %s)\n", certPath, out.ValidBefore.Local().Format(time.RFC1123))
+    return nil
+}
+
+func cmdJobs(client httpClient, baseURL, token string) error {
+    req, err := newRequest(http.MethodGet, baseURL+"/cli/jobs", token, nil)
+    if err != nil { return err }
+    resp, err := client.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        b, _ := io.ReadAll(resp.Body)
+        return fmt.Errorf("%s", strings.TrimSpace(string(b)))
+    }
+    var out struct {
+        Jobs []struct {
+            ID        int       `json:"id"`
+            Type      string    `json:"type"`
+            Status    string    `json:"status"`
+            Step      *string   `json:"step"`
+            CreatedAt time.Time `json:"created_at"`
+        } `json:"jobs"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return err }
+    for _, j := range out.Jobs {
+        step := ""
+        if j.Step != nil { step = *j.Step }
+        fmt.Printf("%-6d %-18s %-10s %-20s %s\n", j.ID, j.Type, j.Status, step, j.CreatedAt.Local().Format(time.RFC1123))
+    }
+    return nil
+}
+
+// cmdFollow prints a job's progress as it happens, reconnecting from the
+// last event seen if the stream drops, and fails if the job does.
+func cmdFollow(client httpClient, baseURL, token, jobID string) error {
+    if strings.TrimSpace(jobID) == "" { return errors.New("usage: den follow JOB_ID") }
+    lastID := ""
+    for attempt := 0; ; attempt++ {
+        done, err := followOnce(client, baseURL, token, jobID, &lastID)
+        if done != nil {
+            fmt.Println(done.Status)
+            if done.Status != "success" {
+                if done.Error != nil { return fmt.Errorf("job %s: %s", done.Status, *done.Error) }
+                return fmt.Errorf("job %s", done.Status)
+            }
+            return nil
+        }
+        if attempt >= 5 { return err }
+        time.Sleep(2 * time.Second)
+    }
+}
+
+type jobDone struct {
+    Status string  `json:"status"`
+    Error  *string `json:"error"`
+}
+
+func followOnce(client httpClient, baseURL, token, jobID string, lastID *string) (*jobDone, error) {
+    req, err := newRequest(http.MethodGet, baseURL+"/cli/jobs/"+jobID+"/events", token, nil)
+    if err != nil { return nil, err }
+    req.Header.Set("Accept", "text/event-stream")
+    if *lastID != "" { req.Header.Set("Last-Event-ID", *lastID) }
+    resp, err := client.Do(req)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        b, _ := io.ReadAll(resp.Body)
+        return nil, fmt.Errorf("%s", strings.TrimSpace(string(b)))
+    }
+    var event, id string
+    var data []string
+    sc := bufio.NewScanner(resp.Body)
+    for sc.Scan() {
+        line := sc.Text()
+        switch {
+        case line == "":
+            payload := strings.Join(data, "\n")
+            if id != "" { *lastID = id }
+            switch event {
+            case "progress":
+                var ev struct {
+                    Level     string    `json:"level"`
+                    Step      string    `json:"step"`
+                    Message   string    `json:"message"`
+                    CreatedAt time.Time `json:"created_at"`
+                }
+                if json.Unmarshal([]byte(payload), &ev) == nil {
+                    msg := ev.Step
+                    if ev.Message != "" { msg += ": " + ev.Message }
+                    if ev.Level != "info" { msg = ev.Level + " " + msg }
+                    fmt.Printf("%s  %s\n", ev.CreatedAt.Local().Format("15:04:05"), msg)
+                }
+            case "done":
+                var d jobDone
+                if err := json.Unmarshal([]byte(payload), &d); err != nil { return nil, err }
+                return &d, nil
+            }
+            event, id, data = "", "", nil
+        case strings.HasPrefix(line, ":"):
+        case strings.HasPrefix(line, "event:"):
+            event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+        case strings.HasPrefix(line, "id:"):
+            id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+        case strings.HasPrefix(line, "data:"):
+            data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+        }
+    }
+    if err := sc.Err(); err != nil { return nil, err }
+    return nil, errors.New("stream ended before the job finished")
+}
+
 func cmdUpdate(client httpClient, baseURL string) error {
     arch := runtime.GOARCH
     if arch != "amd64" && arch != "arm64" {
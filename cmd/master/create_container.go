@@ -0,0 +1,378 @@
+package master
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/den/internal/config"
+	"github.com/den/internal/database"
+	"github.com/den/internal/dns"
+	"github.com/den/internal/jobs"
+	"github.com/lib/pq"
+)
+
+type createContainerPayload struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// createdContainer is what the node reported about the new instance, kept in
+// the workflow state for the later steps and for undoing them.
+type createdContainer struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	IP      *string `json:"ip"`
+	SSHPort int64   `json:"ssh_port"`
+}
+
+// createContainerSteps is the create_container workflow. Each step records a
+// progress event so the user can follow it from the dashboard or `den follow`;
+// if the job is abandoned the instance and its rows are removed again, unless
+// "place" adopted a container the user already had.
+func createContainerSteps(db *database.DB) []jobs.Step {
+	return []jobs.Step{
+		{
+			Name: "place",
+			Run: func(ctx context.Context, f *jobs.Flow) error {
+				p, err := createPayload(f)
+				if err != nil {
+					return err
+				}
+				// a job from before workflows may have got as far as the row, or
+				// this is a duplicate job for a user who already has one. Either
+				// way the container isn't this job's to remove.
+				var c createdContainer
+				var nodeID int
+				var hostname string
+				var ip sql.NullString
+				err = db.QueryRow(`
+					SELECT c.id, c.name, n.id, n.hostname, host(c.ip_address), COALESCE(c.ssh_port, 0)
+					FROM containers c JOIN nodes n ON c.node_id = n.id
+					WHERE c.user_id = $1
+				`, p.UserID).Scan(&c.ID, &c.Name, &nodeID, &hostname, &ip, &c.SSHPort)
+				if err == nil {
+					if ip.Valid {
+						c.IP = &ip.String
+					}
+					f.Set("container", c)
+					f.Set("adopted", true)
+					f.Set("node_id", nodeID)
+					f.Set("node_hostname", hostname)
+					f.Job.Progress("scheduled", "container already registered on "+hostname)
+					return nil
+				}
+				if err != sql.ErrNoRows {
+					return err
+				}
+				nodeID, hostname, err = pickNodeForContainer(db, p.Username)
+				if err != nil {
+					return err
+				}
+				f.Set("node_id", nodeID)
+				f.Set("node_hostname", hostname)
+				f.Job.Progress("scheduled", "scheduled on node "+hostname)
+				return nil
+			},
+		},
+		{
+			Name: "create_instance",
+			// the node call takes minutes and adopts a half-made instance,
+			// so a second try within the attempt is all it's worth
+			Attempts: 2,
+			Run: func(ctx context.Context, f *jobs.Flow) error {
+				var c createdContainer
+				if f.Get("container", &c) {
+					return nil
+				}
+				p, err := createPayload(f)
+				if err != nil {
+					return err
+				}
+				var hostname string
+				f.Get("node_hostname", &hostname)
+				info, err := createOnNode(ctx, f.Job, hostname, p)
+				if err != nil {
+					return err
+				}
+				c.ID, _ = info["ID"].(string)
+				c.Name, _ = info["Name"].(string)
+				if s, ok := info["IP"].(string); ok && s != "" {
+					c.IP = &s
+				}
+				if v, ok := info["SSHPort"].(float64); ok {
+					c.SSHPort = int64(v)
+				}
+				if c.ID == "" {
+					return errors.New("node did not report a container id")
+				}
+				f.Set("container", c)
+				return nil
+			},
+			Compensate: func(ctx context.Context, f *jobs.Flow) error {
+				if adoptedContainer(f) {
+					return nil
+				}
+				var hostname string
+				if !f.Get("node_hostname", &hostname) {
+					return nil
+				}
+				p, err := createPayload(f)
+				if err != nil {
+					return err
+				}
+				// the instance may exist even if we never heard back
+				id := "den-" + p.Username
+				var c createdContainer
+				if f.Get("container", &c) {
+					id = c.ID
+				}
+				req, _ := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("http://%s:8081/api/containers/%s", hostname, id), nil)
+				client := &http.Client{Timeout: 2 * time.Minute}
+				resp, err := client.Do(req)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+					b, _ := io.ReadAll(resp.Body)
+					return fmt.Errorf("delete %s on %s: %s", id, hostname, strings.TrimSpace(string(b)))
+				}
+				f.Job.Progress("removed", "deleted "+id+" from "+hostname)
+				return nil
+			},
+		},
+		{
+			Name: "register",
+			Run: func(ctx context.Context, f *jobs.Flow) error {
+				p, err := createPayload(f)
+				if err != nil {
+					return err
+				}
+				var c createdContainer
+				var nodeID int
+				f.Get("container", &c)
+				f.Get("node_id", &nodeID)
+				if _, err := db.Exec(`
+					INSERT INTO containers (id, user_id, node_id, name, status, ip_address, ssh_port, memory_mb, cpu_cores, storage_gb, allocated_ports)
+					VALUES ($1,$2,$3,$4,'RUNNING',$5,$6,4096,4,15,$7)
+					ON CONFLICT (id) DO NOTHING
+				`, c.ID, p.UserID, nodeID, c.Name, c.IP, sql.NullInt64{Int64: c.SSHPort, Valid: c.SSHPort > 0}, pq.Array([]int{})); err != nil {
+					return fmt.Errorf("db insert failed: %w", err)
+				}
+				if _, err := db.Exec(`UPDATE users SET container_id = $1, updated_at = NOW() WHERE id = $2`, c.ID, p.UserID); err != nil {
+					return fmt.Errorf("db update user failed: %w", err)
+				}
+				return nil
+			},
+			Compensate: func(ctx context.Context, f *jobs.Flow) error {
+				if adoptedContainer(f) {
+					return nil
+				}
+				p, err := createPayload(f)
+				if err != nil {
+					return err
+				}
+				var c createdContainer
+				if !f.Get("container", &c) {
+					return nil
+				}
+				if _, err := db.Exec(`UPDATE users SET container_id = NULL, updated_at = NOW() WHERE id = $1 AND container_id = $2`, p.UserID, c.ID); err != nil {
+					return err
+				}
+				_, err = db.Exec(`DELETE FROM containers WHERE id = $1 AND user_id = $2`, c.ID, p.UserID)
+				return err
+			},
+		},
+		{
+			Name: "write_token",
+			Run: func(ctx context.Context, f *jobs.Flow) error {
+				p, err := createPayload(f)
+				if err != nil {
+					return err
+				}
+				var c createdContainer
+				var hostname string
+				f.Get("container", &c)
+				f.Get("node_hostname", &hostname)
+				var token sql.NullString
+				if err := db.QueryRow(`SELECT container_token FROM containers WHERE id = $1`, c.ID).Scan(&token); err != nil {
+					return err
+				}
+				if !token.Valid {
+					b := make([]byte, 24)
+					if _, err := crand.Read(b); err != nil {
+						return errors.New("token gen failed")
+					}
+					token = sql.NullString{String: hex.EncodeToString(b), Valid: true}
+					if _, err := db.Exec(`UPDATE containers SET container_token = $1, updated_at = NOW() WHERE id = $2`, token.String, c.ID); err != nil {
+						return errors.New("db update token failed")
+					}
+				}
+				// the CLI in the container reads master_url to find its way back
+				return postToNode(ctx, hostname, "/api/cli/token", map[string]string{"container_id": c.ID, "token": token.String, "username": p.Username, "master_url": config.FromEnv().MasterURL})
+			},
+		},
+		{
+			Name: "install_cli",
+			Run: func(ctx context.Context, f *jobs.Flow) error {
+				var c createdContainer
+				var hostname string
+				f.Get("container", &c)
+				f.Get("node_hostname", &hostname)
+				if err := postToNode(ctx, hostname, "/api/cli/install", map[string]string{"container_id": c.ID}); err != nil {
+					return err
+				}
+				f.Job.Progress("cli_installed", "den CLI installed in "+c.ID)
+				var token string
+				_ = db.QueryRow(`SELECT container_token FROM containers WHERE id = $1`, c.ID).Scan(&token)
+				f.SetResult(map[string]interface{}{"container_id": c.ID, "ip_address": c.IP, "ssh_port": c.SSHPort, "container_token": token})
+				return nil
+			},
+		},
+		{
+			Name: "route_custom_domains",
+			Run: func(ctx context.Context, f *jobs.Flow) error {
+				p, err := createPayload(f)
+				if err != nil {
+					return err
+				}
+				var n int
+				_ = db.QueryRow(`SELECT COUNT(*) FROM custom_domains WHERE user_id = $1 AND status = 'verified'`, p.UserID).Scan(&n)
+				if n == 0 {
+					return nil
+				}
+				// domains verified for an earlier container; a failure here
+				// shouldn't undo the container, the next route rebuild fixes it
+				if err := dns.NewService().RestoreUserRoutes(db.DB, p.UserID); err != nil {
+					log.Printf("create_container: routing custom domains for %s: %v", p.Username, err)
+					return nil
+				}
+				f.Job.Progress("custom_domains", fmt.Sprintf("routed %d custom domains", n))
+				return nil
+			},
+		},
+	}
+}
+
+// adoptedContainer reports whether "place" found an existing container rather
+// than this job creating one.
+func adoptedContainer(f *jobs.Flow) bool {
+	var adopted bool
+	f.Get("adopted", &adopted)
+	return adopted
+}
+
+func createPayload(f *jobs.Flow) (createContainerPayload, error) {
+	var p createContainerPayload
+	if err := json.Unmarshal(f.Job.Payload, &p); err != nil {
+		return p, errors.New("invalid payload")
+	}
+	return p, nil
+}
+
+// createOnNode asks the node for den-<username>, which it reuses if an
+// earlier try left one behind. The node streams {"step"} lines and ends with
+// {"container"} or {"error"}; nodes that don't stream yet send the bare
+// container.
+func createOnNode(ctx context.Context, job *jobs.Job, hostname string, p createContainerPayload) (map[string]interface{}, error) {
+	body, _ := json.Marshal(map[string]interface{}{"user_id": p.UserID, "username": p.Username, "stream": true})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s:8081/api/containers", hostname), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, errors.New(string(b))
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var line map[string]interface{}
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF {
+				return nil, errors.New("node closed the connection before the container was ready")
+			}
+			return nil, errors.New("decode response failed")
+		}
+		if msg, ok := line["error"].(string); ok {
+			return nil, errors.New(msg)
+		}
+		if info, ok := line["container"].(map[string]interface{}); ok {
+			return info, nil
+		}
+		if _, ok := line["ID"]; ok {
+			return line, nil
+		}
+		if step, ok := line["step"].(string); ok {
+			msg, _ := line["message"].(string)
+			job.Progress(step, msg)
+		}
+	}
+}
+
+func postToNode(ctx context.Context, hostname, path string, payload interface{}) error {
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s:8081%s", hostname, path), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s on %s: %d %s", path, hostname, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// pickNodeForContainer prefers a node that already has den-<username>, left
+// behind by an earlier attempt, and otherwise takes the first online node.
+func pickNodeForContainer(db *database.DB, username string) (int, string, error) {
+	rows, err := db.Query(`SELECT id, hostname FROM nodes WHERE is_online = true ORDER BY id`)
+	if err != nil {
+		return 0, "", err
+	}
+	type node struct {
+		id       int
+		hostname string
+	}
+	var nodes []node
+	for rows.Next() {
+		var n node
+		if err := rows.Scan(&n.id, &n.hostname); err == nil {
+			nodes = append(nodes, n)
+		}
+	}
+	rows.Close()
+	if len(nodes) == 0 {
+		return 0, "", errors.New("no available nodes")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, n := range nodes {
+		resp, err := client.Get(fmt.Sprintf("http://%s:8081/api/containers/den-%s", n.hostname, username))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			log.Printf("create_container: reusing den-%s found on %s", username, n.hostname)
+			return n.id, n.hostname, nil
+		}
+	}
+	return nodes[0].id, nodes[0].hostname, nil
+}
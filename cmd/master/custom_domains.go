@@ -0,0 +1,218 @@
+package master
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/den/internal/database"
+	"github.com/den/internal/dns"
+	"github.com/den/internal/jobs"
+	"github.com/lib/pq"
+)
+
+// unverified domains are checked by the sweep for this long before they are
+// deleted, which frees the name for whoever really owns it
+const customDomainVerifyWindow = 7 * 24 * time.Hour
+
+// verified domains have their ownership re-checked this often, and lose
+// their route after this many failed checks in a row
+const (
+	customDomainRecheckInterval      = time.Hour
+	customDomainMaxOwnershipFailures = 3
+)
+
+// certificates are re-checked once they are this close to expiring, which
+// also shows when Caddy failed to renew one
+const customDomainCertRenewWindow = 14 * 24 * time.Hour
+
+// verifyCustomDomainJob runs the ownership check for one domain, enqueued
+// when the domain is added and when the user asks for a re-check. It fails
+// (and so retries) while the records aren't there yet.
+func verifyCustomDomainJob(ctx context.Context, db *database.DB, dnsService *dns.Service, job *jobs.Job) ([]byte, error) {
+	var p struct {
+		CustomDomainID int `json:"custom_domain_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return nil, errors.New("invalid payload")
+	}
+	status, err := verifyCustomDomain(ctx, db, dnsService, job, p.CustomDomainID)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{"status": status})
+}
+
+// checkCustomDomains keeps trying pending domains until the verify window
+// runs out, re-checks that verified domains are still owned by their user,
+// and re-checks certificates that aren't issued or are due for renewal.
+func checkCustomDomains(ctx context.Context, db *database.DB, dnsService *dns.Service, job *jobs.Job) ([]byte, error) {
+	res, err := db.Exec(`
+		DELETE FROM custom_domains
+		WHERE status IN ('pending', 'failed') AND pending_since < NOW() - $1 * INTERVAL '1 second'
+	`, customDomainVerifyWindow.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	expired, _ := res.RowsAffected()
+
+	rows, err := db.Query(`
+		SELECT id FROM custom_domains
+		WHERE status = 'pending'
+		   OR (status = 'verified' AND (last_checked_at IS NULL OR last_checked_at < NOW() - $1 * INTERVAL '1 second'))
+		   OR (status = 'verified' AND (cert_status <> 'issued' OR cert_expires_at < NOW() + $2 * INTERVAL '1 second'))
+		ORDER BY id
+	`, customDomainRecheckInterval.Seconds(), customDomainCertRenewWindow.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	counts := map[string]int64{"expired": expired}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		status, err := verifyCustomDomain(ctx, db, dnsService, job, id)
+		if err != nil {
+			counts["unverified"]++
+			continue
+		}
+		counts[status]++
+	}
+	return json.Marshal(counts)
+}
+
+// verifyCustomDomain checks the user still controls the domain. A pending
+// domain that passes is verified, unless another user verified the name
+// first; a verified domain that fails too many checks in a row is unrouted
+// and goes back to needing verification. Verified domains are then routed
+// and their certificate checked. It returns the domain's status, or an error
+// while a pending domain's ownership can't be shown.
+func verifyCustomDomain(ctx context.Context, db *database.DB, dnsService *dns.Service, job *jobs.Job, id int) (string, error) {
+	var hostname, token, status, username string
+	var targetPort, failures int
+	var suspended, certOK bool
+	var nodeIP sql.NullString
+	err := db.QueryRow(`
+		SELECT d.hostname, d.verification_token, d.status, d.target_port, d.ownership_failures, u.username,
+		       u.suspended_at IS NOT NULL, COALESCE(n.public_hostname, n.hostname),
+		       d.cert_status = 'issued' AND COALESCE(d.cert_expires_at > NOW() + $2 * INTERVAL '1 second', false)
+		FROM custom_domains d
+		JOIN users u ON d.user_id = u.id
+		LEFT JOIN containers c ON u.container_id = c.id
+		LEFT JOIN nodes n ON c.node_id = n.id
+		WHERE d.id = $1
+	`, id, customDomainCertRenewWindow.Seconds()).Scan(&hostname, &token, &status, &targetPort, &failures, &username, &suspended, &nodeIP, &certOK)
+	if err == sql.ErrNoRows {
+		// deleted while the job was queued
+		return "deleted", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	verr := dnsService.VerifyCustomDomain(checkCtx, hostname, username, token)
+	cancel()
+
+	if status != "verified" {
+		if verr != nil {
+			_, _ = db.Exec(`UPDATE custom_domains SET verification_error = $2, last_checked_at = NOW(), updated_at = NOW() WHERE id = $1`, id, verr.Error())
+			return "", fmt.Errorf("%s: %w", hostname, verr)
+		}
+		_, err := db.Exec(`
+			UPDATE custom_domains SET status = 'verified', verification_error = NULL, verified_at = NOW(),
+				last_checked_at = NOW(), ownership_failures = 0, cert_status = 'pending', updated_at = NOW()
+			WHERE id = $1
+		`, id)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			// someone else proved ownership first; this claim is deleted with
+			// the other unverified ones when its window runs out
+			_, _ = db.Exec(`UPDATE custom_domains SET status = 'failed', verification_error = 'already verified by another account', last_checked_at = NOW(), updated_at = NOW() WHERE id = $1`, id)
+			return "failed", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		job.Progress("verified", hostname+" ownership verified")
+	} else if verr != nil {
+		failures++
+		if failures < customDomainMaxOwnershipFailures {
+			_, _ = db.Exec(`UPDATE custom_domains SET ownership_failures = $2, verification_error = $3, last_checked_at = NOW(), updated_at = NOW() WHERE id = $1`, id, failures, verr.Error())
+			log.Printf("custom domain %s: ownership check %d of %d failed: %v", hostname, failures, customDomainMaxOwnershipFailures, verr)
+			return "verified", nil
+		}
+		// the name has probably expired or changed hands, so stop routing it
+		// and stop Caddy getting certificates for it
+		if _, err := db.Exec(`
+			UPDATE custom_domains SET status = 'failed', verification_error = $2, ownership_failures = $3,
+				pending_since = NOW(), last_checked_at = NOW(), cert_status = 'none', updated_at = NOW()
+			WHERE id = $1
+		`, id, verr.Error()+" (ownership lost)", failures); err != nil {
+			return "", err
+		}
+		if err := dnsService.RemoveCustomDomainRoute(hostname); err != nil {
+			log.Printf("custom domain %s: failed to remove route: %v", hostname, err)
+		}
+		job.Progress("unverified", hostname+" no longer verified: "+verr.Error())
+		return "lost", nil
+	} else {
+		_, _ = db.Exec(`UPDATE custom_domains SET ownership_failures = 0, verification_error = NULL, last_checked_at = NOW(), updated_at = NOW() WHERE id = $1`, id)
+		if certOK {
+			// already routed with a good certificate
+			return "verified", nil
+		}
+	}
+
+	if suspended || !nodeIP.Valid {
+		// the route is added when the user is restored or creates a container
+		return "verified", nil
+	}
+	if err := dnsService.AddCustomDomainRoute(hostname, nodeIP.String, targetPort); err != nil {
+		return "", err
+	}
+	certStatus := checkCustomDomainCert(db, id, hostname)
+	job.Progress("certificate", hostname+" certificate "+certStatus)
+	return "verified", nil
+}
+
+// checkCustomDomainCert connects to Caddy with the domain as SNI, which also
+// makes Caddy get the certificate on demand if it hasn't yet, and records
+// what it served. CADDY_TLS_ADDR is where Caddy listens (default
+// localhost:443).
+func checkCustomDomainCert(db *database.DB, id int, hostname string) string {
+	addr := os.Getenv("CADDY_TLS_ADDR")
+	if addr == "" {
+		addr = "localhost:443"
+	}
+	dialer := &net.Dialer{Timeout: 60 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: hostname})
+	if err != nil {
+		log.Printf("custom domain %s: certificate check failed: %v", hostname, err)
+		_, _ = db.Exec(`UPDATE custom_domains SET cert_status = 'failed', cert_error = $2, cert_checked_at = NOW(), updated_at = NOW() WHERE id = $1`, id, err.Error())
+		return "failed"
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	var expires *time.Time
+	if len(certs) > 0 {
+		expires = &certs[0].NotAfter
+	}
+	_, _ = db.Exec(`UPDATE custom_domains SET cert_status = 'issued', cert_error = NULL, cert_expires_at = $2, cert_checked_at = NOW(), updated_at = NOW() WHERE id = $1`, id, expires)
+	return "issued"
+}
@@ -0,0 +1,27 @@
+package master
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/den/internal/database"
+	"github.com/den/internal/dns"
+	"github.com/joho/godotenv"
+)
+
+// MigrateWildcardDNS is the one-off move to DNS_MODE=wildcard: it removes the
+// per-subdomain records once the zone's wildcard resolves. Run it before
+// switching the masters over, or right after; either way subdomains keep
+// resolving.
+func MigrateWildcardDNS(dryRun bool, resolver string) error {
+	if err := godotenv.Load(); err != nil {
+		log.Println("no .env file found")
+	}
+	db, err := database.Initialize()
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+	return dns.NewService().MigrateToWildcard(db.DB, os.Stdout, dryRun, resolver)
+}
@@ -0,0 +1,207 @@
+package master
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/den/internal/database"
+	email "github.com/den/internal/email"
+)
+
+const (
+	// how often the "idle check" schedule runs; samples are this far apart
+	idleCheckInterval = 10 * time.Minute
+	// samples older than this are never needed by any sensible policy
+	statsSampleRetention = 14 * 24 * time.Hour
+	// open ssh_sessions rows are refreshed by their gateway every minute; one
+	// that hasn't been for this long was left behind by a gateway that died
+	staleSessionAfter = 10 * time.Minute
+)
+
+var idleHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+type idleCandidate struct {
+	containerID  string
+	nodeHostname string
+	cpuCores     int
+	userID       int
+	username     string
+	email        string
+	idleHours    int
+	maxCPU       float64
+	maxNetPerHr  int64
+}
+
+// idleCheck samples stats for every running container and hibernates the
+// ones that have been idle for longer than their plan allows. It runs as the
+// idle_check job from the "idle check" schedule.
+func idleCheck(db *database.DB) ([]byte, error) {
+	if err := sampleContainerStats(db); err != nil {
+		log.Printf("idle: sampling error: %v", err)
+	}
+	if err := endStaleSessions(db); err != nil {
+		log.Printf("idle: failed to end stale ssh sessions: %v", err)
+	}
+	stopped, err := stopIdleContainers(db)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]int{"stopped": stopped})
+}
+
+// endStaleSessions closes ssh_sessions rows whose gateway stopped refreshing
+// them, so every row still open really is an open session.
+func endStaleSessions(db *database.DB) error {
+	_, err := db.Exec(`
+		UPDATE ssh_sessions SET ended_at = last_seen_at
+		WHERE ended_at IS NULL AND last_seen_at < $1
+	`, time.Now().Add(-staleSessionAfter))
+	return err
+}
+
+func sampleContainerStats(db *database.DB) error {
+	rows, err := db.Query(`
+		SELECT c.id, n.hostname FROM containers c
+		JOIN nodes n ON c.node_id = n.id
+		WHERE c.status = 'RUNNING' AND n.is_online = TRUE
+	`)
+	if err != nil { return err }
+	type target struct{ id, host string }
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.id, &t.host); err == nil { targets = append(targets, t) }
+	}
+	rows.Close()
+
+	for _, t := range targets {
+		resp, err := idleHTTPClient.Get(fmt.Sprintf("http://%s:8081/api/containers-stats/%s", t.host, t.id))
+		if err != nil { continue }
+		var stats struct {
+			CPUUsageNs       uint64 `json:"cpu_usage_ns"`
+			MemoryUsageBytes uint64 `json:"memory_usage_bytes"`
+			NetworkRXBytes   uint64 `json:"network_rx_bytes"`
+			NetworkTXBytes   uint64 `json:"network_tx_bytes"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK { continue }
+		_, err = db.Exec(`
+			INSERT INTO container_stats_samples (container_id, cpu_usage_ns, network_rx_bytes, network_tx_bytes, memory_usage_bytes)
+			VALUES ($1, $2, $3, $4, $5)
+		`, t.id, int64(stats.CPUUsageNs), int64(stats.NetworkRXBytes), int64(stats.NetworkTXBytes), int64(stats.MemoryUsageBytes))
+		if err != nil { log.Printf("idle: failed to store sample for %s: %v", t.id, err) }
+	}
+	_, err = db.Exec(`DELETE FROM container_stats_samples WHERE sampled_at < $1`, time.Now().Add(-statsSampleRetention))
+	return err
+}
+
+func stopIdleContainers(db *database.DB) (int, error) {
+	rows, err := db.Query(`
+		SELECT c.id, n.hostname, COALESCE(c.cpu_cores, 1), u.id, u.username, u.email,
+		       p.idle_hours, p.max_cpu_percent, p.max_network_bytes_per_hour
+		FROM containers c
+		JOIN nodes n ON c.node_id = n.id
+		JOIN users u ON c.user_id = u.id
+		JOIN idle_policies p ON p.plan = u.plan
+		WHERE c.status = 'RUNNING' AND p.enabled = TRUE
+		  AND NOT EXISTS (SELECT 1 FROM idle_exemptions e WHERE e.user_id = u.id)
+		  AND c.created_at < NOW() - make_interval(hours => p.idle_hours)
+	`)
+	if err != nil { return 0, err }
+	var candidates []idleCandidate
+	for rows.Next() {
+		var ic idleCandidate
+		if err := rows.Scan(&ic.containerID, &ic.nodeHostname, &ic.cpuCores, &ic.userID, &ic.username, &ic.email,
+			&ic.idleHours, &ic.maxCPU, &ic.maxNetPerHr); err == nil {
+			candidates = append(candidates, ic)
+		}
+	}
+	rows.Close()
+
+	stopped := 0
+	for _, ic := range candidates {
+		idle, reason := isContainerIdle(db, ic)
+		if !idle { continue }
+		log.Printf("idle: stopping container %s for %s (%s)", ic.containerID, ic.username, reason)
+		if err := stopContainerOnNode(ic.nodeHostname, ic.containerID); err != nil {
+			log.Printf("idle: failed to stop %s: %v", ic.containerID, err)
+			continue
+		}
+		_, _ = db.Exec(`UPDATE containers SET status = 'STOPPED', idle_stopped_at = NOW(), updated_at = NOW() WHERE id = $1`, ic.containerID)
+		stopped++
+		notifyIdleStop(ic)
+	}
+	return stopped, nil
+}
+
+// isContainerIdle reports whether the container has no open SSH sessions, had
+// none during the policy window, and stayed under the CPU and network
+// thresholds for the whole window. Containers without enough samples to cover
+// the window are left alone.
+func isContainerIdle(db *database.DB, ic idleCandidate) (bool, string) {
+	window := time.Duration(ic.idleHours) * time.Hour
+	since := time.Now().Add(-window)
+
+	var recentSessions int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM ssh_sessions
+		WHERE container_id = $1 AND (started_at > $2 OR ended_at > $2 OR ended_at IS NULL)
+	`, ic.containerID, since).Scan(&recentSessions)
+	if err != nil || recentSessions > 0 { return false, "" }
+
+	var firstAt, lastAt time.Time
+	var firstCPU, lastCPU, firstNet, lastNet int64
+	err = db.QueryRow(`
+		SELECT sampled_at, cpu_usage_ns, network_rx_bytes + network_tx_bytes FROM container_stats_samples
+		WHERE container_id = $1 AND sampled_at >= $2 ORDER BY sampled_at ASC LIMIT 1
+	`, ic.containerID, since.Add(-idleCheckInterval)).Scan(&firstAt, &firstCPU, &firstNet)
+	if err != nil { return false, "" }
+	err = db.QueryRow(`
+		SELECT sampled_at, cpu_usage_ns, network_rx_bytes + network_tx_bytes FROM container_stats_samples
+		WHERE container_id = $1 ORDER BY sampled_at DESC LIMIT 1
+	`, ic.containerID).Scan(&lastAt, &lastCPU, &lastNet)
+	if err != nil { return false, "" }
+
+	// the earliest sample must sit near the start of the window, otherwise we
+	// haven't been watching long enough to call it idle
+	if firstAt.After(since.Add(2 * idleCheckInterval)) { return false, "" }
+	elapsed := lastAt.Sub(firstAt)
+	if elapsed <= 0 { return false, "" }
+	// counters go backwards when the container restarts; treat that as activity
+	if lastCPU < firstCPU || lastNet < firstNet { return false, "" }
+
+	cores := ic.cpuCores
+	if cores <= 0 { cores = 1 }
+	cpuPercent := float64(lastCPU-firstCPU) / (float64(elapsed.Nanoseconds()) * float64(cores)) * 100
+	netPerHour := float64(lastNet-firstNet) / elapsed.Hours()
+	if cpuPercent > ic.maxCPU || netPerHour > float64(ic.maxNetPerHr) { return false, "" }
+	return true, fmt.Sprintf("cpu %.2f%%, network %.0f B/h over %dh", cpuPercent, netPerHour, ic.idleHours)
+}
+
+func stopContainerOnNode(nodeHostname, containerID string) error {
+	body, _ := json.Marshal(map[string]string{"action": "stop"})
+	resp, err := idleHTTPClient.Post(fmt.Sprintf("http://%s:8081/api/control/containers/%s", nodeHostname, containerID), "application/json", bytes.NewReader(body))
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 { return fmt.Errorf("node returned %d", resp.StatusCode) }
+	return nil
+}
+
+func notifyIdleStop(ic idleCandidate) {
+	if strings.TrimSpace(ic.email) == "" { return }
+	client, err := email.NewFromEnv(); if err != nil { return }
+	htmlBody := email.RenderNeobrutalismEmail(
+		"Your environment is asleep",
+		"Stopped after a period of inactivity",
+		fmt.Sprintf("<p>Hi <b>%s</b>, your den environment hasn't been used for %d hours, so we stopped it to free up resources for everyone.</p><p>Nothing was deleted. Start it again from the dashboard, or turn on <i>wake on connect</i> so it starts automatically the next time you SSH in.</p>",
+			html.EscapeString(ic.username), ic.idleHours),
+	)
+	_ = client.Send([]string{ic.email}, "den: your environment was stopped due to inactivity", htmlBody, "")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,13 +16,13 @@ import (
 	"time"
 	crand "crypto/rand"
 	"encoding/hex"
-	"math"
     "strings"
 
 	"github.com/den/internal/auth"
 	"github.com/den/internal/database"
 	"github.com/den/internal/dns"
 	"github.com/den/internal/handlers"
+	"github.com/den/internal/jobs"
 	"github.com/den/internal/storage"
 	"github.com/den/internal/ssh"
 	email "github.com/den/internal/email"
@@ -29,7 +30,6 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/lib/pq"
 )
 
 func Run() error {
@@ -65,24 +65,11 @@ func Run() error {
     if _, err := storage.NewR2ClientFromEnv(); err != nil {
         log.Printf("warning: R2 not configured: %v", err)
     }
-    router := setupRouter(authService, db)
-    go func() {
-        ticker := time.NewTicker(30 * time.Minute)
-        defer ticker.Stop()
-        for range ticker.C {
-            if err := cleanupExpiredExports(db); err != nil {
-                log.Printf("export cleanup error: %v", err)
-            }
-        }
-    }()
-    go func() {
-        for {
-            if err := runJobOnce(db); err != nil {
-                log.Printf("job worker error: %v", err)
-                time.Sleep(2 * time.Second)
-            }
-        }
-    }()
+    router := setupRouter(authService, db, sshGateway)
+    jobCfg := jobs.ConfigFromEnv()
+    jobRunner := jobs.NewRunner(db.DB, database.URL(), jobCfg)
+    registerJobHandlers(jobRunner, db, authService, dnsService)
+    jobRunner.Start()
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -101,11 +88,23 @@ func Run() error {
 	<-quit
 	log.Println("shutting down server...")
 
+	// stop claiming jobs right away, but let the ones in flight finish
+	jobsStopped := make(chan error, 1)
+	go func() {
+		jctx, jcancel := context.WithTimeout(context.Background(), jobCfg.ShutdownTimeout)
+		defer jcancel()
+		jobsStopped <- jobRunner.Shutdown(jctx)
+	}()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	
-	if err := srv.Shutdown(ctx); err != nil {
-		return fmt.Errorf("server forced to shutdown: %w", err)
+	srvErr := srv.Shutdown(ctx)
+	if err := <-jobsStopped; err != nil {
+		log.Printf("jobs still running after %s, leaving them: %v", jobCfg.ShutdownTimeout, err)
+	}
+	if srvErr != nil {
+		return fmt.Errorf("server forced to shutdown: %w", srvErr)
 	}
 
 	log.Println("server exited")
@@ -127,35 +126,59 @@ func cleanupExpiredExports(db *database.DB) error {
     return nil
 }
 
-func runJobOnce(db *database.DB) error {
-    tx, err := db.Begin()
+func cleanupExpiredRecordings(db *database.DB) error {
+    r2, err := storage.NewR2ClientFromEnv()
+    if err != nil { return nil }
+    rows, err := db.Query(`SELECT id, recording_key FROM ssh_sessions WHERE recording_key IS NOT NULL AND recording_expires_at < NOW()`)
     if err != nil { return err }
-    defer tx.Rollback()
-    var id int
-    var jtype string
-    var payloadStr string
-    err = tx.QueryRow(`SELECT id, type, payload FROM jobs WHERE status='queued' AND run_after <= NOW() ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED`).Scan(&id, &jtype, &payloadStr)
-    if err != nil {
-        if err.Error() == "sql: no rows in result set" { time.Sleep(1 * time.Second); return nil }
-        return err
-    }
-    if _, err := tx.Exec(`UPDATE jobs SET status='running', attempts=attempts+1, updated_at=NOW() WHERE id=$1`, id); err != nil { return err }
-    if err := tx.Commit(); err != nil { return err }
-
-    switch jtype {
-    case "export_container":
-        return handleExportJob(db, id, []byte(payloadStr))
-    case "create_container":
-        return handleCreateContainerJob(db, id, []byte(payloadStr))
-    case "delete_container":
-        return handleDeleteContainerJob(db, id, []byte(payloadStr))
-    default:
-        _, _ = db.Exec(`UPDATE jobs SET status='failed', error=$2, updated_at=NOW() WHERE id=$1`, id, "unknown job type")
-        return nil
+    defer rows.Close()
+    for rows.Next() {
+        var id int; var key string
+        if err := rows.Scan(&id, &key); err != nil { continue }
+        if err := r2.DeleteObject(context.Background(), key); err != nil {
+            log.Printf("failed to delete recording %s: %v", key, err)
+            continue
+        }
+        _, _ = db.Exec(`UPDATE ssh_sessions SET recording_key = NULL, recording_expires_at = NULL WHERE id=$1`, id)
     }
+    return nil
+}
+
+// cleanupSSHAuthHistory keeps 90 days of login history and drops bans that expired over a week ago.
+func cleanupSSHAuthHistory(db *database.DB) error {
+    if _, err := db.Exec(`DELETE FROM ssh_auth_attempts WHERE created_at < NOW() - INTERVAL '90 days'`); err != nil { return err }
+    _, err := db.Exec(`DELETE FROM ip_bans WHERE expires_at IS NOT NULL AND expires_at < NOW() - INTERVAL '7 days'`)
+    return err
 }
 
-func handleExportJob(db *database.DB, jobID int, payload []byte) error {
+// registerJobHandlers wires each job type to its handler. Pool sizes come
+// from jobs.ConfigFromEnv; the cleanup types are enqueued by the schedules
+// table.
+func registerJobHandlers(runner *jobs.Runner, db *database.DB, authService *auth.Service, dnsService *dns.Service) {
+    runner.Handle("export_container", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return handleExportJob(db, job.Payload) })
+    runner.HandleWorkflow("create_container", createContainerSteps(db)...)
+    runner.Handle("delete_container", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return handleDeleteContainerJob(db, job.Payload) })
+    runner.Handle("cleanup_exports", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return nil, cleanupExpiredExports(db) })
+    runner.Handle("cleanup_recordings", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return nil, cleanupExpiredRecordings(db) })
+    runner.Handle("cleanup_ssh_auth_history", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return nil, cleanupSSHAuthHistory(db) })
+    runner.Handle("purge_sessions", func(ctx context.Context, job *jobs.Job) ([]byte, error) {
+        n, err := authService.PurgeExpiredSessions()
+        if err != nil { return nil, err }
+        return json.Marshal(map[string]int64{"purged": n})
+    })
+    runner.Handle("idle_check", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return idleCheck(db) })
+    runner.Handle("suspension_expiry", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return nil, liftExpiredSuspensions(db, dnsService) })
+    runner.Handle("verify_custom_domain", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return verifyCustomDomainJob(ctx, db, dnsService, job) })
+    runner.Handle("check_custom_domains", func(ctx context.Context, job *jobs.Job) ([]byte, error) { return checkCustomDomains(ctx, db, dnsService, job) })
+    runner.Handle("mark_nodes_offline", func(ctx context.Context, job *jobs.Job) ([]byte, error) {
+        res, err := db.Exec("UPDATE nodes SET is_online=false WHERE is_online AND last_seen < NOW() - INTERVAL '90 seconds'")
+        if err != nil { return nil, err }
+        n, _ := res.RowsAffected()
+        return json.Marshal(map[string]int64{"marked_offline": n})
+    })
+}
+
+func handleExportJob(db *database.DB, payload []byte) ([]byte, error) {
     var p struct {
         ExportID    int    `json:"export_id"`
         UserID      int    `json:"user_id"`
@@ -168,26 +191,26 @@ func handleExportJob(db *database.DB, jobID int, payload []byte) error {
         TargetEmail string `json:"target_email"`
         TargetUsername string `json:"target_username"`
     }
-    if err := json.Unmarshal(payload, &p); err != nil { return err }
+    if err := json.Unmarshal(payload, &p); err != nil { return nil, err }
 
     r2, err := storage.NewR2ClientFromEnv()
-    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, "storage not configured"); return nil }
+    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, "storage not configured"); return nil, errors.New("storage not configured") }
     putURL, err := r2.PresignedPut(context.Background(), p.ObjectKey, 2*time.Hour)
-    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, "presign put failed"); return nil }
+    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, "presign put failed"); return nil, errors.New("presign put failed") }
     _, _ = db.Exec(`UPDATE exports SET status='uploading', updated_at=NOW() WHERE id=$1`, p.ExportID)
 
     slaveURL := fmt.Sprintf("http://%s:8081/api/export", p.NodeHostname)
     body, _ := json.Marshal(map[string]string{"container_id": p.ContainerID, "put_url": putURL})
     resp, err := http.Post(slaveURL, "application/json", bytes.NewBuffer(body))
-    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, err.Error()); return finalizeJob(db, jobID, false, err.Error(), nil) }
+    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, err.Error()); return nil, err }
     defer resp.Body.Close()
     if resp.StatusCode != http.StatusOK {
         b, _ := io.ReadAll(resp.Body)
         _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, string(b))
-        return finalizeJob(db, jobID, false, string(b), nil)
+        return nil, errors.New(string(b))
     }
     getURL, err := r2.PresignedGet(context.Background(), p.ObjectKey, time.Duration(p.TTLDays)*24*time.Hour)
-    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, "presign get failed"); return finalizeJob(db, jobID, false, "presign get failed", nil) }
+    if err != nil { _, _ = db.Exec(`UPDATE exports SET status='failed', error=$2 WHERE id=$1`, p.ExportID, "presign get failed"); return nil, errors.New("presign get failed") }
     _, _ = db.Exec(`UPDATE exports SET status='complete', updated_at=NOW() WHERE id=$1`, p.ExportID)
     go func() {
         client, err := email.NewFromEnv(); if err != nil { return }
@@ -206,136 +229,29 @@ func handleExportJob(db *database.DB, jobID int, payload []byte) error {
     }()
     res := map[string]string{"download_url": getURL}
     rb, _ := json.Marshal(res)
-    return finalizeJob(db, jobID, true, "", rb)
+    return rb, nil
 }
 
-func finalizeJob(db *database.DB, jobID int, success bool, errMsg string, result []byte) error {
-	if success {
-		_, err := db.Exec(`UPDATE jobs SET status='success', result=$2, updated_at=NOW() WHERE id=$1`, jobID, string(result))
-		return err
-	}
-	var attempts, maxAttempts int
-	if err := db.QueryRow(`SELECT attempts, COALESCE(max_attempts, 0) FROM jobs WHERE id=$1`, jobID).Scan(&attempts, &maxAttempts); err == nil {
-		if maxAttempts == 0 { maxAttempts = 3 }
-		if attempts < maxAttempts {
-			backoff := int(math.Pow(2, float64(attempts-1)) * 5)
-			if backoff > 300 { backoff = 300 }
-			delay := time.Duration(backoff) * time.Second
-			_, _ = db.Exec(`UPDATE jobs SET status='queued', run_after=NOW()+($2::interval), error=$3, updated_at=NOW() WHERE id=$1`, jobID, fmt.Sprintf("%d seconds", int(delay.Seconds())), errMsg)
-			return nil
-		}
-	}
-	_, err := db.Exec(`UPDATE jobs SET status='failed', error=$2, updated_at=NOW() WHERE id=$1`, jobID, errMsg)
-	return err
-}
-
-func handleCreateContainerJob(db *database.DB, jobID int, payload []byte) error {
-    var p struct {
-        UserID   int    `json:"user_id"`
-        Username string `json:"username"`
-    }
-    if err := json.Unmarshal(payload, &p); err != nil { return finalizeJob(db, jobID, false, "invalid payload", nil) }
-
-    var nodeID int
-    var nodeHostname string
-    if err := db.QueryRow(`SELECT id, hostname FROM nodes WHERE is_online = true ORDER BY id LIMIT 1`).Scan(&nodeID, &nodeHostname); err != nil {
-        return finalizeJob(db, jobID, false, "no available nodes", nil)
-    }
-    slaveURL := fmt.Sprintf("http://%s:8081", nodeHostname)
-
-    reqBody, _ := json.Marshal(map[string]interface{}{ "user_id": p.UserID, "username": p.Username })
-    resp, err := http.Post(slaveURL+"/api/containers", "application/json", bytes.NewBuffer(reqBody))
-    if err != nil {
-        return finalizeJob(db, jobID, false, err.Error(), nil)
-    }
-    defer resp.Body.Close()
-    if resp.StatusCode != http.StatusOK {
-        b, _ := io.ReadAll(resp.Body)
-        return finalizeJob(db, jobID, false, string(b), nil)
-    }
-    var containerInfo map[string]interface{}
-    if err := json.NewDecoder(resp.Body).Decode(&containerInfo); err != nil {
-        return finalizeJob(db, jobID, false, "decode response failed", nil)
-    }
-
-    containerID, _ := containerInfo["ID"].(string)
-    name, _ := containerInfo["Name"].(string)
-    ipAny, _ := containerInfo["IP"]
-    sshPortAny, _ := containerInfo["SSHPort"]
-    var ip *string
-    if s, ok := ipAny.(string); ok { ip = &s }
-    sshPort := 0
-    switch v := sshPortAny.(type) {
-    case float64:
-        sshPort = int(v)
-    case int:
-        sshPort = v
-    }
-
-    tokenBytes := make([]byte, 24)
-    if _, err := crand.Read(tokenBytes); err != nil { return finalizeJob(db, jobID, false, "token gen failed", nil) }
-    containerToken := hex.EncodeToString(tokenBytes)
-
-    if _, err := db.Exec(`INSERT INTO containers (id, user_id, node_id, name, status, ip_address, ssh_port, memory_mb, cpu_cores, storage_gb, allocated_ports, container_token) VALUES ($1,$2,$3,$4,'RUNNING',$5,$6,4096,4,15,$7,$8)`,
-        containerID, p.UserID, nodeID, name, ip, sshPort, pq.Array([]int{}), containerToken); err != nil {
-        return finalizeJob(db, jobID, false, "db insert failed", nil)
-    }
-    if _, err := db.Exec(`UPDATE users SET container_id = $1, updated_at = NOW() WHERE id = $2`, containerID, p.UserID); err != nil {
-        return finalizeJob(db, jobID, false, "db update user failed", nil)
-    }
-
-    {
-        body, _ := json.Marshal(map[string]string{"container_id": containerID, "token": containerToken, "username": p.Username})
-        resp, err := http.Post(slaveURL+"/api/cli/token", "application/json", bytes.NewBuffer(body))
-        if err != nil {
-            log.Printf("post /api/cli/token failed for %s: %v", containerID, err)
-        } else {
-            defer resp.Body.Close()
-            if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-                b, _ := io.ReadAll(resp.Body)
-                log.Printf("/api/cli/token non-200 for %s: %d %s", containerID, resp.StatusCode, strings.TrimSpace(string(b)))
-            }
-        }
-    }
-    {
-        installBody, _ := json.Marshal(map[string]string{"container_id": containerID})
-        resp, err := http.Post(slaveURL+"/api/cli/install", "application/json", bytes.NewBuffer(installBody))
-        if err != nil {
-            log.Printf("post /api/cli/install failed for %s: %v", containerID, err)
-        } else {
-            defer resp.Body.Close()
-            if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-                b, _ := io.ReadAll(resp.Body)
-                log.Printf("/api/cli/install non-200 for %s: %d %s", containerID, resp.StatusCode, strings.TrimSpace(string(b)))
-            }
-        }
-    }
-
-    res := map[string]interface{}{ "container_id": containerID, "ip_address": ip, "ssh_port": sshPort, "container_token": containerToken }
-    rb, _ := json.Marshal(res)
-    return finalizeJob(db, jobID, true, "", rb)
-}
-
-func handleDeleteContainerJob(db *database.DB, jobID int, payload []byte) error {
+func handleDeleteContainerJob(db *database.DB, payload []byte) ([]byte, error) {
     var p struct {
         UserID      int    `json:"user_id"`
         ContainerID string `json:"container_id"`
         NodeHostname string `json:"node_hostname"`
         Username    string `json:"username"`
     }
-    if err := json.Unmarshal(payload, &p); err != nil { return finalizeJob(db, jobID, false, "invalid payload", nil) }
+    if err := json.Unmarshal(payload, &p); err != nil { return nil, errors.New("invalid payload") }
 
     slaveURL := fmt.Sprintf("http://%s:8081/api/containers/%s", p.NodeHostname, p.ContainerID)
     req, _ := http.NewRequest(http.MethodDelete, slaveURL, nil)
     client := &http.Client{Timeout: 2 * time.Minute}
     resp, err := client.Do(req)
     if err != nil {
-        return finalizeJob(db, jobID, false, err.Error(), nil)
+        return nil, err
     }
     defer resp.Body.Close()
     if resp.StatusCode < 200 || resp.StatusCode >= 300 {
         b, _ := io.ReadAll(resp.Body)
-        return finalizeJob(db, jobID, false, string(b), nil)
+        return nil, errors.New(string(b))
     }
 
     if rows, qerr := db.Query(`SELECT subdomain, subdomain_type FROM subdomains WHERE user_id = $1`, p.UserID); qerr == nil {
@@ -348,14 +264,24 @@ func handleDeleteContainerJob(db *database.DB, jobID int, payload []byte) error
         }
         _, _ = db.Exec("DELETE FROM subdomains WHERE user_id = $1", p.UserID)
     }
+    // custom domains stay verified for the next container, but lose their routes
+    if rows, qerr := db.Query(`SELECT hostname FROM custom_domains WHERE user_id = $1 AND status = 'verified'`, p.UserID); qerr == nil {
+        defer rows.Close()
+        for rows.Next() {
+            var hostname string
+            if err := rows.Scan(&hostname); err == nil {
+                _ = dns.NewService().RemoveCustomDomainRoute(hostname)
+            }
+        }
+    }
 
     _, _ = db.Exec("DELETE FROM containers WHERE id = $1", p.ContainerID)
     _, _ = db.Exec("UPDATE users SET container_id = NULL, updated_at = NOW() WHERE id = $1", p.UserID)
 
-    return finalizeJob(db, jobID, true, "", nil)
+    return nil, nil
 }
 
-func setupRouter(authService *auth.Service, db *database.DB) *gin.Engine {
+func setupRouter(authService *auth.Service, db *database.DB, sshGateway *ssh.Gateway) *gin.Engine {
     gin.SetMode(gin.ReleaseMode)
     r := gin.New()
     // i have no idea how to use prometheus
@@ -368,22 +294,13 @@ func setupRouter(authService *auth.Service, db *database.DB) *gin.Engine {
         []string{"method","path"},
     )
     prometheus.MustRegister(requestCounter, durationHist)
-    nodeContainers := prometheus.NewGauge(prometheus.GaugeOpts{Name: "den_master_total_containers", Help: "Total containers across cluster"})
+    // counted at scrape time so every master reports it without a poller
+    nodeContainers := prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "den_master_total_containers", Help: "Total containers across cluster"}, func() float64 {
+        var total int
+        db.QueryRow("SELECT COUNT(*) FROM containers").Scan(&total)
+        return float64(total)
+    })
     prometheus.MustRegister(nodeContainers)
-    go func(){
-        for {
-            var total int
-            db.QueryRow("SELECT COUNT(*) FROM containers").Scan(&total)
-            nodeContainers.Set(float64(total))
-            time.Sleep(15 * time.Second)
-        }
-    }()
-    go func(){
-        for {
-            _, _ = db.Exec("UPDATE nodes SET is_online=false WHERE last_seen < NOW() - INTERVAL '90 seconds'")
-            time.Sleep(30 * time.Second)
-        }
-    }()
     r.GET("/metrics", gin.WrapH(promhttp.Handler()))
     r.GET("/healthz", func(c *gin.Context){ c.JSON(http.StatusOK, gin.H{"ok": true}) })
     r.Use(func(c *gin.Context) {
@@ -416,7 +333,7 @@ func setupRouter(authService *auth.Service, db *database.DB) *gin.Engine {
 	r.Static("/assets", "./webapp/dist/assets")
 	r.StaticFile("/vite.svg", "./webapp/dist/vite.svg")
 	r.Static("/downloads/cli", "./cli/dist")
-    h := handlers.New(authService, db)
+    h := handlers.New(authService, db, sshGateway)
 	webhookGroup := r.Group("/webhook")
 	webhookGroup.Use(h.RawBodyMiddleware())
 	{
@@ -425,10 +342,17 @@ func setupRouter(authService *auth.Service, db *database.DB) *gin.Engine {
 
 	r.GET("/", h.Home)
 	r.GET("/login", h.LoginPage)
+	r.GET("/login/2fa", h.TwoFactorLoginPage)
+	r.POST("/login/2fa", h.TwoFactorLogin)
 	r.GET("/legal", h.LegalPage)
 	r.GET("/logout", h.Logout)
-	r.GET("/auth/github", h.GitHubAuth)
 	r.GET("/auth/callback", h.GitHubCallback)
+	r.GET("/auth/:provider", h.ProviderAuth)
+	r.GET("/auth/:provider/callback", h.ProviderCallback)
+
+	// Caddy's on-demand TLS check; it only says whether a verified custom
+	// domain exists, which DNS already tells anyone
+	r.GET("/internal/caddy/ask", h.CaddyAsk)
 
 	userGroup := r.Group("/user")
 	userGroup.Use(h.RequireAuth())
@@ -440,23 +364,55 @@ func setupRouter(authService *auth.Service, db *database.DB) *gin.Engine {
 		userGroup.GET("/container/stats", h.ContainerStats)
 		userGroup.GET("/container/shell", h.GetContainerShell)
 		userGroup.POST("/container/shell", h.SetContainerShell)
+		userGroup.POST("/settings/wake-on-connect", h.SetWakeOnConnect)
 		userGroup.POST("/container/start", h.ContainerStart)
 		userGroup.POST("/container/stop", h.ContainerStop)
 		userGroup.POST("/container/restart", h.ContainerRestart)
 		userGroup.GET("/container/token", h.ContainerToken)
-		userGroup.POST("/container/token/rotate", h.RotateContainerToken)
+		userGroup.POST("/container/token/rotate", h.RequireStepUp(), h.RotateContainerToken)
 		userGroup.POST("/container/create", h.CreateContainer)
-		userGroup.POST("/container/export", h.UserExportContainer)
+		userGroup.POST("/container/export", h.RequireStepUp(), h.UserExportContainer)
 		userGroup.POST("/container/ports/new", h.GetNewPort)
 		userGroup.GET("/subdomains", h.SubdomainManagement)
 		userGroup.POST("/subdomains", h.CreateSubdomain)
 		userGroup.DELETE("/subdomains/:id", h.DeleteSubdomain)
 		userGroup.GET("/api/subdomains", h.GetUserSubdomains)
+		userGroup.GET("/custom-domains", h.ListCustomDomains)
+		userGroup.POST("/custom-domains", h.CreateCustomDomain)
+		userGroup.POST("/custom-domains/:id/verify", h.VerifyCustomDomain)
+		userGroup.DELETE("/custom-domains/:id", h.DeleteCustomDomain)
 		userGroup.GET("/ssh-setup", h.SSHSetup)
-		userGroup.POST("/ssh-setup", h.ConfigureSSH)
+		userGroup.POST("/ssh-setup", h.RequireStepUp(), h.ConfigureSSH)
+		userGroup.GET("/api/logins", h.UserSSHLogins)
+		userGroup.POST("/ssh/certificate", h.RequireStepUp(), h.IssueSSHCertificate)
+		userGroup.GET("/ssh/certificates", h.ListSSHCertificates)
+		userGroup.DELETE("/ssh/certificates/:serial", h.RevokeSSHCertificate)
+		userGroup.GET("/ssh/ca.pub", h.SSHCAPublicKey)
+		userGroup.GET("/tokens", h.TokensPage)
+		userGroup.GET("/api/tokens", h.ListAccessTokens)
+		userGroup.POST("/tokens", h.RequireStepUp(), h.CreateAccessToken)
+		userGroup.DELETE("/tokens/:id", h.DeleteAccessToken)
+		userGroup.GET("/identities", h.IdentitiesPage)
+		userGroup.GET("/api/identities", h.ListIdentities)
+		userGroup.GET("/identities/:provider/link", h.LinkIdentity)
+		userGroup.DELETE("/identities/:id", h.UnlinkIdentity)
+		userGroup.GET("/sessions", h.SessionsPage)
+		userGroup.GET("/api/sessions", h.ListSessions)
+		userGroup.DELETE("/sessions/:id", h.RevokeSession)
+		userGroup.POST("/sessions/revoke-all", h.RevokeAllSessions)
+		userGroup.GET("/2fa", h.TwoFactorPage)
+		userGroup.GET("/api/2fa", h.TwoFactorStatus)
+		userGroup.POST("/2fa/enroll", h.BeginTwoFactor)
+		userGroup.POST("/2fa/confirm", h.ConfirmTwoFactor)
+		userGroup.POST("/2fa/disable", h.DisableTwoFactor)
+		userGroup.POST("/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+		userGroup.POST("/2fa/reauth", h.StepUp)
 		userGroup.POST("/aup/validate", h.AUPValidate)
 		userGroup.POST("/verification/create", h.CreateVerificationSession)
 		userGroup.GET("/verification/status", h.GetVerificationStatus)
+		userGroup.GET("/jobs", h.UserListJobs)
+		userGroup.GET("/jobs/:id", h.UserGetJob)
+		userGroup.GET("/jobs/:id/events", h.UserJobEvents)
 	}
 
 	adminGroup := r.Group("/admin")
@@ -464,20 +420,66 @@ func setupRouter(authService *auth.Service, db *database.DB) *gin.Engine {
 	adminGroup.Use(h.RequireAdmin())
 	{
 		adminGroup.GET("/", h.AdminDashboard)
-		adminGroup.GET("/nodes", h.NodeManagement)
-		adminGroup.POST("/nodes", h.CreateNode)
-		adminGroup.GET("/nodes/:id/token", h.GenerateNodeToken)
-		adminGroup.DELETE("/nodes/:id", h.DeleteNode)
-		adminGroup.GET("/users", h.UserManagement)
-		adminGroup.DELETE("/users/:id", h.DeleteUser)
-		adminGroup.DELETE("/users/:id/container", h.AdminDeleteUserContainer)
-		adminGroup.POST("/users/:id/rotate-token", h.AdminRotateUserContainerToken)
-		adminGroup.POST("/users/:id/reinstall-cli", h.AdminReinstallUserCLI)
-		adminGroup.POST("/users/:id/approve", h.AdminApproveUser)
-		adminGroup.POST("/users/:id/reject", h.AdminRejectUser)
-		adminGroup.POST("/users/:id/export", h.AdminExportUserContainer)
-		adminGroup.GET("/jobs", h.AdminListJobs)
-		adminGroup.GET("/jobs/:id", h.AdminGetJob)
+		adminGroup.GET("/nodes", h.RequirePermission(auth.PermNodesManage), h.NodeManagement)
+		adminGroup.POST("/nodes", h.RequirePermission(auth.PermNodesManage), h.CreateNode)
+		adminGroup.GET("/nodes/:id/token", h.RequirePermission(auth.PermNodesManage), h.GenerateNodeToken)
+		adminGroup.DELETE("/nodes/:id", h.RequirePermission(auth.PermNodesManage), h.DeleteNode)
+		adminGroup.GET("/users", h.RequirePermission(auth.PermUsersRead), h.UserManagement)
+		adminGroup.DELETE("/users/:id", h.RequirePermission(auth.PermUsersManage), h.DeleteUser)
+		adminGroup.DELETE("/users/:id/container", h.RequirePermission(auth.PermContainersManage), h.RequireStepUp(), h.AdminDeleteUserContainer)
+		adminGroup.POST("/users/:id/rotate-token", h.RequirePermission(auth.PermContainersManage), h.RequireStepUp(), h.AdminRotateUserContainerToken)
+		adminGroup.POST("/users/:id/reinstall-cli", h.RequirePermission(auth.PermContainersManage), h.AdminReinstallUserCLI)
+		adminGroup.POST("/users/:id/approve", h.RequirePermission(auth.PermUsersReview), h.AdminApproveUser)
+		adminGroup.POST("/users/:id/reject", h.RequirePermission(auth.PermUsersReview), h.AdminRejectUser)
+		adminGroup.GET("/users/:id/verifications", h.RequirePermission(auth.PermUsersReview), h.AdminListUserVerifications)
+		adminGroup.POST("/users/:id/export", h.RequirePermission(auth.PermContainersManage), h.RequireStepUp(), h.AdminExportUserContainer)
+		adminGroup.GET("/jobs", h.RequirePermission(auth.PermJobsManage), h.AdminListJobs)
+		adminGroup.GET("/jobs/failed", h.RequirePermission(auth.PermJobsManage), h.AdminListFailedJobs)
+		adminGroup.POST("/jobs/retry-failed", h.RequirePermission(auth.PermJobsManage), h.AdminBulkRetryJobs)
+		adminGroup.GET("/jobs/:id", h.RequirePermission(auth.PermJobsManage), h.AdminGetJob)
+		adminGroup.GET("/jobs/:id/events", h.RequirePermission(auth.PermJobsManage), h.AdminJobEvents)
+		adminGroup.POST("/jobs/:id/cancel", h.RequirePermission(auth.PermJobsManage), h.AdminCancelJob)
+		adminGroup.POST("/jobs/:id/retry", h.RequirePermission(auth.PermJobsManage), h.AdminRetryJob)
+		adminGroup.POST("/jobs/:id/requeue", h.RequirePermission(auth.PermJobsManage), h.AdminRequeueJob)
+		adminGroup.GET("/schedules", h.RequirePermission(auth.PermJobsManage), h.AdminListSchedules)
+		adminGroup.POST("/schedules/:id/pause", h.RequirePermission(auth.PermJobsManage), h.AdminPauseSchedule)
+		adminGroup.POST("/schedules/:id/resume", h.RequirePermission(auth.PermJobsManage), h.AdminResumeSchedule)
+		adminGroup.POST("/schedules/:id/trigger", h.RequirePermission(auth.PermJobsManage), h.AdminTriggerSchedule)
+		adminGroup.GET("/ssh/sessions", h.RequirePermission(auth.PermSSHManage), h.AdminListSSHSessions)
+		adminGroup.GET("/ssh/sessions/:id/recording", h.RequirePermission(auth.PermSSHManage), h.AdminDownloadSSHRecording)
+		adminGroup.GET("/ssh/auth-attempts", h.RequirePermission(auth.PermSSHManage), h.AdminListSSHAuthAttempts)
+		adminGroup.GET("/ssh/live", h.RequirePermission(auth.PermSSHManage), h.AdminListLiveSSHSessions)
+		adminGroup.GET("/ssh/messages", h.RequirePermission(auth.PermSSHManage), h.AdminListGatewayMessages)
+		adminGroup.PUT("/ssh/messages/:name", h.RequirePermission(auth.PermSSHManage), h.AdminUpdateGatewayMessage)
+		adminGroup.DELETE("/ssh/messages/:name", h.RequirePermission(auth.PermSSHManage), h.AdminResetGatewayMessage)
+		adminGroup.DELETE("/ssh/live/:id", h.RequirePermission(auth.PermSSHManage), h.AdminKillSSHSession)
+		adminGroup.DELETE("/users/:id/ssh/live", h.RequirePermission(auth.PermSSHManage), h.AdminKillUserSSHSessions)
+		adminGroup.DELETE("/users/:id/sessions", h.RequirePermission(auth.PermUsersManage), h.AdminRevokeUserSessions)
+		adminGroup.DELETE("/users/:id/2fa", h.RequirePermission(auth.PermUsersManage), h.AdminResetTwoFactor)
+		adminGroup.GET("/settings/security", h.RequirePermission(auth.PermRolesManage), h.AdminGetSecuritySettings)
+		adminGroup.PUT("/settings/security", h.RequirePermission(auth.PermRolesManage), h.AdminUpdateSecuritySettings)
+		adminGroup.POST("/users/:id/suspend", h.RequirePermission(auth.PermUsersManage), h.AdminSuspendUser)
+		adminGroup.DELETE("/users/:id/suspend", h.RequirePermission(auth.PermUsersManage), h.AdminUnsuspendUser)
+		adminGroup.GET("/ssh/bans", h.RequirePermission(auth.PermSSHManage), h.AdminListIPBans)
+		adminGroup.POST("/ssh/bans", h.RequirePermission(auth.PermSSHManage), h.AdminCreateIPBan)
+		adminGroup.DELETE("/ssh/bans/:id", h.RequirePermission(auth.PermSSHManage), h.AdminDeleteIPBan)
+		adminGroup.POST("/users/:id/plan", h.RequirePermission(auth.PermUsersManage), h.AdminSetUserPlan)
+		adminGroup.GET("/plan-limits", h.RequirePermission(auth.PermUsersManage), h.AdminListPlanLimits)
+		adminGroup.PUT("/plan-limits/:plan", h.RequirePermission(auth.PermUsersManage), h.AdminUpsertPlanLimits)
+		adminGroup.GET("/ssh/certificates", h.RequirePermission(auth.PermSSHManage), h.AdminListSSHCertificates)
+		adminGroup.POST("/ssh/certificates/:serial/revoke", h.RequirePermission(auth.PermSSHManage), h.AdminRevokeSSHCertificate)
+		adminGroup.POST("/users/:id/ssh-certificates/revoke", h.RequirePermission(auth.PermSSHManage), h.AdminRevokeUserSSHCertificates)
+		adminGroup.GET("/idle/policies", h.RequirePermission(auth.PermIdleManage), h.AdminListIdlePolicies)
+		adminGroup.PUT("/idle/policies/:plan", h.RequirePermission(auth.PermIdleManage), h.AdminUpsertIdlePolicy)
+		adminGroup.DELETE("/idle/policies/:plan", h.RequirePermission(auth.PermIdleManage), h.AdminDeleteIdlePolicy)
+		adminGroup.GET("/idle/exemptions", h.RequirePermission(auth.PermIdleManage), h.AdminListIdleExemptions)
+		adminGroup.POST("/idle/exemptions", h.RequirePermission(auth.PermIdleManage), h.AdminCreateIdleExemption)
+		adminGroup.DELETE("/idle/exemptions/:user_id", h.RequirePermission(auth.PermIdleManage), h.AdminDeleteIdleExemption)
+		adminGroup.GET("/audit", h.RequirePermission(auth.PermAuditRead), h.AdminListAudit)
+		adminGroup.GET("/audit/export.csv", h.RequirePermission(auth.PermAuditRead), h.AdminExportAuditCSV)
+		adminGroup.GET("/roles", h.RequirePermission(auth.PermRolesManage), h.AdminListRoles)
+		adminGroup.POST("/users/:id/roles", h.RequirePermission(auth.PermRolesManage), h.AdminGrantRole)
+		adminGroup.DELETE("/users/:id/roles/:role", h.RequirePermission(auth.PermRolesManage), h.AdminRevokeRole)
 	}
 
     cliGroup := r.Group("/cli")
@@ -488,6 +490,9 @@ func setupRouter(authService *auth.Service, db *database.DB) *gin.Engine {
         cliGroup.POST("/container/:action", h.CLIContainerControl)
         cliGroup.GET("/container/ports", h.CLIContainerPorts)
         cliGroup.POST("/container/ports/new", h.CLIContainerNewPort)
+        cliGroup.POST("/ssh/certificate", h.CLIIssueSSHCertificate)
+        cliGroup.GET("/jobs", h.CLIListJobs)
+        cliGroup.GET("/jobs/:id/events", h.CLIJobEvents)
     }
 
 	apiGroup := r.Group("/api")
@@ -0,0 +1,42 @@
+package master
+
+import (
+	"log"
+
+	"github.com/den/internal/database"
+	"github.com/den/internal/dns"
+)
+
+// liftExpiredSuspensions lifts timed suspensions once they run out and puts
+// the user's routes back. It runs as the suspension_expiry job.
+func liftExpiredSuspensions(db *database.DB, dnsService *dns.Service) error {
+	rows, err := db.Query(`
+		UPDATE users SET suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL, suspended_by = NULL, updated_at = NOW()
+		WHERE suspended_at IS NOT NULL AND suspended_until <= NOW()
+		RETURNING id, username
+	`)
+	if err != nil { return err }
+	type lifted struct {
+		id       int
+		username string
+	}
+	var users []lifted
+	for rows.Next() {
+		var u lifted
+		if err := rows.Scan(&u.id, &u.username); err == nil { users = append(users, u) }
+	}
+	rows.Close()
+
+	for _, u := range users {
+		log.Printf("suspension for %s expired, restoring access", u.username)
+		if err := dnsService.RestoreUserRoutes(db.DB, u.id); err != nil {
+			log.Printf("failed to restore routes for %s: %v", u.username, err)
+		}
+		_, err := db.Exec(`
+			INSERT INTO audit_log (action, target_type, target_id, changes)
+			VALUES ('user.unsuspend', 'user', $1, '{"reason": "suspension expired"}')
+		`, u.id)
+		if err != nil { log.Printf("audit: failed to record expiry for %s: %v", u.username, err) }
+	}
+	return nil
+}
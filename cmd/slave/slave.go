@@ -393,6 +393,7 @@ func (s *Slave) handleCreateContainer(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		UserID   int    `json:"user_id"`
 		Username string `json:"username"`
+		Stream   bool   `json:"stream"`
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -401,6 +402,10 @@ func (s *Slave) handleCreateContainer(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	log.Printf("create:start user=%d username=%s", req.UserID, req.Username)
+	if req.Stream {
+		s.streamCreateContainer(w, req.UserID, req.Username)
+		return
+	}
 	container, err := s.manager.CreateContainer(req.UserID, req.Username)
 	if err != nil {
 		opCreateTotal.WithLabelValues("fail").Inc()
@@ -413,6 +418,32 @@ func (s *Slave) handleCreateContainer(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(container)
 }
 
+// streamCreateContainer answers a create as newline-delimited JSON: a
+// {"step","message"} line per finished step, then {"container": ...} or
+// {"error": ...}. The status is always 200 since it is sent before the work.
+func (s *Slave) streamCreateContainer(w http.ResponseWriter, userID int, username string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	send := func(v interface{}) {
+		enc.Encode(v)
+		if flusher != nil { flusher.Flush() }
+	}
+	container, err := s.manager.CreateContainerWithProgress(userID, username, func(step, message string) {
+		send(map[string]string{"step": step, "message": message})
+	})
+	if err != nil {
+		opCreateTotal.WithLabelValues("fail").Inc()
+		log.Printf("create:fail user=%d username=%s error=%v", userID, username, err)
+		send(map[string]string{"error": err.Error()})
+		return
+	}
+	opCreateTotal.WithLabelValues("success").Inc()
+	log.Printf("create:done user=%d username=%s id=%s ip=%s", userID, username, container.ID, container.IP)
+	send(map[string]interface{}{"container": container})
+}
+
 func (s *Slave) handleContainerOperations(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 4 {
@@ -627,11 +658,16 @@ func (s *Slave) handleWriteContainerToken(w http.ResponseWriter, r *http.Request
         ContainerID string `json:"container_id"`
         Token       string `json:"token"`
         Username    string `json:"username"`
+        MasterURL   string `json:"master_url"`
     }
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
         http.Error(w, "invalid request", http.StatusBadRequest)
         return
     }
+    // masters from before master_url was sent: fall back to the one this node registers with
+    if strings.TrimSpace(req.MasterURL) == "" {
+        req.MasterURL = s.config.MasterURL
+    }
     if strings.TrimSpace(req.ContainerID) == "" || strings.TrimSpace(req.Token) == "" {
         http.Error(w, "missing fields", http.StatusBadRequest)
         return
@@ -656,6 +692,15 @@ func (s *Slave) handleWriteContainerToken(w http.ResponseWriter, r *http.Request
         http.Error(w, "write token failed", http.StatusInternalServerError)
         return
     }
+    if masterURL := strings.TrimSpace(req.MasterURL); masterURL != "" {
+        safeURL := strings.ReplaceAll(masterURL, "'", "'\\''")
+        urlCmd := exec.Command("lxc", "exec", req.ContainerID, "--", "bash", "-lc", fmt.Sprintf("set -euo pipefail; printf '%%s' '%s' > /etc/den/master_url; chmod 644 /etc/den/master_url", safeURL))
+        if out, err := urlCmd.CombinedOutput(); err != nil {
+            log.Printf("cli:write_master_url_fail id=%s err=%v out=%q", req.ContainerID, err, string(out))
+            http.Error(w, "write master url failed", http.StatusInternalServerError)
+            return
+        }
+    }
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]any{"ok": true})
 }
@@ -10,6 +10,7 @@ require (
 	github.com/minio/minio-go/v7 v7.0.64
 	github.com/prometheus/client_golang v1.17.0
 	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.17.0
 )
 
 require (
@@ -49,7 +50,6 @@ require (
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
@@ -4,14 +4,8 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/den/internal/database"
 	"github.com/den/internal/models"
@@ -20,219 +14,59 @@ import (
 )
 
 type Service struct {
-	db                 *database.DB
-	githubClientID     string
-	githubClientSecret string
-	baseURL             string
-}
-
-type GitHubUser struct {
-	ID    int     `json:"id"`
-	Login string  `json:"login"`
-	Name  *string `json:"name"`
-	Email *string `json:"email"`
-}
-
-type githubTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	Scope       string `json:"scope"`
-}
-
-type githubEmail struct {
-	Email    string `json:"email"`
-	Primary  bool   `json:"primary"`
-	Verified bool   `json:"verified"`
+	db        *database.DB
+	baseURL   string
+	providers map[string]Provider
+	// providerOrder is the order providers are shown on the login page
+	providerOrder []string
 }
 
 func NewService(db *database.DB) *Service {
-	return &Service{
-		db:                db,
-		githubClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		githubClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-		baseURL:           getEnvDefault("BASE_URL", "http://localhost:8080"),
-	}
-}
-func (s *Service) GetAuthURL(state string) string {
-	params := url.Values{}
-	params.Add("client_id", s.githubClientID)
-	params.Add("scope", "read:user user:email")
-	params.Add("redirect_uri", s.baseURL+"/auth/callback")
-	params.Add("state", state)
-	params.Add("allow_signup", "true")
-	return "https://github.com/login/oauth/authorize?" + params.Encode()
-}
-
-func (s *Service) HandleCallback(code string) (*models.User, error) {
-	token, err := s.exchangeCode(code)
-	if err != nil { return nil, fmt.Errorf("failed to exchange code: %w", err) }
-	ghUser, err := s.getGitHubUser(token)
-	if err != nil { return nil, fmt.Errorf("failed to get user info: %w", err) }
-	user, err := s.createOrUpdateUser(ghUser)
-	if err != nil { return nil, fmt.Errorf("failed to create/update user: %w", err) }
-	return user, nil
-}
-
-func (s *Service) exchangeCode(code string) (string, error) {
-	data := url.Values{}
-	data.Set("client_id", s.githubClientID)
-	data.Set("client_secret", s.githubClientSecret)
-	data.Set("code", code)
-	data.Set("redirect_uri", s.baseURL+"/auth/callback")
-
-	req, err := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(data.Encode()))
-	if err != nil { return "", err }
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil { return "", err }
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil { return "", err }
-	var tr githubTokenResponse
-	if err := json.Unmarshal(body, &tr); err != nil { return "", err }
-	if tr.AccessToken == "" { return "", fmt.Errorf("github auth failed: empty access token") }
-	return tr.AccessToken, nil
-}
-
-func (s *Service) getGitHubUser(token string) (*GitHubUser, error) {
-	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
-	if err != nil { return nil, err }
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.github+json")
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil { return nil, err }
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil { return nil, err }
-	var gh GitHubUser
-	if err := json.Unmarshal(body, &gh); err != nil { return nil, err }
-	if gh.Email == nil || *gh.Email == "" {
-		req2, err := http.NewRequest("GET", "https://api.github.com/user/emails", nil)
-		if err == nil {
-			req2.Header.Set("Authorization", "Bearer "+token)
-			req2.Header.Set("Accept", "application/vnd.github+json")
-			resp2, err2 := client.Do(req2)
-			if err2 == nil {
-				defer resp2.Body.Close()
-				eb, _ := io.ReadAll(resp2.Body)
-				var emails []githubEmail
-				if err := json.Unmarshal(eb, &emails); err == nil {
-					for _, e := range emails {
-						if e.Primary && e.Verified { em := e.Email; gh.Email = &em; break }
-					}
-					if gh.Email == nil && len(emails) > 0 { em := emails[0].Email; gh.Email = &em }
-				}
-			}
-		}
+	s := &Service{
+		db:        db,
+		baseURL:   strings.TrimRight(getEnvDefault("BASE_URL", "http://localhost:8080"), "/"),
+		providers: map[string]Provider{},
 	}
-	return &gh, nil
-}
-
-func (s *Service) createOrUpdateUser(ghUser *GitHubUser) (*models.User, error) {
-	var user models.User
-	var tosQuestions pq.Int64Array
-	err := s.db.QueryRow(`
-		SELECT id, github_id, username, email, display_name, is_admin, container_id,
-		       ssh_public_key, agreed_to_tos, agreed_to_privacy, tos_questions, 
-		       approval_status, approved_by, approved_at, rejection_reason, created_at, updated_at
-		FROM users WHERE github_id = $1
-	`, fmt.Sprintf("%d", ghUser.ID)).Scan(
-		&user.ID, &user.GitHubID, &user.Username, &user.Email, &user.DisplayName,
-		&user.IsAdmin, &user.ContainerID, &user.SSHPublicKey, &user.AgreedToTOS, &user.AgreedToPrivacy, &tosQuestions,
-		&user.ApprovalStatus, &user.ApprovedBy, &user.ApprovedAt, &user.RejectionReason, &user.CreatedAt, &user.UpdatedAt,
-	)
-	user.TOSQuestions = make([]int, len(tosQuestions))
-	for i, v := range tosQuestions { user.TOSQuestions[i] = int(v) }
-	if err == sql.ErrNoRows {
-		username := ghUser.Login
-		if username == "" { username = "user" }
-		
-		displayName := ""
-		if ghUser.Name != nil { displayName = *ghUser.Name }
-		if displayName == "" { displayName = username }
-		
-		email := ""
-		if ghUser.Email != nil { email = *ghUser.Email }
-		var tos pq.Int64Array
-		err = s.db.QueryRow(`
-			INSERT INTO users (github_id, username, email, display_name, approval_status)
-			VALUES ($1, $2, $3, $4, 'pending')
-			RETURNING id, github_id, username, email, display_name, is_admin, container_id,
-			          ssh_public_key, agreed_to_tos, agreed_to_privacy, tos_questions,
-			          approval_status, approved_by, approved_at, rejection_reason, created_at, updated_at
-		`, fmt.Sprintf("%d", ghUser.ID), username, email, displayName).Scan(
-			&user.ID, &user.GitHubID, &user.Username, &user.Email, &user.DisplayName,
-			&user.IsAdmin, &user.ContainerID, &user.SSHPublicKey, &user.AgreedToTOS, &user.AgreedToPrivacy, &tos,
-			&user.ApprovalStatus, &user.ApprovedBy, &user.ApprovedAt, &user.RejectionReason, &user.CreatedAt, &user.UpdatedAt,
-		)
-		user.TOSQuestions = make([]int, len(tos))
-		for i, v := range tos { user.TOSQuestions[i] = int(v) }
-		if err != nil { return nil, err }
-	} else if err != nil {
-		return nil, err
-	} else {
-		displayName := ""
-		if ghUser.Name != nil { displayName = *ghUser.Name }
-		if displayName == "" { displayName = ghUser.Login }
-		email := ""
-		if ghUser.Email != nil { email = *ghUser.Email }
-		_, err = s.db.Exec(`
-			UPDATE users SET email = $1, display_name = $2, updated_at = NOW()
-			WHERE id = $3
-		`, email, displayName, user.ID)
-		if err != nil { return nil, err }
-		user.Email = email
-		user.DisplayName = displayName
+	s.registerProvider(&githubProvider{
+		clientID:     os.Getenv("GITHUB_CLIENT_ID"),
+		clientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+		redirectURL:  s.baseURL + "/auth/callback",
+	})
+	for _, cfg := range oidcConfigsFromEnv(s.baseURL) {
+		s.registerProvider(NewOIDCProvider(cfg))
 	}
-	return &user, nil
+	return s
 }
 
-func (s *Service) CreateSession(userID int) (string, error) {
-	sessionID := generateSessionID()
-	expiresAt := time.Now().Add(24 * time.Hour * 7)
-	_, err := s.db.Exec(`
-		INSERT INTO sessions (id, user_id, expires_at)
-		VALUES ($1, $2, $3)
-	`, sessionID, userID, expiresAt)
-
-	if err != nil {
-		return "", err
-	}
-
-	return sessionID, nil
-}
+// userColumns and scanUser keep every query that loads a full user (by
+// session, by access token, ...) in sync.
+const userColumns = `u.id, u.github_id, u.username, u.email, u.display_name, u.is_admin,
+		       u.container_id, u.ssh_public_key, u.agreed_to_tos, u.agreed_to_privacy, u.tos_questions,
+		       u.approval_status, u.approved_by, u.approved_at, u.rejection_reason, u.wake_on_connect,
+		       u.suspended_at, u.suspended_until, u.suspension_reason,
+		       EXISTS(SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.enabled_at IS NOT NULL),
+		       ARRAY(SELECT r.role FROM user_roles r WHERE r.user_id = u.id ORDER BY r.role),
+		       u.created_at, u.updated_at`
 
-func (s *Service) GetUserBySession(sessionID string) (*models.User, error) {
+func scanUser(row *sql.Row, extra ...interface{}) (*models.User, error) {
 	var user models.User
 	var tosQ pq.Int64Array
-	err := s.db.QueryRow(`
-		SELECT u.id, u.github_id, u.username, u.email, u.display_name, u.is_admin,
-		       u.container_id, u.ssh_public_key, u.agreed_to_tos, u.agreed_to_privacy, u.tos_questions,
-		       u.approval_status, u.approved_by, u.approved_at, u.rejection_reason,
-		       u.created_at, u.updated_at
-		FROM users u
-		JOIN sessions s ON u.id = s.user_id
-		WHERE s.id = $1 AND s.expires_at > NOW()
-	`, sessionID).Scan(
+	var roles pq.StringArray
+	dest := []interface{}{
 		&user.ID, &user.GitHubID, &user.Username, &user.Email, &user.DisplayName,
 		&user.IsAdmin, &user.ContainerID, &user.SSHPublicKey, &user.AgreedToTOS, &user.AgreedToPrivacy, &tosQ,
-		&user.ApprovalStatus, &user.ApprovedBy, &user.ApprovedAt, &user.RejectionReason,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
+		&user.ApprovalStatus, &user.ApprovedBy, &user.ApprovedAt, &user.RejectionReason, &user.WakeOnConnect,
+		&user.SuspendedAt, &user.SuspendedUntil, &user.SuspensionReason,
+		&user.TOTPEnabled,
+		&roles, &user.CreatedAt, &user.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil { return nil, err }
+	user.Roles = []string(roles)
 	user.TOSQuestions = make([]int, len(tosQ))
 	for i, v := range tosQ { user.TOSQuestions[i] = int(v) }
-	if err != nil { return nil, err }
 	return &user, nil
 }
 
-func (s *Service) DeleteSession(sessionID string) error {
-	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = $1`, sessionID)
-	return err
-}
-
 func (s *Service) SetSSHPassword(userID int, password string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
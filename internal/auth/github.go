@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type GitHubUser struct {
+	ID    int     `json:"id"`
+	Login string  `json:"login"`
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+}
+
+type githubTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// githubProvider is plain OAuth, not OIDC, so it ignores the nonce. Its
+// callback stays at /auth/callback because that's what the GitHub app is
+// registered with.
+type githubProvider struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+}
+
+func (p *githubProvider) Name() string        { return "github" }
+func (p *githubProvider) DisplayName() string { return "GitHub" }
+
+func (p *githubProvider) AuthURL(ctx context.Context, state, verifier, nonce string) (string, error) {
+	params := url.Values{}
+	params.Add("client_id", p.clientID)
+	params.Add("scope", "read:user user:email")
+	params.Add("redirect_uri", p.redirectURL)
+	params.Add("state", state)
+	params.Add("allow_signup", "true")
+	params.Add("code_challenge", pkceChallenge(verifier))
+	params.Add("code_challenge_method", "S256")
+	return "https://github.com/login/oauth/authorize?" + params.Encode(), nil
+}
+
+func (p *githubProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*ExternalIdentity, error) {
+	token, err := p.exchangeCode(ctx, code, verifier)
+	if err != nil { return nil, fmt.Errorf("failed to exchange code: %w", err) }
+	gh, err := p.getGitHubUser(ctx, token)
+	if err != nil { return nil, fmt.Errorf("failed to get user info: %w", err) }
+	if gh.ID == 0 { return nil, fmt.Errorf("github returned no user id") }
+	ext := &ExternalIdentity{
+		Provider: p.Name(),
+		Subject:  fmt.Sprintf("%d", gh.ID),
+		Username: gh.Login,
+	}
+	if gh.Name != nil { ext.DisplayName = *gh.Name }
+	if gh.Email != nil { ext.Email = *gh.Email }
+	return ext, nil
+}
+
+func (p *githubProvider) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	data := url.Values{}
+	data.Set("client_id", p.clientID)
+	data.Set("client_secret", p.clientSecret)
+	data.Set("code", code)
+	data.Set("redirect_uri", p.redirectURL)
+	data.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://github.com/login/oauth/access_token", strings.NewReader(data.Encode()))
+	if err != nil { return "", err }
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil { return "", err }
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil { return "", err }
+	var tr githubTokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil { return "", err }
+	if tr.AccessToken == "" { return "", fmt.Errorf("github auth failed: empty access token") }
+	return tr.AccessToken, nil
+}
+
+func (p *githubProvider) getGitHubUser(ctx context.Context, token string) (*GitHubUser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user", nil)
+	if err != nil { return nil, err }
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil { return nil, err }
+	var gh GitHubUser
+	if err := json.Unmarshal(body, &gh); err != nil { return nil, err }
+	if gh.Email == nil || *gh.Email == "" {
+		req2, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user/emails", nil)
+		if err == nil {
+			req2.Header.Set("Authorization", "Bearer "+token)
+			req2.Header.Set("Accept", "application/vnd.github+json")
+			resp2, err2 := client.Do(req2)
+			if err2 == nil {
+				defer resp2.Body.Close()
+				eb, _ := io.ReadAll(resp2.Body)
+				var emails []githubEmail
+				if err := json.Unmarshal(eb, &emails); err == nil {
+					for _, e := range emails {
+						if e.Primary && e.Verified { em := e.Email; gh.Email = &em; break }
+					}
+					if gh.Email == nil && len(emails) > 0 { em := emails[0].Email; gh.Email = &em }
+				}
+			}
+		}
+	}
+	return &gh, nil
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/den/internal/models"
+)
+
+var (
+	ErrIdentityInUse = errors.New("that account is already linked to another den user")
+	ErrLastIdentity  = errors.New("you can't unlink your only login method")
+)
+
+const maxUsernameLength = 50
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// LoginWithIdentity returns the user linked to ext, creating a pending user
+// the first time an identity is seen. Profile fields are only refreshed from
+// the user's oldest identity so logging in through a second provider doesn't
+// keep overwriting them.
+func (s *Service) LoginWithIdentity(ext *ExternalIdentity) (*models.User, error) {
+	var identityID, userID int
+	err := s.db.QueryRow(`SELECT id, user_id FROM identities WHERE provider = $1 AND subject = $2`, ext.Provider, ext.Subject).Scan(&identityID, &userID)
+	if err == sql.ErrNoRows {
+		return s.createUserWithIdentity(ext)
+	}
+	if err != nil { return nil, err }
+
+	_, _ = s.db.Exec(`UPDATE identities SET email = NULLIF($2, ''), last_login_at = NOW() WHERE id = $1`, identityID, ext.Email)
+
+	var oldestID int
+	if err := s.db.QueryRow(`SELECT id FROM identities WHERE user_id = $1 ORDER BY created_at, id LIMIT 1`, userID).Scan(&oldestID); err != nil {
+		return nil, err
+	}
+	if oldestID == identityID {
+		displayName := ext.DisplayName
+		if displayName == "" { displayName = ext.Username }
+		_, err = s.db.Exec(`
+			UPDATE users SET email = COALESCE(NULLIF($1, ''), email), display_name = COALESCE(NULLIF($2, ''), display_name), updated_at = NOW()
+			WHERE id = $3
+		`, ext.Email, displayName, userID)
+		if err != nil { return nil, err }
+	}
+	return s.GetUserByID(userID)
+}
+
+func (s *Service) createUserWithIdentity(ext *ExternalIdentity) (*models.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil { return nil, err }
+	defer tx.Rollback()
+
+	base := ext.Username
+	if ext.Provider != "github" {
+		// github logins are already valid usernames; anything else might be an email or worse
+		base = sanitizeUsername(ext.Username, ext.Email)
+	}
+	if base == "" { base = "user" }
+	username, err := uniqueUsername(tx, base)
+	if err != nil { return nil, err }
+
+	displayName := ext.DisplayName
+	if displayName == "" { displayName = username }
+	var githubID *string
+	if ext.Provider == "github" { githubID = &ext.Subject }
+
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO users (github_id, username, email, display_name, approval_status)
+		VALUES ($1, $2, $3, $4, 'pending')
+		RETURNING id
+	`, githubID, username, ext.Email, displayName).Scan(&userID)
+	if err != nil { return nil, err }
+	_, err = tx.Exec(`
+		INSERT INTO identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NOW())
+	`, userID, ext.Provider, ext.Subject, ext.Email)
+	if err != nil { return nil, err }
+	if err := tx.Commit(); err != nil { return nil, err }
+	return s.GetUserByID(userID)
+}
+
+// LinkIdentity attaches ext to an existing user. Linking an identity the
+// user already owns is a no-op.
+func (s *Service) LinkIdentity(userID int, ext *ExternalIdentity) error {
+	var owner int
+	err := s.db.QueryRow(`SELECT user_id FROM identities WHERE provider = $1 AND subject = $2`, ext.Provider, ext.Subject).Scan(&owner)
+	if err == nil {
+		if owner != userID { return ErrIdentityInUse }
+		return nil
+	}
+	if err != sql.ErrNoRows { return err }
+	_, err = s.db.Exec(`
+		INSERT INTO identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+	`, userID, ext.Provider, ext.Subject, ext.Email)
+	if err != nil { return err }
+	if ext.Provider == "github" {
+		_, _ = s.db.Exec(`UPDATE users SET github_id = $1 WHERE id = $2 AND github_id IS NULL`, ext.Subject, userID)
+	}
+	return nil
+}
+
+func (s *Service) ListIdentities(userID int) ([]models.Identity, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM identities WHERE user_id = $1 ORDER BY created_at, id
+	`, userID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	identities := []models.Identity{}
+	for rows.Next() {
+		var i models.Identity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt); err != nil { return nil, err }
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// UnlinkIdentity removes one of the user's identities, refusing to remove
+// the last one since that would leave no way to log in.
+func (s *Service) UnlinkIdentity(userID, identityID int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil { return false, err }
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, provider, subject FROM identities WHERE user_id = $1 FOR UPDATE`, userID)
+	if err != nil { return false, err }
+	var provider, subject string
+	count, found := 0, false
+	for rows.Next() {
+		var id int
+		var p, sub string
+		if err := rows.Scan(&id, &p, &sub); err != nil { rows.Close(); return false, err }
+		count++
+		if id == identityID { found, provider, subject = true, p, sub }
+	}
+	rows.Close()
+	if !found { return false, nil }
+	if count <= 1 { return false, ErrLastIdentity }
+
+	if _, err := tx.Exec(`DELETE FROM identities WHERE id = $1`, identityID); err != nil { return false, err }
+	if provider == "github" {
+		if _, err := tx.Exec(`UPDATE users SET github_id = NULL WHERE id = $1 AND github_id = $2`, userID, subject); err != nil { return false, err }
+	}
+	return true, tx.Commit()
+}
+
+func (s *Service) GetUserByID(userID int) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users u WHERE u.id = $1`, userID))
+}
+
+// sanitizeUsername turns a preferred username, or failing that the local
+// part of an email, into something usable as a unix login.
+func sanitizeUsername(preferred, email string) string {
+	candidate := preferred
+	if candidate == "" {
+		candidate = email
+	}
+	if at := strings.Index(candidate, "@"); at >= 0 {
+		candidate = candidate[:at]
+	}
+	candidate = usernameInvalidChars.ReplaceAllString(strings.ToLower(candidate), "")
+	candidate = strings.TrimLeft(candidate, "0123456789-")
+	if len(candidate) > maxUsernameLength-4 {
+		candidate = candidate[:maxUsernameLength-4]
+	}
+	return candidate
+}
+
+func uniqueUsername(tx *sql.Tx, base string) (string, error) {
+	for i := 1; i < 1000; i++ {
+		name := base
+		if i > 1 { name = fmt.Sprintf("%s%d", base, i) }
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`, name).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists { return name, nil }
+	}
+	return "", fmt.Errorf("could not find a free username for %q", base)
+}
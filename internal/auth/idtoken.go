@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tolerated difference between our clock and the provider's
+	idTokenClockSkew = 2 * time.Minute
+	// unknown key ids trigger a refetch, but not more often than this
+	jwksMinRefresh = time.Minute
+)
+
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type idTokenClaims struct {
+	Issuer            string    `json:"iss"`
+	Subject           string    `json:"sub"`
+	Audience          audience  `json:"aud"`
+	AuthorizedParty   string    `json:"azp"`
+	Expiry            int64     `json:"exp"`
+	IssuedAt          int64     `json:"iat"`
+	NotBefore         int64     `json:"nbf"`
+	Nonce             string    `json:"nonce"`
+	Email             string    `json:"email"`
+	EmailVerified     *flexBool `json:"email_verified"`
+	Name              string    `json:"name"`
+	PreferredUsername string    `json:"preferred_username"`
+}
+
+// audience is either a single string or an array in the wild.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// flexBool accepts true and "true"; some providers send booleans as strings.
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	var v bool
+	if err := json.Unmarshal(b, &v); err == nil {
+		*f = flexBool(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*f = flexBool(s == "true")
+	return nil
+}
+
+func (p *oidcProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string) (*idTokenClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	var header idTokenHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed header")
+	}
+
+	key, err := p.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims idTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed claims")
+	}
+	now := time.Now()
+	if strings.TrimRight(claims.Issuer, "/") != strings.TrimRight(d.Issuer, "/") {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("missing subject")
+	}
+	found := false
+	for _, aud := range claims.Audience {
+		if aud == p.cfg.ClientID {
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.New("token was not issued for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, errors.New("token was not issued for this client")
+	}
+	if claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(idTokenClockSkew)) {
+		return nil, errors.New("token has expired")
+	}
+	if claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(idTokenClockSkew)) {
+		return nil, errors.New("token was issued in the future")
+	}
+	if claims.NotBefore != 0 && time.Unix(claims.NotBefore, 0).After(now.Add(idTokenClockSkew)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	return &claims, nil
+}
+
+// verifyJWTSignature only knows the asymmetric algorithms OIDC providers
+// actually use. "none" and the HMAC family are rejected outright.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match RS256")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("bad signature")
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errors.New("key type does not match ES256")
+		}
+		if len(sig) != 64 {
+			return errors.New("bad signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// jwks caches a provider's signing keys by key id.
+type jwks struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	url       string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWKS(client *http.Client) *jwks {
+	return &jwks{client: client, keys: map[string]crypto.PublicKey{}}
+}
+
+func (k *jwks) setURL(u string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.url != u {
+		k.url = u
+		k.keys = map[string]crypto.PublicKey{}
+		k.fetchedAt = time.Time{}
+	}
+}
+
+func (k *jwks) get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	if time.Since(k.fetchedAt) < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := k.fetch(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup allows an empty kid only when the set has exactly one key.
+func (k *jwks) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwks) fetch(ctx context.Context) error {
+	if k.url == "" {
+		return errors.New("no jwks url")
+	}
+	k.fetchedAt = time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", k.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signing keys endpoint returned %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("invalid signing keys: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	k.keys = keys
+	return nil
+}
+
+func parseJWK(jwk jsonWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 || exp.Int64() < 3 {
+			return nil, errors.New("bad rsa exponent")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("rsa key too small")
+		}
+		return pub, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const discoveryCacheTTL = time.Hour
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// OIDCConfig describes one OpenID Connect provider.
+type OIDCConfig struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+}
+
+// oidcConfigsFromEnv reads OIDC_PROVIDERS, a comma separated list of names,
+// and for each name OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optionally _DISPLAY_NAME and _SCOPES. Dashes in names become underscores
+// in the variable names.
+func oidcConfigsFromEnv(baseURL string) []OIDCConfig {
+	var configs []OIDCConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" { continue }
+		if !providerNamePattern.MatchString(name) || name == "github" || name == "stub" || name == "callback" {
+			log.Printf("ignoring oidc provider with invalid name %q", name)
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := OIDCConfig{
+			Name:         name,
+			DisplayName:  getEnvDefault(prefix+"DISPLAY_NAME", name),
+			Issuer:       strings.TrimSpace(os.Getenv(prefix + "ISSUER")),
+			ClientID:     strings.TrimSpace(os.Getenv(prefix + "CLIENT_ID")),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  baseURL + "/auth/" + name + "/callback",
+		}
+		if scopes := strings.Fields(strings.ReplaceAll(os.Getenv(prefix+"SCOPES"), ",", " ")); len(scopes) > 0 {
+			cfg.Scopes = scopes
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			log.Printf("ignoring oidc provider %q: %sISSUER and %sCLIENT_ID are required", name, prefix, prefix)
+			continue
+		}
+		configs = append(configs, cfg)
+	}
+	return configs
+}
+
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// oidcProvider implements the authorization code flow with PKCE. Discovery
+// and signing keys are fetched lazily so a provider that's briefly down
+// doesn't stop the master from starting.
+type oidcProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	discoveredAt time.Time
+	keys         *jwks
+}
+
+func NewOIDCProvider(cfg OIDCConfig) Provider {
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	hasOpenID := false
+	for _, s := range cfg.Scopes {
+		if s == "openid" { hasOpenID = true }
+	}
+	if !hasOpenID {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &oidcProvider{cfg: cfg, client: client, keys: newJWKS(client)}
+}
+
+func (p *oidcProvider) Name() string        { return p.cfg.Name }
+func (p *oidcProvider) DisplayName() string { return p.cfg.DisplayName }
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryCacheTTL {
+		return p.discovery, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil { return nil, err }
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if p.discovery != nil { return p.discovery, nil }
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if p.discovery != nil { return p.discovery, nil }
+		return nil, fmt.Errorf("oidc discovery returned %d", resp.StatusCode)
+	}
+	var d oidcDiscovery
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&d); err != nil {
+		return nil, fmt.Errorf("invalid oidc discovery document: %w", err)
+	}
+	// the document must describe the issuer we were configured with, otherwise
+	// anyone who can serve that URL could mint tokens for another issuer
+	if strings.TrimRight(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery issuer mismatch: got %q, want %q", d.Issuer, p.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery document is missing endpoints")
+	}
+	p.discovery = &d
+	p.discoveredAt = time.Now()
+	p.keys.setURL(d.JWKSURI)
+	return &d, nil
+}
+
+func (p *oidcProvider) AuthURL(ctx context.Context, state, verifier, nonce string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil { return "", err }
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") { sep = "&" }
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*ExternalIdentity, error) {
+	d, err := p.discover(ctx)
+	if err != nil { return nil, err }
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	useBasic := p.cfg.ClientSecret != ""
+	if useBasic && len(d.TokenAuthMethods) > 0 {
+		useBasic = false
+		for _, m := range d.TokenAuthMethods {
+			if m == "client_secret_basic" { useBasic = true }
+		}
+		if !useBasic { form.Set("client_secret", p.cfg.ClientSecret) }
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil { return nil, err }
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil { return nil, fmt.Errorf("token request failed: %w", err) }
+	defer resp.Body.Close()
+	var tr oidcTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("invalid token response (status %d): %w", resp.StatusCode, err)
+	}
+	if tr.Error != "" { return nil, fmt.Errorf("token request failed: %s %s", tr.Error, tr.ErrorDescription) }
+	if tr.IDToken == "" { return nil, fmt.Errorf("token response has no id_token") }
+
+	claims, err := p.verifyIDToken(ctx, d, tr.IDToken, nonce)
+	if err != nil { return nil, fmt.Errorf("invalid id token: %w", err) }
+
+	ext := &ExternalIdentity{
+		Provider:    p.cfg.Name,
+		Subject:     claims.Subject,
+		Username:    claims.PreferredUsername,
+		DisplayName: claims.Name,
+	}
+	// an unverified email is just a string the user typed in, so don't keep it
+	if claims.Email != "" && (claims.EmailVerified == nil || *claims.EmailVerified) {
+		ext.Email = claims.Email
+	}
+	return ext, nil
+}
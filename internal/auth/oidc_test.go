@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRedirectURL = "http://den.test/auth/stub/callback"
+
+// newTestIdP starts the stub IdP and an OIDC provider configured against it.
+func newTestIdP(t *testing.T) (*stubIdP, *oidcProvider) {
+	t.Helper()
+	var idp *stubIdP
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	var err error
+	idp, err = newStubIdP(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewOIDCProvider(OIDCConfig{
+		Name:        "stub",
+		Issuer:      srv.URL,
+		ClientID:    stubClientID,
+		RedirectURL: testRedirectURL,
+	}).(*oidcProvider)
+	return idp, p
+}
+
+// authorize runs the authorization request as user and returns the code the
+// stub redirected back with.
+func authorize(t *testing.T, p *oidcProvider, state, verifier, nonce, user string) string {
+	t.Helper()
+	authURL, err := p.AuthURL(context.Background(), state, verifier, nonce)
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	q.Set("login_hint", user)
+	q.Set("email", user+"@example.com")
+	u.RawQuery = q.Encode()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %d, want 302", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), testRedirectURL) {
+		t.Fatalf("redirected to %s, want %s", loc, testRedirectURL)
+	}
+	if got := loc.Query().Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+	code := loc.Query().Get("code")
+	if code == "" {
+		t.Fatal("no code in redirect")
+	}
+	return code
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	_, p := newTestIdP(t)
+	d, err := p.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Issuer != p.cfg.Issuer || d.TokenEndpoint != p.cfg.Issuer+"/token" || d.JWKSURI != p.cfg.Issuer+"/jwks" {
+		t.Fatalf("unexpected discovery document %+v", d)
+	}
+
+	authURL, err := p.AuthURL(context.Background(), "st", "verifier", "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if q.Get("code_challenge") != pkceChallenge("verifier") || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("auth url is missing pkce: %s", authURL)
+	}
+	if !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("scope %q is missing openid", q.Get("scope"))
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 "https://evil.example.com",
+			"authorization_endpoint": "https://evil.example.com/authorize",
+			"token_endpoint":         "https://evil.example.com/token",
+			"jwks_uri":               "https://evil.example.com/jwks",
+		})
+	}))
+	defer srv.Close()
+	p := NewOIDCProvider(OIDCConfig{Name: "x", Issuer: srv.URL, ClientID: "c"}).(*oidcProvider)
+	if _, err := p.discover(context.Background()); err == nil {
+		t.Fatal("discovery accepted a document for another issuer")
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	_, p := newTestIdP(t)
+	ctx := context.Background()
+	code := authorize(t, p, "state-1", "verifier-1", "nonce-1", "alice")
+
+	ext, err := p.Exchange(ctx, code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if ext.Provider != "stub" || ext.Subject != "stub|alice" || ext.Username != "alice" || ext.Email != "alice@example.com" {
+		t.Fatalf("unexpected identity %+v", ext)
+	}
+
+	// codes are single use
+	if _, err := p.Exchange(ctx, code, "verifier-1", "nonce-1"); err == nil {
+		t.Fatal("a code was accepted twice")
+	}
+}
+
+func TestOIDCExchangeRejects(t *testing.T) {
+	_, p := newTestIdP(t)
+	ctx := context.Background()
+
+	code := authorize(t, p, "s", "right-verifier", "n", "bob")
+	if _, err := p.Exchange(ctx, code, "wrong-verifier", "n"); err == nil {
+		t.Error("exchange accepted the wrong pkce verifier")
+	}
+
+	code = authorize(t, p, "s", "v", "nonce-a", "bob")
+	_, err := p.Exchange(ctx, code, "v", "nonce-b")
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("exchange with another nonce: err = %v, want nonce mismatch", err)
+	}
+
+	if _, err := p.Exchange(ctx, "made-up", "v", "n"); err == nil {
+		t.Error("exchange accepted an unknown code")
+	}
+}
+
+func TestStubIdPRequiresPKCE(t *testing.T) {
+	_, p := newTestIdP(t)
+	q := url.Values{}
+	q.Set("client_id", stubClientID)
+	q.Set("response_type", "code")
+	q.Set("redirect_uri", testRedirectURL)
+	q.Set("login_hint", "carol")
+	resp, err := http.Get(p.cfg.Issuer + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("authorize without pkce returned %d, want 400", resp.StatusCode)
+	}
+}
+
+func encodeSegment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp, p := newTestIdP(t)
+	ctx := context.Background()
+	d, err := p.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   p.cfg.Issuer,
+			"sub":   "stub|dave",
+			"aud":   stubClientID,
+			"exp":   now.Add(5 * time.Minute).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "n",
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	signed := func(c map[string]interface{}) string {
+		tok, err := idp.sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	withAlg := func(alg string, c map[string]interface{}, sig func(string) []byte) string {
+		unsigned := encodeSegment(map[string]string{"alg": alg, "typ": "JWT", "kid": stubKeyID}) + "." + encodeSegment(c)
+		return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig(unsigned))
+	}
+
+	if _, err := p.verifyIDToken(ctx, d, signed(claims(nil)), "n"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"wrong aud", signed(claims(func(c map[string]interface{}) { c["aud"] = "someone-else" })), "n"},
+		{"extra aud without azp", signed(claims(func(c map[string]interface{}) { c["aud"] = []string{stubClientID, "other"} })), "n"},
+		{"wrong iss", signed(claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })), "n"},
+		{"nonce mismatch", signed(claims(nil)), "other"},
+		{"expired", signed(claims(func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() })), "n"},
+		{"no exp", signed(claims(func(c map[string]interface{}) { delete(c, "exp") })), "n"},
+		{"issued in the future", signed(claims(func(c map[string]interface{}) { c["iat"] = now.Add(time.Hour).Unix() })), "n"},
+		{"no subject", signed(claims(func(c map[string]interface{}) { delete(c, "sub") })), "n"},
+		{"alg none", withAlg("none", claims(nil), func(string) []byte { return nil }), "n"},
+		{"alg HS256", withAlg("HS256", claims(nil), func(unsigned string) []byte {
+			// signed with the public key as the HMAC secret, the classic
+			// algorithm confusion attack
+			mac := hmac.New(sha256.New, idp.key.PublicKey.N.Bytes())
+			mac.Write([]byte(unsigned))
+			return mac.Sum(nil)
+		}), "n"},
+		{"tampered claims", func() string {
+			parts := strings.Split(signed(claims(nil)), ".")
+			parts[1] = encodeSegment(claims(func(c map[string]interface{}) { c["sub"] = "stub|admin" }))
+			return strings.Join(parts, ".")
+		}(), "n"},
+		{"malformed", "not-a-jwt", "n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.verifyIDToken(ctx, d, tt.token, tt.nonce); err == nil {
+				t.Fatal("token was accepted")
+			}
+		})
+	}
+}
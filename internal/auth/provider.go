@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// ExternalIdentity is what an identity provider tells us about whoever just
+// logged in. Provider and Subject together identify the account; the rest is
+// profile data used when creating a user.
+type ExternalIdentity struct {
+	Provider    string
+	Subject     string
+	Username    string
+	Email       string
+	DisplayName string
+}
+
+// Provider is an external identity provider users can log in with.
+type Provider interface {
+	// Name is used in URLs and stored in identities.provider, so it must not change.
+	Name() string
+	DisplayName() string
+	// AuthURL returns where to send the browser. verifier is the PKCE code
+	// verifier and nonce is bound into the ID token by OIDC providers.
+	AuthURL(ctx context.Context, state, verifier, nonce string) (string, error)
+	// Exchange trades the authorization code for the identity it belongs to.
+	Exchange(ctx context.Context, code, verifier, nonce string) (*ExternalIdentity, error)
+}
+
+// ProviderInfo is the public description of a provider for the UI.
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+func (s *Service) registerProvider(p Provider) {
+	if _, exists := s.providers[p.Name()]; !exists {
+		s.providerOrder = append(s.providerOrder, p.Name())
+	}
+	s.providers[p.Name()] = p
+}
+
+func (s *Service) Provider(name string) (Provider, bool) {
+	p, ok := s.providers[name]
+	return p, ok
+}
+
+func (s *Service) Providers() []ProviderInfo {
+	out := make([]ProviderInfo, 0, len(s.providerOrder))
+	for _, name := range s.providerOrder {
+		out = append(out, ProviderInfo{Name: name, DisplayName: s.providers[name].DisplayName()})
+	}
+	return out
+}
+
+// LoginFlow is the per-attempt secret state of an authorization code flow.
+// It lives in a short-lived cookie between the redirect and the callback.
+type LoginFlow struct {
+	State    string
+	Verifier string
+	Nonce    string
+}
+
+func NewLoginFlow() LoginFlow {
+	return LoginFlow{State: randomToken(), Verifier: randomToken(), Nonce: randomToken()}
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/den/internal/models"
+)
+
+// Roles bundle permissions; routes check permissions, never roles, so a
+// role can be reshaped without touching the router.
+const (
+	RoleReviewer   = "reviewer"
+	RoleOperator   = "operator"
+	RoleSuperadmin = "superadmin"
+)
+
+const (
+	PermUsersRead        = "users:read"
+	PermUsersReview      = "users:review"
+	PermUsersManage      = "users:manage"
+	PermNodesManage      = "nodes:manage"
+	PermJobsManage       = "jobs:manage"
+	PermContainersManage = "containers:manage"
+	PermSSHManage        = "ssh:manage"
+	PermIdleManage       = "idle:manage"
+	PermRolesManage      = "roles:manage"
+	PermAuditRead        = "audit:read"
+)
+
+var AllPermissions = []string{
+	PermUsersRead, PermUsersReview, PermUsersManage, PermNodesManage, PermJobsManage,
+	PermContainersManage, PermSSHManage, PermIdleManage, PermRolesManage, PermAuditRead,
+}
+
+var rolePermissions = map[string][]string{
+	RoleReviewer:   {PermUsersRead, PermUsersReview},
+	RoleOperator:   {PermUsersRead, PermNodesManage, PermJobsManage, PermContainersManage, PermSSHManage, PermIdleManage},
+	RoleSuperadmin: AllPermissions,
+}
+
+var Roles = []string{RoleReviewer, RoleOperator, RoleSuperadmin}
+
+var ErrLastSuperadmin = errors.New("can't remove the last superadmin")
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions describes every role for the admin UI.
+func RolePermissions() map[string][]string {
+	out := make(map[string][]string, len(rolePermissions))
+	for role, perms := range rolePermissions {
+		out[role] = append([]string(nil), perms...)
+	}
+	return out
+}
+
+// Permissions is the union of the user's roles. The legacy is_admin flag
+// counts as superadmin.
+func Permissions(user *models.User) []string {
+	roles := user.Roles
+	if user.IsAdmin {
+		roles = append([]string{RoleSuperadmin}, roles...)
+	}
+	seen := map[string]bool{}
+	var perms []string
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if !seen[p] {
+				seen[p] = true
+				perms = append(perms, p)
+			}
+		}
+	}
+	return perms
+}
+
+func HasPermission(user *models.User, perm string) bool {
+	for _, p := range Permissions(user) {
+		if p == perm { return true }
+	}
+	return false
+}
+
+func (s *Service) GrantRole(userID int, role string, grantedBy int) error {
+	if !IsValidRole(role) { return fmt.Errorf("unknown role %q", role) }
+	_, err := s.db.Exec(`
+		INSERT INTO user_roles (user_id, role, granted_by) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, role) DO NOTHING
+	`, userID, role, grantedBy)
+	return err
+}
+
+// RevokeRole refuses to take superadmin away from the last person holding
+// it, counting legacy is_admin users.
+func (s *Service) RevokeRole(userID int, role string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil { return false, err }
+	defer tx.Rollback()
+	if role == RoleSuperadmin {
+		// lock the superadmin rows so two admins can't demote each other at once
+		if _, err := tx.Exec(`SELECT 1 FROM user_roles WHERE role = $1 FOR UPDATE`, RoleSuperadmin); err != nil { return false, err }
+		var others int
+		err := tx.QueryRow(`
+			SELECT COUNT(DISTINCT id) FROM users
+			WHERE id <> $1 AND (is_admin = TRUE OR id IN (SELECT user_id FROM user_roles WHERE role = $2))
+		`, userID, RoleSuperadmin).Scan(&others)
+		if err != nil { return false, err }
+		if others == 0 { return false, ErrLastSuperadmin }
+	}
+	res, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userID, role)
+	if err != nil { return false, err }
+	n, _ := res.RowsAffected()
+	return n > 0, tx.Commit()
+}
+
+func (s *Service) ListRoleAssignments() ([]models.UserRole, error) {
+	rows, err := s.db.Query(`
+		SELECT r.user_id, u.username, r.role, r.granted_by, COALESCE(r.granted_at, NOW())
+		FROM user_roles r JOIN users u ON u.id = r.user_id
+		ORDER BY u.username, r.role
+	`)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	out := []models.UserRole{}
+	for rows.Next() {
+		var ur models.UserRole
+		if err := rows.Scan(&ur.UserID, &ur.Username, &ur.Role, &ur.GrantedBy, &ur.GrantedAt); err != nil { return nil, err }
+		out = append(out, ur)
+	}
+	return out, rows.Err()
+}
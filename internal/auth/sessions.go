@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/den/internal/models"
+)
+
+const (
+	// SessionIdleTimeout is how long a session survives without being used.
+	// Every authenticated request pushes it forward.
+	SessionIdleTimeout = 7 * 24 * time.Hour
+	// SessionMaxLifetime is the hard cap, however active the session is.
+	SessionMaxLifetime = 30 * 24 * time.Hour
+	// sessionTouchInterval limits last_seen_at writes to one per session per minute.
+	sessionTouchInterval = time.Minute
+	// pendingMFALifetime is how long a login waits for its second factor.
+	pendingMFALifetime = 10 * time.Minute
+	// maxMFAFailures wrong codes end the session.
+	maxMFAFailures = 5
+	// StepUpWindow is how long a second-factor check covers sensitive actions.
+	StepUpWindow = 10 * time.Minute
+)
+
+func (s *Service) CreateSession(userID int, userAgent, ip string) (string, error) {
+	sessionID := generateSessionID()
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (id, user_id, expires_at, absolute_expires_at, user_agent, ip_address, last_seen_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+	`, sessionID, userID, now.Add(SessionIdleTimeout), now.Add(SessionMaxLifetime), truncate(userAgent, 512), ip, now)
+	if err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// CreatePendingSession starts a login that still needs its second factor.
+// The session can't authenticate anything until CompleteMFASession.
+func (s *Service) CreatePendingSession(userID int, userAgent, ip string) (string, error) {
+	sessionID := generateSessionID()
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (id, user_id, expires_at, absolute_expires_at, user_agent, ip_address, last_seen_at, mfa_pending)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, TRUE)
+	`, sessionID, userID, now.Add(pendingMFALifetime), now.Add(SessionMaxLifetime), truncate(userAgent, 512), ip, now)
+	if err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+func (s *Service) GetUserBySession(sessionID string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`
+		SELECT `+userColumns+`
+		FROM users u
+		JOIN sessions s ON u.id = s.user_id
+		WHERE s.id = $1 AND s.expires_at > NOW() AND s.absolute_expires_at > NOW() AND NOT s.mfa_pending
+	`, sessionID))
+}
+
+// GetPendingMFAUser returns the user behind a login waiting for its second factor.
+func (s *Service) GetPendingMFAUser(sessionID string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`
+		SELECT `+userColumns+`
+		FROM users u
+		JOIN sessions s ON u.id = s.user_id
+		WHERE s.id = $1 AND s.expires_at > NOW() AND s.mfa_pending
+	`, sessionID))
+}
+
+// CompleteMFASession turns a pending login into a normal session.
+func (s *Service) CompleteMFASession(sessionID string) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET mfa_pending = FALSE, mfa_failures = 0, reauthenticated_at = NOW(), last_seen_at = NOW(),
+		       expires_at = LEAST(NOW() + make_interval(secs => $2), absolute_expires_at)
+		WHERE id = $1 AND mfa_pending
+	`, sessionID, SessionIdleTimeout.Seconds())
+	return err
+}
+
+// RecordMFAFailure counts a wrong code against the session and deletes it
+// once there have been too many. It reports whether the session is gone.
+func (s *Service) RecordMFAFailure(sessionID string) bool {
+	var failures int
+	err := s.db.QueryRow(`UPDATE sessions SET mfa_failures = mfa_failures + 1 WHERE id = $1 RETURNING mfa_failures`, sessionID).Scan(&failures)
+	if err != nil { return true }
+	if failures >= maxMFAFailures {
+		_ = s.DeleteSession(sessionID)
+		return true
+	}
+	return false
+}
+
+// MarkReauthenticated records a successful step-up check on the session.
+func (s *Service) MarkReauthenticated(sessionID string) error {
+	_, err := s.db.Exec(`UPDATE sessions SET reauthenticated_at = NOW(), mfa_failures = 0 WHERE id = $1`, sessionID)
+	return err
+}
+
+func (s *Service) RecentlyReauthenticated(sessionID string) bool {
+	var ok bool
+	err := s.db.QueryRow(`
+		SELECT reauthenticated_at > NOW() - make_interval(secs => $2) FROM sessions WHERE id = $1 AND reauthenticated_at IS NOT NULL
+	`, sessionID, StepUpWindow.Seconds()).Scan(&ok)
+	return err == nil && ok
+}
+
+// TouchSession records activity on a session and slides its expiry forward,
+// never past the absolute lifetime.
+func (s *Service) TouchSession(sessionID, ip string) {
+	_, _ = s.db.Exec(`
+		UPDATE sessions
+		SET last_seen_at = NOW(), ip_address = COALESCE(NULLIF($2, ''), ip_address),
+		    expires_at = LEAST(NOW() + make_interval(secs => $3), absolute_expires_at)
+		WHERE id = $1 AND (last_seen_at IS NULL OR last_seen_at < NOW() - make_interval(secs => $4))
+	`, sessionID, ip, SessionIdleTimeout.Seconds(), sessionTouchInterval.Seconds())
+}
+
+func (s *Service) DeleteSession(sessionID string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = $1`, sessionID)
+	return err
+}
+
+// ListSessions returns the user's live sessions, newest activity first,
+// flagging the one identified by currentID.
+func (s *Service) ListSessions(userID int, currentID string) ([]models.WebSession, error) {
+	rows, err := s.db.Query(`
+		SELECT public_id, user_agent, ip_address, COALESCE(created_at, NOW()), COALESCE(last_seen_at, created_at, NOW()),
+		       expires_at, absolute_expires_at, id = $2
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > NOW() AND absolute_expires_at > NOW() AND NOT mfa_pending
+		ORDER BY last_seen_at DESC NULLS LAST
+	`, userID, currentID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	sessions := []models.WebSession{}
+	for rows.Next() {
+		var ws models.WebSession
+		if err := rows.Scan(&ws.ID, &ws.UserAgent, &ws.IPAddress, &ws.CreatedAt, &ws.LastSeenAt, &ws.ExpiresAt, &ws.AbsoluteExpiresAt, &ws.Current); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, ws)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession deletes one of the user's sessions by its public id.
+func (s *Service) RevokeSession(userID, publicID int) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = $1 AND public_id = $2`, userID, publicID)
+	if err != nil { return false, err }
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RevokeUserSessions logs the user out everywhere.
+func (s *Service) RevokeUserSessions(userID int) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+	if err != nil { return 0, err }
+	return res.RowsAffected()
+}
+
+// PurgeExpiredSessions deletes sessions past either expiry.
+func (s *Service) PurgeExpiredSessions() (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at < NOW() OR absolute_expires_at < NOW()`)
+	if err != nil { return 0, err }
+	return res.RowsAffected()
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n { return s[:n] }
+	return s
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stubClientID = "den-test"
+	stubKeyID    = "stub-1"
+	stubCodeTTL  = time.Minute
+)
+
+// stubIdP is a minimal OpenID Connect provider for exercising the login flow
+// end to end without a real IdP. It logs in whoever asks for a username.
+type stubIdP struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]stubGrant
+}
+
+type stubGrant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	username    string
+	email       string
+	expiresAt   time.Time
+}
+
+func newStubIdP(issuer string) (*stubIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &stubIdP{issuer: strings.TrimRight(issuer, "/"), key: key, codes: map[string]stubGrant{}}, nil
+}
+
+// ServeHTTP expects paths relative to the issuer, e.g. /authorize.
+func (p *stubIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		p.discovery(w)
+	case "/jwks":
+		p.jwks(w)
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *stubIdP) discovery(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"none"},
+	})
+}
+
+func (p *stubIdP) jwks(w http.ResponseWriter) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": stubKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var stubLoginPage = template.Must(template.New("stub").Parse(`<!doctype html>
+<title>stub idp</title>
+<h1>stub identity provider</h1>
+<p>development only. whoever you type in here is who you are.</p>
+<form method="post">
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+{{end}}<p><label>username <input name="username" value="{{.Hint}}" required autofocus></label></p>
+<p><label>email <input name="email" type="email"></label></p>
+<button type="submit">log in</button>
+</form>
+`))
+
+// authorize shows a login form on GET. Passing login_hint skips the form,
+// which is handy for scripted logins.
+func (p *stubIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	q := r.Form
+	if q.Get("client_id") != stubClientID || q.Get("response_type") != "code" {
+		http.Error(w, "unknown client or unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "pkce with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" || redirectURI.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	username := strings.TrimSpace(q.Get("username"))
+	if r.Method == http.MethodGet && username == "" {
+		username = strings.TrimSpace(q.Get("login_hint"))
+	}
+	if username == "" {
+		params := url.Values{}
+		for _, k := range []string{"client_id", "response_type", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params.Set(k, q.Get(k))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		stubLoginPage.Execute(w, map[string]interface{}{"Params": params, "Hint": q.Get("login_hint")})
+		return
+	}
+
+	code := randomToken()
+	p.mu.Lock()
+	now := time.Now()
+	for c, g := range p.codes {
+		if now.After(g.expiresAt) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = stubGrant{
+		redirectURI: redirectURI.String(),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		username:    username,
+		email:       strings.TrimSpace(q.Get("email")),
+		expiresAt:   now.Add(stubCodeTTL),
+	}
+	p.mu.Unlock()
+
+	values := redirectURI.Query()
+	values.Set("code", code)
+	values.Set("state", q.Get("state"))
+	redirectURI.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	grant, ok := p.codes[code]
+	delete(p.codes, code) // codes are single use
+	p.mu.Unlock()
+	if !ok || time.Now().After(grant.expiresAt) || r.PostForm.Get("redirect_uri") != grant.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(pkceChallenge(r.PostForm.Get("code_verifier"))), []byte(grant.challenge)) != 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "pkce verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                p.issuer,
+		"sub":                "stub|" + grant.username,
+		"aud":                stubClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              grant.nonce,
+		"name":               grant.username,
+		"preferred_username": grant.username,
+	}
+	if grant.email != "" {
+		claims["email"] = grant.email
+		claims["email_verified"] = true
+	}
+	idToken, err := p.sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomToken(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *stubIdP) sign(claims map[string]interface{}) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": stubKeyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/den/internal/models"
+	"github.com/lib/pq"
+)
+
+// Personal access tokens look like den_pat_<64 hex chars>. Only the SHA-256
+// of the token is stored; the plaintext is shown to the user exactly once.
+const AccessTokenPrefix = "den_pat_"
+
+const (
+	ScopeRead             = "read"
+	ScopeContainerControl = "container:control"
+	ScopeSubdomainsWrite  = "subdomains:write"
+	ScopeExports          = "exports"
+)
+
+var AccessTokenScopes = []string{ScopeRead, ScopeContainerControl, ScopeSubdomainsWrite, ScopeExports}
+
+func IsValidScope(scope string) bool {
+	for _, s := range AccessTokenScopes {
+		if s == scope { return true }
+	}
+	return false
+}
+
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAccessToken stores a new token and returns it in plaintext along with its row.
+func (s *Service) CreateAccessToken(userID int, name string, scopes []string, expiresAt *time.Time) (string, *models.PersonalAccessToken, error) {
+	for _, scope := range scopes {
+		if !IsValidScope(scope) { return "", nil, fmt.Errorf("unknown scope %q", scope) }
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil { return "", nil, err }
+	token := AccessTokenPrefix + hex.EncodeToString(b)
+	pat := &models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(AccessTokenPrefix)+4],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	err := s.db.QueryRow(`
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, userID, name, hashAccessToken(token), pat.Prefix, pq.Array(scopes), expiresAt).Scan(&pat.ID, &pat.CreatedAt)
+	if err != nil { return "", nil, err }
+	return token, pat, nil
+}
+
+// GetUserByAccessToken resolves an unexpired token to its user and scopes,
+// and records when and from where it was used.
+func (s *Service) GetUserByAccessToken(token, clientIP string) (*models.User, []string, error) {
+	var tokenID int
+	var scopes pq.StringArray
+	user, err := scanUser(s.db.QueryRow(`
+		SELECT `+userColumns+`, t.id, t.scopes
+		FROM personal_access_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND (t.expires_at IS NULL OR t.expires_at > NOW())
+	`, hashAccessToken(token)), &tokenID, &scopes)
+	if err != nil { return nil, nil, err }
+	_, _ = s.db.Exec(`UPDATE personal_access_tokens SET last_used_at = NOW(), last_used_ip = $2 WHERE id = $1`, tokenID, clientIP)
+	return user, scopes, nil
+}
+
+func (s *Service) ListAccessTokens(userID int) ([]models.PersonalAccessToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, name, token_prefix, scopes, expires_at, last_used_at, last_used_ip, created_at
+		FROM personal_access_tokens WHERE user_id = $1 ORDER BY created_at DESC
+	`, userID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	tokens := []models.PersonalAccessToken{}
+	for rows.Next() {
+		var t models.PersonalAccessToken
+		var scopes pq.StringArray
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.LastUsedIP, &t.CreatedAt); err == nil {
+			t.Scopes = scopes
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// DeleteAccessToken revokes a token; it reports false if the user has no such token.
+func (s *Service) DeleteAccessToken(userID, tokenID int) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`, tokenID, userID)
+	if err != nil { return false, err }
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
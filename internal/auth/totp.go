@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/den/internal/models"
+)
+
+// TOTP follows RFC 6238 with the parameters every authenticator app
+// defaults to: SHA-1, six digits, 30 second steps.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// accept one step either side for clock drift
+	totpSkew          = 1
+	totpIssuer        = "den"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidCode         = errors.New("invalid code")
+	ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled     = errors.New("start enrolment first")
+	ErrTOTPRequiredForRole = errors.New("two-factor authentication is required for accounts with admin roles")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// matchTOTP returns the time step the code belongs to, or -1.
+func matchTOTP(secret, code string, now time.Time) int64 {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return -1
+	}
+	step := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		s := step + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(s))), []byte(code)) == 1 {
+			return s
+		}
+	}
+	return -1
+}
+
+func totpURI(account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+account) + "?" + q.Encode()
+}
+
+// normalizeCode strips the spaces and dashes people type or paste.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// recovery codes are ten characters from an alphabet without lookalikes,
+// shown as xxxxx-xxxxx
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	buf := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil { return nil, err }
+		var b strings.Builder
+		for j, c := range buf {
+			if j == 5 { b.WriteByte('-') }
+			b.WriteByte(recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// BeginTOTPEnrolment stores a fresh, not yet enabled secret for the user and
+// returns it with the otpauth:// URI authenticator apps scan as a QR code.
+func (s *Service) BeginTOTPEnrolment(user *models.User) (string, string, error) {
+	if user.TOTPEnabled { return "", "", ErrTOTPAlreadyEnabled }
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil { return "", "", err }
+	secret := totpEncoding.EncodeToString(key)
+	res, err := s.db.Exec(`
+		INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_totp.enabled_at IS NULL
+	`, user.ID, secret)
+	if err != nil { return "", "", err }
+	if n, _ := res.RowsAffected(); n == 0 { return "", "", ErrTOTPAlreadyEnabled }
+	return secret, totpURI(user.Username, secret), nil
+}
+
+// ConfirmTOTP enables two-factor once the user proves their app produces
+// valid codes, and hands back the first set of recovery codes.
+func (s *Service) ConfirmTOTP(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil { return nil, err }
+	defer tx.Rollback()
+	var secret string
+	var enabledAt sql.NullTime
+	err = tx.QueryRow(`SELECT secret, enabled_at FROM user_totp WHERE user_id = $1 FOR UPDATE`, userID).Scan(&secret, &enabledAt)
+	if err == sql.ErrNoRows { return nil, ErrTOTPNotEnrolled }
+	if err != nil { return nil, err }
+	if enabledAt.Valid { return nil, ErrTOTPAlreadyEnabled }
+	step := matchTOTP(secret, normalizeCode(code), time.Now())
+	if step < 0 { return nil, ErrInvalidCode }
+	if _, err := tx.Exec(`UPDATE user_totp SET enabled_at = NOW(), last_used_step = $2 WHERE user_id = $1`, userID, step); err != nil { return nil, err }
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil { return nil, err }
+	return codes, tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil { return nil, err }
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil { return nil, err }
+	for _, c := range codes {
+		if _, err := tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hashRecoveryCode(c)); err != nil { return nil, err }
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused
+// recovery code. TOTP codes can't be replayed: each time step is accepted
+// at most once.
+func (s *Service) VerifySecondFactor(userID int, code string) error {
+	code = normalizeCode(code)
+	if len(code) == totpDigits {
+		var secret string
+		err := s.db.QueryRow(`SELECT secret FROM user_totp WHERE user_id = $1 AND enabled_at IS NOT NULL`, userID).Scan(&secret)
+		if err == sql.ErrNoRows { return ErrInvalidCode }
+		if err != nil { return err }
+		step := matchTOTP(secret, code, time.Now())
+		if step < 0 { return ErrInvalidCode }
+		res, err := s.db.Exec(`UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`, userID, step)
+		if err != nil { return err }
+		if n, _ := res.RowsAffected(); n == 0 { return ErrInvalidCode }
+		return nil
+	}
+	res, err := s.db.Exec(`
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashRecoveryCode(code))
+	if err != nil { return err }
+	if n, _ := res.RowsAffected(); n == 0 { return ErrInvalidCode }
+	return nil
+}
+
+func (s *Service) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil { return nil, err }
+	defer tx.Rollback()
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil { return nil, err }
+	return codes, tx.Commit()
+}
+
+func (s *Service) RecoveryCodesRemaining(userID int) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// DisableTOTP turns two-factor off, unless policy requires it for this user.
+func (s *Service) DisableTOTP(user *models.User) error {
+	if len(Permissions(user)) > 0 && s.RequireAdminTOTP() { return ErrTOTPRequiredForRole }
+	_, err := s.ResetTOTP(user.ID)
+	return err
+}
+
+// ResetTOTP removes two-factor for a user who lost their device and their
+// recovery codes. It skips the admin policy check; callers are admins.
+func (s *Service) ResetTOTP(userID int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil { return false, err }
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil { return false, err }
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil { return false, err }
+	n, _ := res.RowsAffected()
+	return n > 0, tx.Commit()
+}
+
+const settingRequireAdminTOTP = "require_admin_totp"
+
+// RequireAdminTOTP reports whether accounts with any admin role must have
+// two-factor enabled to use the admin area.
+func (s *Service) RequireAdminTOTP() bool {
+	var v string
+	if err := s.db.QueryRow(`SELECT value FROM settings WHERE key = $1`, settingRequireAdminTOTP).Scan(&v); err != nil { return false }
+	return v == "true"
+}
+
+func (s *Service) SetRequireAdminTOTP(required bool, updatedBy int) error {
+	_, err := s.db.Exec(`
+		INSERT INTO settings (key, value, updated_by) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+	`, settingRequireAdminTOTP, fmt.Sprint(required), updatedBy)
+	return err
+}
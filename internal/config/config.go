@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Config is where den is served from. It is read from the environment:
+//
+//	DEN_DOMAIN         zone subdomains and SSH are under (default hack.kim)
+//	DEN_DASHBOARD_URL  public URL of the dashboard (default https://DEN_DOMAIN)
+//	DEN_MASTER_URL     URL containers reach the master's CLI API on (default
+//	                   the dashboard URL)
+type Config struct {
+	Domain       string
+	DashboardURL string
+	MasterURL    string
+}
+
+const defaultDomain = "hack.kim"
+
+func FromEnv() Config {
+	cfg := Config{
+		Domain:       strings.Trim(strings.ToLower(strings.TrimSpace(os.Getenv("DEN_DOMAIN"))), "."),
+		DashboardURL: strings.TrimRight(strings.TrimSpace(os.Getenv("DEN_DASHBOARD_URL")), "/"),
+		MasterURL:    strings.TrimRight(strings.TrimSpace(os.Getenv("DEN_MASTER_URL")), "/"),
+	}
+	if cfg.Domain == "" {
+		cfg.Domain = defaultDomain
+	}
+	if cfg.DashboardURL == "" {
+		cfg.DashboardURL = "https://" + cfg.Domain
+	}
+	if cfg.MasterURL == "" {
+		cfg.MasterURL = cfg.DashboardURL
+	}
+	return cfg
+}
+
+// Hostname is the public name of a subdomain: sub.domain for username
+// subdomains and sub.username.domain for project ones.
+func (c Config) Hostname(subdomain, username, subdomainType string) string {
+	if subdomainType == "username" {
+		return fmt.Sprintf("%s.%s", subdomain, c.Domain)
+	}
+	return fmt.Sprintf("%s.%s.%s", subdomain, username, c.Domain)
+}
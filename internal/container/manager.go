@@ -92,12 +92,32 @@ func (m *Manager) portInSlice(port int, ports []int) bool {
 	return false
 }
 
+// ProgressFunc is told about each step of a long operation as it completes.
+type ProgressFunc func(step, message string)
+
+func (p ProgressFunc) report(step, message string) {
+	if p != nil {
+		p(step, message)
+	}
+}
+
 func (m *Manager) CreateContainer(userID int, username string) (*ContainerInfo, error) {
+	return m.CreateContainerWithProgress(userID, username, nil)
+}
+
+func (m *Manager) CreateContainerWithProgress(userID int, username string, progress ProgressFunc) (*ContainerInfo, error) {
 	containerName := fmt.Sprintf("den-%s", username)
+	// a retried create job finds the instance from the earlier attempt;
+	// finish setting it up instead of failing on the name
+	if exec.Command("lxc", "info", containerName).Run() == nil {
+		progress.report("launched", "reusing existing instance "+containerName)
+		return m.adoptContainer(containerName, username, progress)
+	}
 	cmd := exec.Command("lxc", "launch", "ubuntu:22.04", containerName)
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("failed to create container: %w", err)
 	}
+	progress.report("launched", "launched "+containerName+" from ubuntu:22.04")
 	
 	if err := m.configureContainer(containerName); err != nil {
 		exec.Command("lxc", "delete", containerName, "--force").Run()
@@ -108,8 +128,9 @@ func (m *Manager) CreateContainer(userID int, username string) (*ContainerInfo,
 		exec.Command("lxc", "delete", containerName, "--force").Run()
 		return nil, fmt.Errorf("container failed to start: %w", err)
 	}
+	progress.report("configured", "resource limits applied, container is up")
 	
-	if err := m.setupUserInContainer(containerName, username); err != nil {
+	if err := m.setupUserInContainer(containerName, username, progress); err != nil {
 		exec.Command("lxc", "delete", containerName, "--force").Run()
 		return nil, fmt.Errorf("failed to setup user: %w", err)
 	}
@@ -125,6 +146,30 @@ func (m *Manager) CreateContainer(userID int, username string) (*ContainerInfo,
 	return info, nil
 }
 
+// adoptContainer brings an existing instance to the state CreateContainer
+// would leave it in. It never deletes the instance, which may hold user data.
+func (m *Manager) adoptContainer(name, username string, progress ProgressFunc) (*ContainerInfo, error) {
+	if err := m.StartContainer(name); err != nil {
+		return nil, err
+	}
+	if err := m.configureContainer(name); err != nil {
+		return nil, fmt.Errorf("failed to configure container: %w", err)
+	}
+	if err := m.waitForContainer(name); err != nil {
+		return nil, fmt.Errorf("container failed to start: %w", err)
+	}
+	progress.report("configured", "resource limits applied, container is up")
+	if err := m.setupUserInContainer(name, username, progress); err != nil {
+		return nil, fmt.Errorf("failed to setup user: %w", err)
+	}
+	info, err := m.getContainerInfo(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container info: %w", err)
+	}
+	info.AllocatedPorts = []int{}
+	return info, nil
+}
+
 func (m *Manager) configureContainer(name string) error {
 	configs := [][]string{
 		{"lxc", "config", "set", name, "limits.memory", fmt.Sprintf("%dMB", m.defaultMemoryMB)},
@@ -155,7 +200,7 @@ func (m *Manager) waitForContainer(name string) error {
 	return fmt.Errorf("container did not become ready in time")
 }
 
-func (m *Manager) setupUserInContainer(containerName, username string) error {
+func (m *Manager) setupUserInContainer(containerName, username string, progress ProgressFunc) error {
 	readme := fmt.Sprintf(
 		"Welcome to den!\n\n"+
 		"This environment is registered to user: %s\n"+
@@ -168,6 +213,7 @@ func (m *Manager) setupUserInContainer(containerName, username string) error {
 		"  - den start|stop|restart\n"+
 		"  - den ports\n"+
 		"  - den get_port\n"+
+		"  - den jobs, den follow JOB_ID\n"+
 		"  - den update\n\n"+
 		"Token locations:\n"+
 		"  - /etc/den/container_token (root-readable)\n"+
@@ -192,12 +238,21 @@ func (m *Manager) setupUserInContainer(containerName, username string) error {
 		{"lxc", "exec", containerName, "--", "bash", "-c", fmt.Sprintf("echo -e 'welcome to den! i hope you enjoy your stay here!\\n\\nyour container is running on: %s\\nfor direct port access, use this hostname\\n\\n~ a fuzzy little dog' > /etc/motd", m.getDisplayHostname())},
 	}
 
+	// safe to re-run: only useradd fails on a second pass
+	userExists := exec.Command("lxc", "exec", containerName, "--", "id", "-u", username).Run() == nil
 	for _, cmd := range commands {
+		if userExists && cmd[4] == "useradd" {
+			continue
+		}
 		execCmd := exec.Command(cmd[0], cmd[1:]...)
 		if err := execCmd.Run(); err != nil {
 			return fmt.Errorf("failed to run setup command %v: %w", cmd, err)
 		}
+		if cmd[4] == "apt-get" && cmd[5] == "install" {
+			progress.report("packages_installed", "installed "+strings.Join(cmd[7:], ", "))
+		}
 	}
+	progress.report("user_created", "user "+username+" can log in over ssh")
 
 	return nil
 }
@@ -332,6 +387,10 @@ func (m *Manager) GetContainerStats(containerID string) (*ContainerStats, error)
 }
 
 func (m *Manager) DeleteContainer(containerID string) error {
+	// already gone, e.g. a retried delete job
+	if out, err := exec.Command("lxc", "info", containerID).CombinedOutput(); err != nil && strings.Contains(strings.ToLower(string(out)), "not found") {
+		return nil
+	}
 	stopCmd := exec.Command("lxc", "stop", containerID)
 	stopCmd.Run()
 	deleteCmd := exec.Command("lxc", "delete", containerID)
@@ -40,10 +40,17 @@ func NewManager(publicHostname string) (*Manager, error) {
 	}, nil
 }
 
+// ProgressFunc is told about each step of a long operation as it completes.
+type ProgressFunc func(step, message string)
+
 func (m *Manager) CreateContainer(userID int, username string) (*ContainerInfo, error) {
 	return nil, fmt.Errorf("container operations not supported on master node")
 }
 
+func (m *Manager) CreateContainerWithProgress(userID int, username string, progress ProgressFunc) (*ContainerInfo, error) {
+	return nil, fmt.Errorf("container operations not supported on master node")
+}
+
 func (m *Manager) ListContainers() ([]*ContainerInfo, error) {
 	return nil, fmt.Errorf("container operations not supported on master node")
 }
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
+	"net/url"
 )
 
+// CloudflareService is the Cloudflare DNS provider.
 type CloudflareService struct {
 	apiToken string
 	zoneID   string
@@ -23,230 +25,167 @@ type CloudflareRecord struct {
 	Proxied bool   `json:"proxied"`
 }
 
-type CloudflareResponse struct {
-	Success bool                `json:"success"`
-	Errors  []CloudflareError   `json:"errors"`
-    Result  []CloudflareRecord  `json:"result"`
-}
-
 type CloudflareError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
 
 type CloudflareListResponse struct {
-    Success bool               `json:"success"`
-    Errors  []CloudflareError  `json:"errors"`
-    Result  []CloudflareRecord `json:"result"`
+	Success    bool               `json:"success"`
+	Errors     []CloudflareError  `json:"errors"`
+	Result     []CloudflareRecord `json:"result"`
+	ResultInfo struct {
+		Page       int `json:"page"`
+		TotalPages int `json:"total_pages"`
+	} `json:"result_info"`
 }
 
 type CloudflareSingleResponse struct {
-    Success bool              `json:"success"`
-    Errors  []CloudflareError `json:"errors"`
-    Result  CloudflareRecord  `json:"result"`
-}
-
-type CloudflareDeleteResponse struct {
-    Success bool              `json:"success"`
-    Errors  []CloudflareError `json:"errors"`
-    Result  struct {
-        ID string `json:"id"`
-    } `json:"result"`
+	Success bool              `json:"success"`
+	Errors  []CloudflareError `json:"errors"`
+	Result  CloudflareRecord  `json:"result"`
 }
 
-func NewCloudflareService() *CloudflareService {
+func NewCloudflareService(apiToken, zoneID string) *CloudflareService {
 	return &CloudflareService{
-		apiToken: os.Getenv("CLOUDFLARE_API_TOKEN"),
-		zoneID:   os.Getenv("CLOUDFLARE_ZONE_ID"),
+		apiToken: apiToken,
+		zoneID:   zoneID,
 		baseURL:  "https://api.cloudflare.com/client/v4",
 	}
 }
 
-func (c *CloudflareService) CreateRecord(subdomain, targetIP string) error {
-	if c.apiToken == "" || c.zoneID == "" {
-		return fmt.Errorf("cloudflare API token or zone ID not configured")
-	}
-	
-	fmt.Printf("cloudflare config: zoneID=%s, hasToken=%t\n", c.zoneID, c.apiToken != "")
+func (c *CloudflareService) Name() string { return "cloudflare" }
 
-	record := CloudflareRecord{
-		Type:    "A",
-		Name:    subdomain,
-		Content: targetIP,
-		TTL:     300,
-		Proxied: false,
-	}
-
-	jsonData, err := json.Marshal(record)
-	if err != nil {
-		return fmt.Errorf("failed to marshal record: %w", err)
+func (c *CloudflareService) CreateRecord(rec Record) error {
+	if err := c.do("POST", "/dns_records", cloudflareRecord(rec), nil); err != nil {
+		return fmt.Errorf("create %s record %s: %w", rec.Type, rec.Name, err)
 	}
+	fmt.Printf("created DNS record: %s %s -> %s\n", rec.Type, rec.Name, rec.Content)
+	return nil
+}
 
-	url := fmt.Sprintf("%s/zones/%s/dns_records", c.baseURL, c.zoneID)
-	fmt.Printf("cloudflare API URL: %s\n", url)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+func (c *CloudflareService) UpdateRecord(rec Record) error {
+	ids, err := c.findRecordIDs(rec.Name, rec.Type)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to find record for update: %w", err)
 	}
-
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
+	if len(ids) == 0 {
+		return c.CreateRecord(rec)
 	}
-	defer resp.Body.Close()
-
-    var cfResp CloudflareSingleResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
+	if err := c.do("PUT", "/dns_records/"+ids[0], cloudflareRecord(rec), nil); err != nil {
+		return fmt.Errorf("update %s record %s: %w", rec.Type, rec.Name, err)
 	}
-
-	if !cfResp.Success {
-		if len(cfResp.Errors) > 0 {
-			return fmt.Errorf("cloudflare API error: %s", cfResp.Errors[0].Message)
+	// Update replaces the whole set, so drop any duplicates
+	for _, id := range ids[1:] {
+		if err := c.do("DELETE", "/dns_records/"+id, nil, nil); err != nil {
+			return fmt.Errorf("delete duplicate %s record %s: %w", rec.Type, rec.Name, err)
 		}
-		return fmt.Errorf("cloudflare API request failed")
 	}
-
-	fmt.Printf("created DNS record: %s -> %s\n", subdomain, targetIP)
+	fmt.Printf("updated dns record: %s %s -> %s\n", rec.Type, rec.Name, rec.Content)
 	return nil
 }
 
-func (c *CloudflareService) DeleteRecord(subdomain string) error {
-	if c.apiToken == "" || c.zoneID == "" {
-		return fmt.Errorf("cloudflare API token or zone ID not configured")
-	}
-
-	recordID, err := c.findRecordID(subdomain)
+func (c *CloudflareService) DeleteRecord(name, recordType string) error {
+	ids, err := c.findRecordIDs(name, recordType)
 	if err != nil {
 		return fmt.Errorf("failed to find record: %w", err)
 	}
-
-	if recordID == "" {
-		fmt.Printf("DNS record %s not found (may have been already deleted)\n", subdomain)
+	if len(ids) == 0 {
+		fmt.Printf("DNS record %s not found (may have been already deleted)\n", name)
 		return nil
 	}
-
-	url := fmt.Sprintf("%s/zones/%s/dns_records/%s", c.baseURL, c.zoneID, recordID)
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create delete request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make delete request: %w", err)
-	}
-	defer resp.Body.Close()
-
-    var cfResp CloudflareDeleteResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		return fmt.Errorf("failed to decode delete response: %w", err)
-	}
-
-	if !cfResp.Success {
-		if len(cfResp.Errors) > 0 {
-			return fmt.Errorf("cloudflare delete error: %s", cfResp.Errors[0].Message)
+	for _, id := range ids {
+		if err := c.do("DELETE", "/dns_records/"+id, nil, nil); err != nil {
+			return fmt.Errorf("delete %s record %s: %w", recordType, name, err)
 		}
-		return fmt.Errorf("cloudflare delete request failed")
 	}
-
-	fmt.Printf("deleted DNS record: %s\n", subdomain)
+	fmt.Printf("deleted DNS record: %s\n", name)
 	return nil
 }
 
-func (c *CloudflareService) findRecordID(subdomain string) (string, error) {
-	url := fmt.Sprintf("%s/zones/%s/dns_records?name=%s&type=A", c.baseURL, c.zoneID, subdomain)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create search request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to search for record: %w", err)
-	}
-	defer resp.Body.Close()
-
-    var cfResp CloudflareListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		return "", fmt.Errorf("failed to decode search response: %w", err)
-	}
-
-	if !cfResp.Success {
-		if len(cfResp.Errors) > 0 {
-			return "", fmt.Errorf("cloudflare search error: %s", cfResp.Errors[0].Message)
+func (c *CloudflareService) ListRecords() ([]Record, error) {
+	out := []Record{}
+	for page := 1; ; page++ {
+		var resp CloudflareListResponse
+		if err := c.do("GET", fmt.Sprintf("/dns_records?per_page=100&page=%d", page), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Result {
+			out = append(out, Record{Name: r.Name, Type: r.Type, Content: r.Content, TTL: r.TTL})
+		}
+		if page >= resp.ResultInfo.TotalPages {
+			return out, nil
 		}
-		return "", fmt.Errorf("cloudflare search request failed")
-	}
-
-	if len(cfResp.Result) > 0 {
-		return cfResp.Result[0].ID, nil
 	}
-
-	return "", nil
 }
 
-func (c *CloudflareService) UpdateRecord(subdomain, newTargetIP string) error {
-	recordID, err := c.findRecordID(subdomain)
-	if err != nil {
-		return fmt.Errorf("failed to find record for update: %w", err)
+func (c *CloudflareService) findRecordIDs(name, recordType string) ([]string, error) {
+	var resp CloudflareListResponse
+	q := url.Values{"name": {name}, "type": {recordType}}
+	if err := c.do("GET", "/dns_records?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
 	}
-
-	if recordID == "" {
-		return c.CreateRecord(subdomain, newTargetIP)
+	ids := make([]string, 0, len(resp.Result))
+	for _, r := range resp.Result {
+		ids = append(ids, r.ID)
 	}
+	return ids, nil
+}
 
-	record := CloudflareRecord{
-		Type:    "A",
-		Name:    subdomain,
-		Content: newTargetIP,
-		TTL:     300,
-		Proxied: false,
+func cloudflareRecord(rec Record) CloudflareRecord {
+	ttl := rec.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
 	}
+	return CloudflareRecord{Type: rec.Type, Name: rec.Name, Content: rec.Content, TTL: ttl}
+}
 
-	jsonData, err := json.Marshal(record)
-	if err != nil {
-		return fmt.Errorf("failed to marshal update record: %w", err)
+// do calls the zone's API and decodes the response into out if given.
+// Cloudflare reports failures in the body, so that is checked too.
+func (c *CloudflareService) do(method, path string, body interface{}, out interface{}) error {
+	if c.apiToken == "" || c.zoneID == "" {
+		return fmt.Errorf("cloudflare API token or zone ID not configured")
 	}
-
-	url := fmt.Sprintf("%s/zones/%s/dns_records/%s", c.baseURL, c.zoneID, recordID)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record: %w", err)
+		}
+		reqBody = bytes.NewBuffer(b)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/zones/%s%s", c.baseURL, c.zoneID, path), reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to create update request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
-
 	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to make update request: %w", err)
+		return fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
-
-    var cfResp CloudflareSingleResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		return fmt.Errorf("failed to decode update response: %w", err)
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
 	}
-
-	if !cfResp.Success {
-		if len(cfResp.Errors) > 0 {
-			return fmt.Errorf("cloudflare update error: %s", cfResp.Errors[0].Message)
+	var status struct {
+		Success bool              `json:"success"`
+		Errors  []CloudflareError `json:"errors"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if !status.Success {
+		if len(status.Errors) > 0 {
+			return fmt.Errorf("cloudflare API error: %s", status.Errors[0].Message)
 		}
-		return fmt.Errorf("cloudflare update request failed")
+		return fmt.Errorf("cloudflare API request failed")
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
 	}
-
-	fmt.Printf("updated dns record: %s -> %s\n", subdomain, newTargetIP)
 	return nil
 }
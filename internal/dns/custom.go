@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ChallengePrefix is the label under a custom domain where the TXT
+// ownership record goes.
+const ChallengePrefix = "_den-challenge"
+
+// NormalizeCustomDomain lowercases hostname and checks it is a name a user
+// may bring: a valid DNS name outside den's own domain.
+func (s *Service) NormalizeCustomDomain(hostname string) (string, error) {
+	hostname = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(hostname), "."))
+	if len(hostname) < 3 || len(hostname) > 253 {
+		return "", fmt.Errorf("domain must be between 3 and 253 characters")
+	}
+	if net.ParseIP(hostname) != nil {
+		return "", fmt.Errorf("domain must be a hostname, not an IP address")
+	}
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("domain must include a top-level domain")
+	}
+	for _, label := range labels {
+		if len(label) < 1 || len(label) > 63 {
+			return "", fmt.Errorf("each part of the domain must be between 1 and 63 characters")
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", fmt.Errorf("domain parts cannot start or end with a hyphen")
+		}
+		for _, char := range label {
+			if !((char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '-') {
+				return "", fmt.Errorf("domain can only contain letters, digits, hyphens and dots")
+			}
+		}
+	}
+	if hostname == s.domain || strings.HasSuffix(hostname, "."+s.domain) {
+		return "", fmt.Errorf("use a subdomain for names under %s", s.domain)
+	}
+	return hostname, nil
+}
+
+// CustomDomainTarget is the name a custom domain can CNAME to, which both
+// routes it to den and proves the user owns it.
+func (s *Service) CustomDomainTarget(username string) string {
+	return fmt.Sprintf("%s.%s", username, s.domain)
+}
+
+// VerifyCustomDomain checks the user controls hostname: either a TXT record
+// at _den-challenge.<hostname> holds den-verify=<token>, or hostname is a
+// CNAME to the user's own name under den's domain.
+func (s *Service) VerifyCustomDomain(ctx context.Context, hostname, username, token string) error {
+	want := "den-verify=" + token
+	txts, _ := net.DefaultResolver.LookupTXT(ctx, ChallengePrefix+"."+hostname)
+	for _, txt := range txts {
+		if strings.TrimSpace(txt) == want {
+			return nil
+		}
+	}
+	target := s.CustomDomainTarget(username)
+	cname, cnameErr := net.DefaultResolver.LookupCNAME(ctx, hostname)
+	if cnameErr == nil && strings.EqualFold(strings.TrimSuffix(cname, "."), target) {
+		return nil
+	}
+	if len(txts) > 0 {
+		return fmt.Errorf("TXT record at %s.%s does not match", ChallengePrefix, hostname)
+	}
+	if cnameErr == nil && !strings.EqualFold(strings.TrimSuffix(cname, "."), hostname) {
+		return fmt.Errorf("%s points at %s, not %s", hostname, strings.TrimSuffix(cname, "."), target)
+	}
+	return fmt.Errorf("no TXT record at %s.%s or CNAME to %s", ChallengePrefix, hostname, target)
+}
+
+// AddCustomDomainRoute routes a verified custom domain to the container and
+// makes sure Caddy will get it a certificate on demand.
+func (s *Service) AddCustomDomainRoute(hostname, nodeIP string, targetPort int) error {
+	if err := s.caddy.EnableOnDemandTLS(s.askURL); err != nil {
+		return fmt.Errorf("failed to enable on-demand TLS: %w", err)
+	}
+	if err := s.caddy.AddSubdomain(hostname, nodeIP, targetPort); err != nil {
+		return fmt.Errorf("failed to add caddy route: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) RemoveCustomDomainRoute(hostname string) error {
+	return s.caddy.RemoveSubdomain(hostname)
+}
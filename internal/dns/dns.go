@@ -13,19 +13,40 @@ import (
 )
 
 type Service struct {
-	domain     string
-	caddy      *proxy.CaddyService
-	cloudflare *CloudflareService
+	domain   string
+	caddy    *proxy.CaddyService
+	provider Provider
+	// wildcard means the zone's *.domain record covers every subdomain, so
+	// only Caddy routes are added and removed
+	wildcard bool
+	// askURL is where Caddy checks a custom domain may get a certificate
+	askURL string
 }
 
 func NewService() *Service {
+	cfg := ConfigFromEnv()
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		fmt.Printf("dns provider %s unavailable: %v\n", cfg.Provider, err)
+		provider = unavailableProvider{name: cfg.Provider, err: err}
+	}
+	if cfg.Mode != ModeRecords && cfg.Mode != ModeWildcard {
+		fmt.Printf("unknown DNS_MODE %q, using %s\n", cfg.Mode, ModeRecords)
+	}
 	return &Service{
-		domain:     "hack.kim",
-		caddy:      proxy.NewCaddyService(""),
-		cloudflare: NewCloudflareService(),
+		domain:   cfg.Domain,
+		caddy:    proxy.NewCaddyService("", cfg.Domain),
+		provider: provider,
+		wildcard: cfg.Mode == ModeWildcard,
+		askURL:   cfg.CaddyAskURL,
 	}
 }
 
+// Provider is the DNS provider the service manages records with.
+func (s *Service) Provider() Provider {
+	return s.provider
+}
+
 func (s *Service) RebuildRoutesFromDatabase(db *sql.DB) error {
 	fmt.Println("rebuilding caddy routes from database on startup...")
 	return s.caddy.RebuildAllRoutes(db)
@@ -73,15 +94,22 @@ func (s *Service) CreateDNSRecord(subdomain, username, subdomainType, nodeIP str
     }
     fmt.Printf("creating dns record: %s -> %s:%d\n", fullDomain, nodeIP, externalPort)
     
+    if s.wildcard {
+        if err := s.caddy.AddSubdomain(fullDomain, nodeIP, externalPort); err != nil {
+            return fmt.Errorf("failed to add caddy route: %w", err)
+        }
+        return nil
+    }
+
     publicIP := s.getPublicIP()
-    fmt.Printf("creating cloudflare DNS record: %s -> %s\n", fullDomain, publicIP)
-    if err := s.cloudflare.CreateRecord(fullDomain, publicIP); err != nil {
-        fmt.Printf("cloudflare API error: %v\n", err)
-        return fmt.Errorf("failed to create cloudflare record: %w", err)
+    fmt.Printf("creating %s DNS record: %s -> %s\n", s.provider.Name(), fullDomain, publicIP)
+    if err := s.provider.CreateRecord(Record{Name: fullDomain, Type: "A", Content: publicIP, TTL: defaultTTL}); err != nil {
+        fmt.Printf("%s error: %v\n", s.provider.Name(), err)
+        return fmt.Errorf("failed to create DNS record: %w", err)
     }
     
     if err := s.caddy.AddSubdomain(fullDomain, nodeIP, externalPort); err != nil {
-        s.cloudflare.DeleteRecord(fullDomain)
+        s.provider.DeleteRecord(fullDomain, "A")
         return fmt.Errorf("failed to add caddy route: %w", err)
     }
     
@@ -101,13 +129,103 @@ func (s *Service) DeleteDNSRecord(subdomain, username, subdomainType string) err
         fmt.Printf("failed to remove caddy route: %v\n", err)
     }
 
-    if err := s.cloudflare.DeleteRecord(fullDomain); err != nil {
-        return fmt.Errorf("failed to delete cloudflare record: %w", err)
+    if s.wildcard {
+        return nil
+    }
+
+    if err := s.provider.DeleteRecord(fullDomain, "A"); err != nil {
+        return fmt.Errorf("failed to delete DNS record: %w", err)
     }
     
     return nil
 }
 
+// fullDomain is the public hostname for a subdomain row. Custom domains are
+// already full hostnames.
+func (s *Service) fullDomain(subdomain, username, subdomainType string) string {
+    if subdomainType == "custom" {
+        return subdomain
+    }
+    if subdomainType == "username" {
+        return fmt.Sprintf("%s.%s", subdomain, s.domain)
+    }
+    return fmt.Sprintf("%s.%s.%s", subdomain, username, s.domain)
+}
+
+// DisableUserRoutes takes every caddy route for the user offline. DNS records
+// and subdomain rows are kept so RestoreUserRoutes can bring them back.
+func (s *Service) DisableUserRoutes(db *sql.DB, userID int) error {
+    rows, err := db.Query(`
+        SELECT s.subdomain, s.subdomain_type, u.username
+        FROM subdomains s JOIN users u ON s.user_id = u.id
+        WHERE s.user_id = $1 AND s.is_active = true
+        UNION ALL
+        SELECT d.hostname, 'custom', u.username
+        FROM custom_domains d JOIN users u ON d.user_id = u.id
+        WHERE d.user_id = $1 AND d.status = 'verified'
+    `, userID)
+    if err != nil {
+        return fmt.Errorf("failed to query subdomains: %w", err)
+    }
+    defer rows.Close()
+    var failed int
+    for rows.Next() {
+        var subdomain, subdomainType, username string
+        if err := rows.Scan(&subdomain, &subdomainType, &username); err != nil {
+            return err
+        }
+        if err := s.caddy.RemoveSubdomain(s.fullDomain(subdomain, username, subdomainType)); err != nil {
+            fmt.Printf("failed to disable route for %s: %v\n", subdomain, err)
+            failed++
+        }
+    }
+    if failed > 0 {
+        return fmt.Errorf("%d routes could not be removed", failed)
+    }
+    return rows.Err()
+}
+
+// RestoreUserRoutes re-adds the caddy routes removed by DisableUserRoutes.
+func (s *Service) RestoreUserRoutes(db *sql.DB, userID int) error {
+    rows, err := db.Query(`
+        SELECT s.subdomain, s.target_port, s.subdomain_type, u.username,
+               COALESCE(n.public_hostname, n.hostname)
+        FROM subdomains s
+        JOIN users u ON s.user_id = u.id
+        JOIN containers c ON u.container_id = c.id
+        JOIN nodes n ON c.node_id = n.id
+        WHERE s.user_id = $1 AND s.is_active = true
+        UNION ALL
+        SELECT d.hostname, d.target_port, 'custom', u.username,
+               COALESCE(n.public_hostname, n.hostname)
+        FROM custom_domains d
+        JOIN users u ON d.user_id = u.id
+        JOIN containers c ON u.container_id = c.id
+        JOIN nodes n ON c.node_id = n.id
+        WHERE d.user_id = $1 AND d.status = 'verified'
+    `, userID)
+    if err != nil {
+        return fmt.Errorf("failed to query subdomains: %w", err)
+    }
+    defer rows.Close()
+    var failed int
+    for rows.Next() {
+        var subdomain, subdomainType, username, nodeIP string
+        var targetPort int
+        if err := rows.Scan(&subdomain, &targetPort, &subdomainType, &username, &nodeIP); err != nil {
+            return err
+        }
+        if err := s.caddy.AddSubdomain(s.fullDomain(subdomain, username, subdomainType), nodeIP, targetPort); err != nil {
+            fmt.Printf("failed to restore route for %s: %v\n", subdomain, err)
+            failed++
+        }
+    }
+    if failed > 0 {
+        return fmt.Errorf("%d routes could not be restored", failed)
+    }
+    return rows.Err()
+}
+
 func (s *Service) ValidateUserPort(port int, allocatedPorts []int) error {
 	for _, allocatedPort := range allocatedPorts {
 		if port == allocatedPort {
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryProvider keeps records in a map, for development and tests where
+// there is no zone to update.
+type MemoryProvider struct {
+	mu      sync.Mutex
+	records map[string][]Record
+}
+
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{records: map[string][]Record{}}
+}
+
+func memoryKey(name, recordType string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + " " + strings.ToUpper(recordType)
+}
+
+func (m *MemoryProvider) Name() string { return "memory" }
+
+func (m *MemoryProvider) CreateRecord(rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryKey(rec.Name, rec.Type)
+	if len(m.records[key]) > 0 {
+		return fmt.Errorf("%s record for %s already exists", rec.Type, rec.Name)
+	}
+	m.records[key] = []Record{normalizeRecord(rec)}
+	return nil
+}
+
+func (m *MemoryProvider) UpdateRecord(rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[memoryKey(rec.Name, rec.Type)] = []Record{normalizeRecord(rec)}
+	return nil
+}
+
+func (m *MemoryProvider) DeleteRecord(name, recordType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, memoryKey(name, recordType))
+	return nil
+}
+
+func (m *MemoryProvider) ListRecords() ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []Record{}
+	for _, recs := range m.records {
+		out = append(out, recs...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out, nil
+}
+
+func normalizeRecord(rec Record) Record {
+	rec.Name = strings.ToLower(strings.TrimSuffix(rec.Name, "."))
+	rec.Type = strings.ToUpper(rec.Type)
+	if rec.TTL <= 0 {
+		rec.TTL = defaultTTL
+	}
+	return rec
+}
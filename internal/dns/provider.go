@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/den/internal/config"
+)
+
+// Record is a DNS record as the providers see it. Name is the full
+// hostname without a trailing dot.
+type Record struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// Provider manages records in the zone den hands out names from.
+type Provider interface {
+	Name() string
+	// CreateRecord adds a record. It may fail if one with the same name and
+	// type already exists.
+	CreateRecord(rec Record) error
+	// UpdateRecord replaces the records with the same name and type, or
+	// creates one if there are none.
+	UpdateRecord(rec Record) error
+	// DeleteRecord removes the records with the name and type. Deleting a
+	// record that doesn't exist is not an error.
+	DeleteRecord(name, recordType string) error
+	ListRecords() ([]Record, error)
+}
+
+const defaultTTL = 300
+
+const (
+	ModeRecords  = "records"
+	ModeWildcard = "wildcard"
+)
+
+// Config picks and sets up the DNS provider.
+type Config struct {
+	// Provider is cloudflare, rfc2136 or memory.
+	Provider string
+	Domain   string
+	// Mode is records (a record per subdomain) or wildcard, where the zone
+	// has *.Domain and only Caddy routes are managed per subdomain.
+	Mode string
+	// CaddyAskURL is the master endpoint Caddy asks before getting an
+	// on-demand certificate for a custom domain.
+	CaddyAskURL string
+
+	CloudflareAPIToken string
+	CloudflareZoneID   string
+
+	// RFC2136Server is the primary's host:port, RFC2136Zone the zone to
+	// update (defaults to Domain). TSIG is used when a key name is set.
+	RFC2136Server        string
+	RFC2136Zone          string
+	RFC2136TSIGKey       string
+	RFC2136TSIGSecret    string
+	RFC2136TSIGAlgorithm string
+}
+
+// ConfigFromEnv takes the domain from DEN_DOMAIN and reads DNS_PROVIDER
+// (default cloudflare), DNS_MODE (default records), CADDY_ASK_URL,
+// CLOUDFLARE_API_TOKEN, CLOUDFLARE_ZONE_ID and the RFC2136_* variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Provider:             strings.ToLower(strings.TrimSpace(os.Getenv("DNS_PROVIDER"))),
+		Domain:               config.FromEnv().Domain,
+		Mode:                 strings.ToLower(strings.TrimSpace(os.Getenv("DNS_MODE"))),
+		CaddyAskURL:          os.Getenv("CADDY_ASK_URL"),
+		CloudflareAPIToken:   os.Getenv("CLOUDFLARE_API_TOKEN"),
+		CloudflareZoneID:     os.Getenv("CLOUDFLARE_ZONE_ID"),
+		RFC2136Server:        os.Getenv("RFC2136_SERVER"),
+		RFC2136Zone:          os.Getenv("RFC2136_ZONE"),
+		RFC2136TSIGKey:       os.Getenv("RFC2136_TSIG_KEY"),
+		RFC2136TSIGSecret:    os.Getenv("RFC2136_TSIG_SECRET"),
+		RFC2136TSIGAlgorithm: os.Getenv("RFC2136_TSIG_ALGORITHM"),
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = "cloudflare"
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeRecords
+	}
+	if cfg.CaddyAskURL == "" {
+		cfg.CaddyAskURL = "http://localhost:8080/internal/caddy/ask"
+	}
+	if cfg.RFC2136Zone == "" {
+		cfg.RFC2136Zone = cfg.Domain
+	}
+	return cfg
+}
+
+// memory is shared by every Service in the process so records made through
+// one are seen by the others, as they would be with a real provider.
+var memory = NewMemoryProvider()
+
+// NewProvider builds the provider named in cfg.
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "cloudflare":
+		return NewCloudflareService(cfg.CloudflareAPIToken, cfg.CloudflareZoneID), nil
+	case "rfc2136":
+		return NewRFC2136Provider(cfg.RFC2136Server, cfg.RFC2136Zone, cfg.RFC2136TSIGKey, cfg.RFC2136TSIGSecret, cfg.RFC2136TSIGAlgorithm)
+	case "memory":
+		return memory, nil
+	default:
+		return nil, fmt.Errorf("unknown DNS provider %q", cfg.Provider)
+	}
+}
+
+// unavailableProvider stands in when the configured provider can't be set
+// up, so the rest of den still starts and DNS changes fail with the reason.
+type unavailableProvider struct {
+	name string
+	err  error
+}
+
+func (u unavailableProvider) Name() string { return u.name }
+
+func (u unavailableProvider) CreateRecord(Record) error { return u.unavailable() }
+
+func (u unavailableProvider) UpdateRecord(Record) error { return u.unavailable() }
+
+func (u unavailableProvider) DeleteRecord(string, string) error { return u.unavailable() }
+
+func (u unavailableProvider) ListRecords() ([]Record, error) { return nil, u.unavailable() }
+
+func (u unavailableProvider) unavailable() error {
+	return fmt.Errorf("dns provider %s not configured: %w", u.name, u.err)
+}
@@ -0,0 +1,404 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RFC2136Provider updates a zone on an authoritative server with dynamic
+// updates (RFC 2136), signed with TSIG (RFC 8945) when a key is set. Records
+// are listed with a zone transfer, so the key must be allowed AXFR too.
+//
+// Responses are checked for their rcode but their TSIG is not verified.
+type RFC2136Provider struct {
+	server    string
+	zone      string
+	keyName   string
+	secret    []byte
+	algorithm string
+	timeout   time.Duration
+}
+
+const (
+	typeTSIG  = 250
+	tsigFudge = 300
+)
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// NewRFC2136Provider returns a provider for zone on server (host or
+// host:port). secret is the base64 TSIG secret; algorithm defaults to
+// hmac-sha256.
+func NewRFC2136Provider(server, zone, keyName, secret, algorithm string) (*RFC2136Provider, error) {
+	if server == "" {
+		return nil, fmt.Errorf("RFC2136_SERVER not configured")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	p := &RFC2136Provider{
+		server:  server,
+		zone:    fqdn(zone),
+		timeout: 10 * time.Second,
+	}
+	if keyName == "" {
+		return p, nil
+	}
+	p.keyName = fqdn(keyName)
+	p.algorithm = strings.ToLower(strings.TrimSuffix(algorithm, "."))
+	if p.algorithm == "" {
+		p.algorithm = "hmac-sha256"
+	}
+	if _, ok := tsigAlgorithms[p.algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q", algorithm)
+	}
+	var err error
+	if p.secret, err = base64.StdEncoding.DecodeString(secret); err != nil || len(p.secret) == 0 {
+		return nil, fmt.Errorf("RFC2136_TSIG_SECRET must be base64")
+	}
+	return p, nil
+}
+
+func (p *RFC2136Provider) Name() string { return "rfc2136" }
+
+func (p *RFC2136Provider) CreateRecord(rec Record) error {
+	if err := p.update(func(b *dnsmessage.Builder) error {
+		return addRecord(b, rec)
+	}); err != nil {
+		return fmt.Errorf("create %s record %s: %w", rec.Type, rec.Name, err)
+	}
+	fmt.Printf("created DNS record: %s %s -> %s\n", rec.Type, rec.Name, rec.Content)
+	return nil
+}
+
+func (p *RFC2136Provider) UpdateRecord(rec Record) error {
+	// both changes are in one message, which the server applies atomically
+	if err := p.update(func(b *dnsmessage.Builder) error {
+		if err := deleteRRset(b, rec.Name, rec.Type); err != nil {
+			return err
+		}
+		return addRecord(b, rec)
+	}); err != nil {
+		return fmt.Errorf("update %s record %s: %w", rec.Type, rec.Name, err)
+	}
+	fmt.Printf("updated dns record: %s %s -> %s\n", rec.Type, rec.Name, rec.Content)
+	return nil
+}
+
+func (p *RFC2136Provider) DeleteRecord(name, recordType string) error {
+	if err := p.update(func(b *dnsmessage.Builder) error {
+		return deleteRRset(b, name, recordType)
+	}); err != nil {
+		return fmt.Errorf("delete %s record %s: %w", recordType, name, err)
+	}
+	fmt.Printf("deleted DNS record: %s\n", name)
+	return nil
+}
+
+func (p *RFC2136Provider) ListRecords() ([]Record, error) {
+	zone, err := dnsmessage.NewName(p.zone)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(rand.Intn(1 << 16))})
+	_ = b.StartQuestions()
+	if err := b.Question(dnsmessage.Question{Name: zone, Type: dnsmessage.TypeAXFR, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.send(msg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	out := []Record{}
+	soas := 0
+	for soas < 2 {
+		resp, err := readMessage(conn)
+		if err != nil {
+			return nil, fmt.Errorf("zone transfer: %w", err)
+		}
+		var parser dnsmessage.Parser
+		h, err := parser.Start(resp)
+		if err != nil {
+			return nil, err
+		}
+		if h.RCode != dnsmessage.RCodeSuccess {
+			return nil, fmt.Errorf("zone transfer refused: %s", h.RCode)
+		}
+		if err := parser.SkipAllQuestions(); err != nil {
+			return nil, err
+		}
+		answers, err := parser.AllAnswers()
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) == 0 {
+			return nil, fmt.Errorf("zone transfer ended early")
+		}
+		for _, a := range answers {
+			if _, ok := a.Body.(*dnsmessage.SOAResource); ok {
+				soas++
+				continue
+			}
+			if rec, ok := fromResource(a); ok {
+				out = append(out, rec)
+			}
+		}
+	}
+	return out, nil
+}
+
+// update sends an UPDATE for the zone with the changes written by fn and
+// checks the server accepted it.
+func (p *RFC2136Provider) update(fn func(b *dnsmessage.Builder) error) error {
+	msg, err := p.updateMessage(fn)
+	if err != nil {
+		return err
+	}
+	conn, err := p.send(msg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+	var parser dnsmessage.Parser
+	h, err := parser.Start(resp)
+	if err != nil {
+		return err
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("server refused update: %s", h.RCode)
+	}
+	return nil
+}
+
+// updateMessage builds an unsigned UPDATE for the zone. In an UPDATE the
+// question is the zone and the authority section the changes; there are no
+// prerequisites.
+func (p *RFC2136Provider) updateMessage(fn func(b *dnsmessage.Builder) error) ([]byte, error) {
+	zone, err := dnsmessage.NewName(p.zone)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), OpCode: 5})
+	_ = b.StartQuestions()
+	if err := b.Question(dnsmessage.Question{Name: zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	_ = b.StartAnswers()
+	_ = b.StartAuthorities()
+	if err := fn(&b); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// send signs msg if there is a key and writes it to a new TCP connection.
+func (p *RFC2136Provider) send(msg []byte) (net.Conn, error) {
+	if p.keyName != "" {
+		var err error
+		if msg, err = p.sign(msg, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.DialTimeout("tcp", p.server, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	frame := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	if _, err := conn.Write(append(frame, msg...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sign appends a TSIG record to msg. The MAC covers the message as it was
+// before the record was added, followed by the TSIG variables.
+func (p *RFC2136Provider) sign(msg []byte, now time.Time) ([]byte, error) {
+	keyName, err := wireName(p.keyName)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := wireName(p.algorithm + ".")
+	if err != nil {
+		return nil, err
+	}
+	var signed [6]byte
+	secs := uint64(now.Unix())
+	binary.BigEndian.PutUint16(signed[0:2], uint16(secs>>32))
+	binary.BigEndian.PutUint32(signed[2:6], uint32(secs))
+
+	mac := hmac.New(tsigAlgorithms[p.algorithm], p.secret)
+	mac.Write(msg)
+	mac.Write(keyName)
+	mac.Write([]byte{0, 255, 0, 0, 0, 0}) // class ANY, TTL 0
+	mac.Write(alg)
+	mac.Write(signed[:])
+	mac.Write([]byte{tsigFudge >> 8, tsigFudge & 0xff, 0, 0, 0, 0}) // fudge, error, other len
+	sum := mac.Sum(nil)
+
+	rdata := append([]byte{}, alg...)
+	rdata = append(rdata, signed[:]...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0], msg[1]) // original id
+	rdata = append(rdata, 0, 0, 0, 0)     // error, other len
+
+	out := append([]byte{}, msg...)
+	out = append(out, keyName...)
+	out = binary.BigEndian.AppendUint16(out, typeTSIG)
+	out = binary.BigEndian.AppendUint16(out, uint16(dnsmessage.ClassANY))
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	binary.BigEndian.PutUint16(out[10:12], binary.BigEndian.Uint16(out[10:12])+1)
+	return out, nil
+}
+
+func readMessage(r io.Reader) ([]byte, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func addRecord(b *dnsmessage.Builder, rec Record) error {
+	name, err := dnsmessage.NewName(fqdn(rec.Name))
+	if err != nil {
+		return err
+	}
+	ttl := rec.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	h := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: uint32(ttl)}
+	switch strings.ToUpper(rec.Type) {
+	case "A":
+		ip := net.ParseIP(rec.Content).To4()
+		if ip == nil {
+			return fmt.Errorf("invalid IPv4 address %q", rec.Content)
+		}
+		var a dnsmessage.AResource
+		copy(a.A[:], ip)
+		return b.AResource(h, a)
+	case "AAAA":
+		ip := net.ParseIP(rec.Content)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", rec.Content)
+		}
+		var a dnsmessage.AAAAResource
+		copy(a.AAAA[:], ip.To16())
+		return b.AAAAResource(h, a)
+	case "CNAME":
+		target, err := dnsmessage.NewName(fqdn(rec.Content))
+		if err != nil {
+			return err
+		}
+		return b.CNAMEResource(h, dnsmessage.CNAMEResource{CNAME: target})
+	case "TXT":
+		var txt []string
+		for s := rec.Content; ; s = s[255:] {
+			if len(s) <= 255 {
+				txt = append(txt, s)
+				break
+			}
+			txt = append(txt, s[:255])
+		}
+		return b.TXTResource(h, dnsmessage.TXTResource{TXT: txt})
+	default:
+		return fmt.Errorf("unsupported record type %q", rec.Type)
+	}
+}
+
+// deleteRRset writes a "delete an RRset" change, or "delete all RRsets" for
+// the name when recordType is empty.
+func deleteRRset(b *dnsmessage.Builder, name, recordType string) error {
+	n, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return err
+	}
+	t := dnsmessage.TypeALL
+	if recordType != "" {
+		var ok bool
+		if t, ok = recordTypes[strings.ToUpper(recordType)]; !ok {
+			return fmt.Errorf("unsupported record type %q", recordType)
+		}
+	}
+	return b.UnknownResource(dnsmessage.ResourceHeader{Name: n, Class: dnsmessage.ClassANY}, dnsmessage.UnknownResource{Type: t})
+}
+
+var recordTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+func fromResource(r dnsmessage.Resource) (Record, bool) {
+	rec := Record{Name: strings.TrimSuffix(r.Header.Name.String(), "."), TTL: int(r.Header.TTL)}
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		rec.Type, rec.Content = "A", net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		rec.Type, rec.Content = "AAAA", net.IP(body.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		rec.Type, rec.Content = "CNAME", strings.TrimSuffix(body.CNAME.String(), ".")
+	case *dnsmessage.TXTResource:
+		rec.Type, rec.Content = "TXT", strings.Join(body.TXT, "")
+	default:
+		return rec, false
+	}
+	return rec, true
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// wireName is name in uncompressed, lowercased wire format, as TSIG wants it.
+func wireName(name string) ([]byte, error) {
+	var out []byte
+	for _, label := range strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0), nil
+}
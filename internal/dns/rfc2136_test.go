@@ -0,0 +1,364 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testTSIGSecret = "ZGVuIHJmYzIxMzYgdGVzdCBzZWNyZXQ=" // "den rfc2136 test secret"
+
+// an UPDATE for den.test with ID 0x1234 and no changes
+var testUpdateMessage, _ = hex.DecodeString("1234280000010000000000000364656e04746573740000060001")
+
+func TestTSIGSign(t *testing.T) {
+	// the MACs were computed separately from RFC 8945 section 4.3.3: the
+	// message, then key name, class ANY, TTL 0, algorithm, time signed
+	// 1700000000, fudge 300, error 0 and other len 0
+	tests := []struct {
+		algorithm string
+		mac       string
+	}{
+		{"hmac-sha256", "72bef5a9413d8b90f1aedfa139bde63b1d7ff9e55f927c0bfd5a38a5dd5992bd"},
+		{"hmac-sha512", "5038a6b9d0449c046be8c6d9c18529798e721dc587ab5220c0f2ea20a8832434f5f8f2609119ed92ee6c7e9fd136856986cdb542889341767fd1a98ffa03b007"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			p, err := NewRFC2136Provider("127.0.0.1", "den.test", "den-key", testTSIGSecret, strings.ToUpper(tt.algorithm)+".")
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := p.sign(append([]byte{}, testUpdateMessage...), time.Unix(1700000000, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(signed[2:10], testUpdateMessage[2:10]) {
+				t.Fatal("sign changed the header")
+			}
+			if got := binary.BigEndian.Uint16(signed[10:12]); got != 1 {
+				t.Fatalf("ARCOUNT = %d, want 1", got)
+			}
+
+			var parser dnsmessage.Parser
+			if _, err := parser.Start(signed); err != nil {
+				t.Fatal(err)
+			}
+			if err := parser.SkipAllQuestions(); err != nil {
+				t.Fatal(err)
+			}
+			_ = parser.SkipAllAnswers()
+			_ = parser.SkipAllAuthorities()
+			rr, err := parser.Additional()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rr.Header.Name.String() != "den-key." || rr.Header.Type != typeTSIG || rr.Header.Class != dnsmessage.ClassANY || rr.Header.TTL != 0 {
+				t.Fatalf("unexpected TSIG header %+v", rr.Header)
+			}
+			rdata := rr.Body.(*dnsmessage.UnknownResource).Data
+			alg, _ := wireName(tt.algorithm + ".")
+			if !bytes.HasPrefix(rdata, alg) {
+				t.Fatalf("rdata does not start with the algorithm name")
+			}
+			rest := rdata[len(alg):]
+			if secs := uint64(binary.BigEndian.Uint16(rest[0:2]))<<32 | uint64(binary.BigEndian.Uint32(rest[2:6])); secs != 1700000000 {
+				t.Fatalf("time signed = %d", secs)
+			}
+			if fudge := binary.BigEndian.Uint16(rest[6:8]); fudge != tsigFudge {
+				t.Fatalf("fudge = %d", fudge)
+			}
+			macLen := int(binary.BigEndian.Uint16(rest[8:10]))
+			if got := hex.EncodeToString(rest[10 : 10+macLen]); got != tt.mac {
+				t.Fatalf("MAC = %s\nwant  %s", got, tt.mac)
+			}
+			if trailer := rest[10+macLen:]; !bytes.Equal(trailer, []byte{0x12, 0x34, 0, 0, 0, 0}) {
+				t.Fatalf("original id, error and other len = %x", trailer)
+			}
+		})
+	}
+}
+
+func TestNewRFC2136ProviderRejects(t *testing.T) {
+	if _, err := NewRFC2136Provider("", "den.test", "", "", ""); err == nil {
+		t.Error("accepted an empty server")
+	}
+	if _, err := NewRFC2136Provider("ns", "den.test", "key", testTSIGSecret, "hmac-md5"); err == nil {
+		t.Error("accepted hmac-md5")
+	}
+	if _, err := NewRFC2136Provider("ns", "den.test", "key", "not base64!", ""); err == nil {
+		t.Error("accepted a secret that isn't base64")
+	}
+	p, err := NewRFC2136Provider("ns.den.test", "den.test", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.server != "ns.den.test:53" || p.zone != "den.test." {
+		t.Fatalf("server %q zone %q", p.server, p.zone)
+	}
+}
+
+// parseUpdate checks msg is an UPDATE for zone and returns its authority
+// section. Delete-RRset changes have no rdata, which dnsmessage can't parse
+// as their type, so those come back with only the header set.
+func parseUpdate(t *testing.T, msg []byte, zone string) []dnsmessage.Resource {
+	t.Helper()
+	var parser dnsmessage.Parser
+	h, err := parser.Start(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.OpCode != 5 {
+		t.Fatalf("opcode = %d, want 5 (UPDATE)", h.OpCode)
+	}
+	qs, err := parser.AllQuestions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qs) != 1 || qs[0].Name.String() != zone || qs[0].Type != dnsmessage.TypeSOA || qs[0].Class != dnsmessage.ClassINET {
+		t.Fatalf("zone section = %+v", qs)
+	}
+	if err := parser.SkipAllAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	var out []dnsmessage.Resource
+	for {
+		rh, err := parser.AuthorityHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rh.Class == dnsmessage.ClassANY {
+			if rh.TTL != 0 || rh.Length != 0 {
+				t.Fatalf("delete change has TTL %d and %d bytes of rdata", rh.TTL, rh.Length)
+			}
+			if err := parser.SkipAuthority(); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, dnsmessage.Resource{Header: rh})
+			continue
+		}
+		r, err := parser.Authority()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, r)
+	}
+}
+
+func TestUpdateMessageRoundTrip(t *testing.T) {
+	p, err := NewRFC2136Provider("127.0.0.1", "den.test", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 300)
+	records := []Record{
+		{Name: "blog.alice.den.test", Type: "A", Content: "203.0.113.7", TTL: 60},
+		{Name: "v6.den.test", Type: "aaaa", Content: "2001:db8::1", TTL: 120},
+		{Name: "www.den.test", Type: "CNAME", Content: "alice.den.test", TTL: 300},
+		{Name: "_den-challenge.den.test", Type: "TXT", Content: long, TTL: 300},
+	}
+	for _, rec := range records {
+		t.Run(rec.Type, func(t *testing.T) {
+			msg, err := p.updateMessage(func(b *dnsmessage.Builder) error {
+				if err := deleteRRset(b, rec.Name, rec.Type); err != nil {
+					return err
+				}
+				return addRecord(b, rec)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes := parseUpdate(t, msg, "den.test.")
+			if len(changes) != 2 {
+				t.Fatalf("got %d changes, want 2", len(changes))
+			}
+			del := changes[0].Header
+			if del.Name.String() != rec.Name+"." || del.Type != recordTypes[strings.ToUpper(rec.Type)] {
+				t.Fatalf("delete change = %+v", del)
+			}
+			if changes[1].Header.Class != dnsmessage.ClassINET {
+				t.Fatalf("add change has class %s", changes[1].Header.Class)
+			}
+			got, ok := fromResource(changes[1])
+			if !ok {
+				t.Fatalf("add change has unexpected body %T", changes[1].Body)
+			}
+			want := normalizeRecord(rec)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("round trip = %+v, want %+v", got, want)
+			}
+			if rec.Type == "TXT" {
+				if txt := changes[1].Body.(*dnsmessage.TXTResource).TXT; len(txt) != 2 || len(txt[0]) != 255 {
+					t.Fatalf("long TXT split into %d strings", len(txt))
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteRRsetAllTypes(t *testing.T) {
+	p, _ := NewRFC2136Provider("127.0.0.1", "den.test", "", "", "")
+	msg, err := p.updateMessage(func(b *dnsmessage.Builder) error {
+		return deleteRRset(b, "old.den.test", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := parseUpdate(t, msg, "den.test.")
+	if len(changes) != 1 || changes[0].Header.Type != dnsmessage.TypeALL || changes[0].Header.Name.String() != "old.den.test." {
+		t.Fatalf("changes = %+v", changes)
+	}
+}
+
+func TestAddRecordRejects(t *testing.T) {
+	p, _ := NewRFC2136Provider("127.0.0.1", "den.test", "", "", "")
+	for _, rec := range []Record{
+		{Name: "a.den.test", Type: "A", Content: "2001:db8::1"},
+		{Name: "a.den.test", Type: "AAAA", Content: "192.0.2.1"},
+		{Name: "a.den.test", Type: "MX", Content: "mail.den.test"},
+	} {
+		if _, err := p.updateMessage(func(b *dnsmessage.Builder) error { return addRecord(b, rec) }); err == nil {
+			t.Errorf("%s %s accepted", rec.Type, rec.Content)
+		}
+	}
+	if _, err := p.updateMessage(func(b *dnsmessage.Builder) error { return deleteRRset(b, "a.den.test", "MX") }); err == nil {
+		t.Error("deleting an MX RRset accepted")
+	}
+}
+
+// fakePrimary is a TCP DNS server that accepts every UPDATE and answers
+// AXFR with a fixed zone split over two messages.
+type fakePrimary struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	updates [][]byte
+	rcode   dnsmessage.RCode
+}
+
+func newFakePrimary(t *testing.T) *fakePrimary {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakePrimary{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve(t)
+	return f
+}
+
+func (f *fakePrimary) serve(t *testing.T) {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			msg, err := readMessage(conn)
+			if err != nil {
+				return
+			}
+			var parser dnsmessage.Parser
+			h, err := parser.Start(msg)
+			if err != nil {
+				return
+			}
+			q, err := parser.Question()
+			if err != nil {
+				return
+			}
+			if h.OpCode == 5 {
+				f.mu.Lock()
+				f.updates = append(f.updates, msg)
+				rcode := f.rcode
+				f.mu.Unlock()
+				writeFramed(conn, response(h.ID, q, rcode, nil))
+				return
+			}
+			soa := dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns.den.test."), MBox: dnsmessage.MustNewName("admin.den.test."), Serial: 1},
+			}
+			a := dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("alice.den.test."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.AResource{A: [4]byte{203, 0, 113, 7}},
+			}
+			txt := dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("_den-challenge.blog.example.com."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.TXTResource{TXT: []string{"den-verify=", "abc"}},
+			}
+			writeFramed(conn, response(h.ID, q, dnsmessage.RCodeSuccess, []dnsmessage.Resource{soa, a}))
+			writeFramed(conn, response(h.ID, q, dnsmessage.RCodeSuccess, []dnsmessage.Resource{txt, soa}))
+		}(conn)
+	}
+}
+
+func response(id uint16, q dnsmessage.Question, rcode dnsmessage.RCode, answers []dnsmessage.Resource) []byte {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, Response: true, RCode: rcode},
+		Questions: []dnsmessage.Question{q},
+		Answers:   answers,
+	}
+	b, _ := msg.Pack()
+	return b
+}
+
+func writeFramed(conn net.Conn, msg []byte) {
+	conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+}
+
+func TestRFC2136ProviderAgainstServer(t *testing.T) {
+	srv := newFakePrimary(t)
+	p, err := NewRFC2136Provider(srv.ln.Addr().String(), "den.test", "den-key", testTSIGSecret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UpdateRecord(Record{Name: "*.den.test", Type: "A", Content: "203.0.113.7"}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if err := p.DeleteRecord("alice.den.test", "A"); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	srv.mu.Lock()
+	updates := srv.updates
+	srv.mu.Unlock()
+	if len(updates) != 2 {
+		t.Fatalf("server got %d updates, want 2", len(updates))
+	}
+	for _, msg := range updates {
+		if arcount := binary.BigEndian.Uint16(msg[10:12]); arcount != 1 {
+			t.Fatalf("update is not signed (ARCOUNT %d)", arcount)
+		}
+	}
+
+	records, err := p.ListRecords()
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	want := []Record{
+		{Name: "alice.den.test", Type: "A", Content: "203.0.113.7", TTL: 300},
+		{Name: "_den-challenge.blog.example.com", Type: "TXT", Content: "den-verify=abc", TTL: 60},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("ListRecords = %+v, want %+v", records, want)
+	}
+
+	srv.mu.Lock()
+	srv.rcode = dnsmessage.RCodeRefused
+	srv.mu.Unlock()
+	if err := p.CreateRecord(Record{Name: "x.den.test", Type: "A", Content: "192.0.2.1"}); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("CreateRecord against a refusing server: err = %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/den/internal/proxy"
+)
+
+// fakeCaddy is enough of Caddy's admin API for CaddyService: GET /config/,
+// POST /load and DELETE /id/<id>.
+type fakeCaddy struct {
+	mu     sync.Mutex
+	config map[string]interface{}
+}
+
+func newFakeCaddy(t *testing.T, domain string) (*fakeCaddy, *httptest.Server) {
+	t.Helper()
+	f := &fakeCaddy{config: map[string]interface{}{
+		"apps": map[string]interface{}{
+			"http": map[string]interface{}{
+				"servers": map[string]interface{}{
+					"srv0": map[string]interface{}{
+						"routes": []interface{}{
+							map[string]interface{}{"match": []interface{}{map[string]interface{}{"host": []interface{}{"*." + domain}}}},
+						},
+					},
+				},
+			},
+		},
+	}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeCaddy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/config/":
+		json.NewEncoder(w).Encode(f.config)
+	case r.Method == http.MethodPost && r.URL.Path == "/load":
+		var cfg map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.config = cfg
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/id/"):
+		id := strings.TrimPrefix(r.URL.Path, "/id/")
+		srv0 := f.srv0()
+		routes := srv0["routes"].([]interface{})
+		for i, route := range routes {
+			if route.(map[string]interface{})["@id"] == id {
+				srv0["routes"] = append(routes[:i:i], routes[i+1:]...)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeCaddy) srv0() map[string]interface{} {
+	return f.config["apps"].(map[string]interface{})["http"].(map[string]interface{})["servers"].(map[string]interface{})["srv0"].(map[string]interface{})
+}
+
+// hosts lists the host each route matches, in order.
+func (f *fakeCaddy) hosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, route := range f.srv0()["routes"].([]interface{}) {
+		match := route.(map[string]interface{})["match"].([]interface{})
+		out = append(out, match[0].(map[string]interface{})["host"].([]interface{})[0].(string))
+	}
+	return out
+}
+
+func newTestService(t *testing.T, wildcard bool) (*Service, *MemoryProvider, *fakeCaddy) {
+	t.Helper()
+	t.Setenv("PUBLIC_IP", "198.51.100.10")
+	caddy, srv := newFakeCaddy(t, "den.test")
+	mem := NewMemoryProvider()
+	return &Service{
+		domain:   "den.test",
+		caddy:    proxy.NewCaddyService(srv.URL, "den.test"),
+		provider: mem,
+		wildcard: wildcard,
+	}, mem, caddy
+}
+
+func TestServiceRecordsMode(t *testing.T) {
+	s, mem, caddy := newTestService(t, false)
+
+	if err := s.CreateDNSRecord("blog", "alice", "project", "10.0.0.5", 20001); err != nil {
+		t.Fatalf("CreateDNSRecord: %v", err)
+	}
+	if err := s.CreateDNSRecord("alice", "alice", "username", "10.0.0.5", 20002); err != nil {
+		t.Fatalf("CreateDNSRecord: %v", err)
+	}
+	records, _ := mem.ListRecords()
+	want := []Record{
+		{Name: "alice.den.test", Type: "A", Content: "198.51.100.10", TTL: defaultTTL},
+		{Name: "blog.alice.den.test", Type: "A", Content: "198.51.100.10", TTL: defaultTTL},
+	}
+	if len(records) != len(want) || records[0] != want[0] || records[1] != want[1] {
+		t.Fatalf("records = %+v, want %+v", records, want)
+	}
+	// managed routes go before the catch-all
+	if got := strings.Join(caddy.hosts(), " "); got != "blog.alice.den.test alice.den.test *.den.test" {
+		t.Fatalf("caddy routes = %s", got)
+	}
+
+	// a second record with the same name is refused and leaves no route
+	if err := s.CreateDNSRecord("blog", "alice", "project", "10.0.0.5", 20001); err == nil {
+		t.Fatal("created the same record twice")
+	}
+
+	if err := s.DeleteDNSRecord("blog", "alice", "project"); err != nil {
+		t.Fatalf("DeleteDNSRecord: %v", err)
+	}
+	records, _ = mem.ListRecords()
+	if len(records) != 1 || records[0].Name != "alice.den.test" {
+		t.Fatalf("records after delete = %+v", records)
+	}
+	if got := strings.Join(caddy.hosts(), " "); got != "alice.den.test *.den.test" {
+		t.Fatalf("caddy routes after delete = %s", got)
+	}
+}
+
+func TestServiceWildcardMode(t *testing.T) {
+	s, mem, caddy := newTestService(t, true)
+
+	if err := s.CreateDNSRecord("blog", "alice", "project", "10.0.0.5", 20001); err != nil {
+		t.Fatalf("CreateDNSRecord: %v", err)
+	}
+	if records, _ := mem.ListRecords(); len(records) != 0 {
+		t.Fatalf("wildcard mode created records: %+v", records)
+	}
+	if got := strings.Join(caddy.hosts(), " "); got != "blog.alice.den.test *.den.test" {
+		t.Fatalf("caddy routes = %s", got)
+	}
+
+	if err := s.DeleteDNSRecord("blog", "alice", "project"); err != nil {
+		t.Fatalf("DeleteDNSRecord: %v", err)
+	}
+	if got := strings.Join(caddy.hosts(), " "); got != "*.den.test" {
+		t.Fatalf("caddy routes after delete = %s", got)
+	}
+}
+
+func TestMemoryProvider(t *testing.T) {
+	m := NewMemoryProvider()
+	if err := m.CreateRecord(Record{Name: "A.den.test.", Type: "a", Content: "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateRecord(Record{Name: "a.den.test", Type: "A", Content: "192.0.2.2"}); err == nil {
+		t.Fatal("created a duplicate record")
+	}
+	if err := m.UpdateRecord(Record{Name: "a.den.test", Type: "A", Content: "192.0.2.3", TTL: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpdateRecord(Record{Name: "a.den.test", Type: "TXT", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := m.ListRecords()
+	want := []Record{
+		{Name: "a.den.test", Type: "A", Content: "192.0.2.3", TTL: 60},
+		{Name: "a.den.test", Type: "TXT", Content: "hello", TTL: defaultTTL},
+	}
+	if len(records) != 2 || records[0] != want[0] || records[1] != want[1] {
+		t.Fatalf("records = %+v, want %+v", records, want)
+	}
+	if err := m.DeleteRecord("a.den.test", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteRecord("missing.den.test", "A"); err != nil {
+		t.Fatalf("deleting a missing record: %v", err)
+	}
+	if records, _ := m.ListRecords(); len(records) != 1 || records[0].Type != "TXT" {
+		t.Fatalf("records after delete = %+v", records)
+	}
+}
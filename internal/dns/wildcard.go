@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// MigrateToWildcard moves the zone to wildcard mode: it points *.domain at
+// this master, checks a made-up name resolves through it, then removes the A
+// records made for each subdomain and checks those names still resolve. With
+// dryRun nothing is changed. resolver is a host:port to query instead of the
+// system resolver, ideally the zone's authoritative server so cached answers
+// don't hide a missing wildcard.
+func (s *Service) MigrateToWildcard(db *sql.DB, out io.Writer, dryRun bool, resolver string) error {
+	publicIP := s.getPublicIP()
+	wildcard := "*." + s.domain
+	lookup := newLookup(resolver)
+
+	fmt.Fprintf(out, "provider %s, %s -> %s\n", s.provider.Name(), wildcard, publicIP)
+	if !dryRun {
+		if err := s.provider.UpdateRecord(Record{Name: wildcard, Type: "A", Content: publicIP, TTL: defaultTTL}); err != nil {
+			return fmt.Errorf("failed to create wildcard record: %w", err)
+		}
+	}
+	b := make([]byte, 6)
+	_, _ = crand.Read(b)
+	probe := "den-wildcard-check-" + hex.EncodeToString(b) + "." + s.domain
+	wait := 2 * time.Minute
+	if dryRun {
+		wait = 0
+	}
+	if err := waitResolves(lookup, probe, publicIP, wait); err != nil {
+		if dryRun {
+			fmt.Fprintf(out, "wildcard not resolving yet: %v\n", err)
+		} else {
+			return fmt.Errorf("wildcard check failed, no records removed: %w", err)
+		}
+	} else {
+		fmt.Fprintf(out, "wildcard ok: %s -> %s\n", probe, publicIP)
+	}
+
+	names, err := s.subdomainNames(db)
+	if err != nil {
+		return err
+	}
+	records, err := s.provider.ListRecords()
+	if err != nil {
+		return fmt.Errorf("failed to list records: %w", err)
+	}
+
+	var removed, failed int
+	for _, rec := range records {
+		name := strings.ToLower(strings.TrimSuffix(rec.Name, "."))
+		if rec.Type != "A" || !names[name] {
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(out, "would remove %s A %s\n", name, rec.Content)
+			removed++
+			continue
+		}
+		if err := s.provider.DeleteRecord(name, "A"); err != nil {
+			fmt.Fprintf(out, "failed to remove %s: %v\n", name, err)
+			failed++
+			continue
+		}
+		// the record is gone, so an answer can only come from the wildcard
+		// (or a cache, when checking through a recursive resolver)
+		if err := waitResolves(lookup, name, publicIP, 30*time.Second); err != nil {
+			fmt.Fprintf(out, "removed %s but it does not resolve through the wildcard: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "removed %s, resolves to %s\n", name, publicIP)
+		removed++
+	}
+
+	if dryRun {
+		fmt.Fprintf(out, "dry run: %d records would be removed\n", removed)
+		return nil
+	}
+	fmt.Fprintf(out, "%d records removed, %d failed\n", removed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d subdomains need attention", failed)
+	}
+	fmt.Fprintf(out, "set DNS_MODE=%s on every master\n", ModeWildcard)
+	return nil
+}
+
+// subdomainNames is the hostname of every subdomain row, including inactive
+// ones whose records may have been left behind.
+func (s *Service) subdomainNames(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT s.subdomain, s.subdomain_type, u.username
+		FROM subdomains s JOIN users u ON s.user_id = u.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subdomains: %w", err)
+	}
+	defer rows.Close()
+	names := map[string]bool{}
+	for rows.Next() {
+		var subdomain, subdomainType, username string
+		if err := rows.Scan(&subdomain, &subdomainType, &username); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(s.fullDomain(subdomain, username, subdomainType))] = true
+	}
+	return names, rows.Err()
+}
+
+func newLookup(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// waitResolves polls until name resolves to ip or the wait is up, allowing
+// for the provider taking a moment to publish a change.
+func waitResolves(r *net.Resolver, name, ip string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addrs, err := r.LookupHost(ctx, name)
+		cancel()
+		if err == nil {
+			for _, a := range addrs {
+				if a == ip {
+					return nil
+				}
+			}
+			err = fmt.Errorf("resolves to %s", strings.Join(addrs, ", "))
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// auditChange is one field of an audit diff.
+func auditChange(from, to interface{}) gin.H {
+	return gin.H{"from": from, "to": to}
+}
+
+// audit records a privileged action. It never fails the request: a broken
+// audit write is logged and the action still goes through.
+func (h *Handler) audit(c *gin.Context, action, targetType, targetID string, changes gin.H) {
+	var actorID *int
+	var actorName *string
+	if v, ok := c.Get("user"); ok {
+		if u, ok := v.(*models.User); ok {
+			actorID, actorName = &u.ID, &u.Username
+		}
+	}
+	var diff interface{}
+	if len(changes) > 0 {
+		b, err := json.Marshal(changes)
+		if err != nil { log.Printf("audit: marshal %s: %v", action, err) } else { diff = string(b) }
+	}
+	_, err := h.db.Exec(`
+		INSERT INTO audit_log (actor_id, actor_username, action, target_type, target_id, request_id, ip_address, changes)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8)
+	`, actorID, actorName, action, targetType, targetID, c.GetString("request_id"), c.ClientIP(), diff)
+	if err != nil { log.Printf("audit: failed to record %s on %s %s: %v", action, targetType, targetID, err) }
+}
+
+// auditQuery builds the WHERE clause shared by the list and export endpoints.
+func auditQuery(c *gin.Context) (string, []interface{}, error) {
+	where := ` WHERE 1=1`
+	args := []interface{}{}
+	if v := c.Query("actor"); v != "" {
+		if id, err := strconv.Atoi(v); err == nil {
+			args = append(args, id)
+			where += ` AND actor_id = $` + strconv.Itoa(len(args))
+		} else {
+			args = append(args, v)
+			where += ` AND actor_username = $` + strconv.Itoa(len(args))
+		}
+	}
+	for _, f := range []string{"action", "target_type", "target_id", "request_id"} {
+		if v := c.Query(f); v != "" {
+			args = append(args, v)
+			where += ` AND ` + f + ` = $` + strconv.Itoa(len(args))
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		v := c.Query(f.param)
+		if v == "" { continue }
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil { return "", nil, fmt.Errorf("invalid %s, want RFC 3339", f.param) }
+		args = append(args, t)
+		where += ` AND created_at ` + f.op + ` $` + strconv.Itoa(len(args))
+	}
+	return where, args, nil
+}
+
+const auditColumns = `id, actor_id, actor_username, action, target_type, target_id, request_id, ip_address, changes, created_at`
+
+func scanAuditEntry(rows interface{ Scan(...interface{}) error }) (models.AuditEntry, error) {
+	var e models.AuditEntry
+	err := rows.Scan(&e.ID, &e.ActorID, &e.ActorUsername, &e.Action, &e.TargetType, &e.TargetID, &e.RequestID, &e.IPAddress, &e.Changes, &e.CreatedAt)
+	return e, err
+}
+
+// AdminListAudit pages through the audit log, newest first.
+func (h *Handler) AdminListAudit(c *gin.Context) {
+	where, args, err := auditQuery(c)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	page, perPage := 1, 50
+	if v, err := strconv.Atoi(c.Query("page")); err == nil && v > 0 { page = v }
+	if v, err := strconv.Atoi(c.Query("per_page")); err == nil && v > 0 && v <= 500 { perPage = v }
+
+	var total int
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+
+	args = append(args, perPage, (page-1)*perPage)
+	rows, err := h.db.Query(`SELECT `+auditColumns+` FROM audit_log`+where+
+		` ORDER BY created_at DESC, id DESC LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args)), args...)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+		entries = append(entries, e)
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "total": total, "page": page, "per_page": perPage})
+}
+
+// AdminExportAuditCSV streams every matching entry; it takes the same filters
+// as AdminListAudit but ignores pagination.
+func (h *Handler) AdminExportAuditCSV(c *gin.Context) {
+	where, args, err := auditQuery(c)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	rows, err := h.db.Query(`SELECT `+auditColumns+` FROM audit_log`+where+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s.csv", time.Now().UTC().Format("20060102-150405")))
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "created_at", "actor_id", "actor_username", "action", "target_type", "target_id", "request_id", "ip_address", "changes"})
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil { log.Printf("audit export: %v", err); break }
+		changes := ""
+		if e.Changes != nil {
+			b, _ := json.Marshal(e.Changes)
+			changes = string(b)
+		}
+		actorID := ""
+		if e.ActorID != nil { actorID = strconv.Itoa(*e.ActorID) }
+		w.Write([]string{
+			strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(time.RFC3339), actorID, deref(e.ActorUsername),
+			e.Action, e.TargetType, deref(e.TargetID), deref(e.RequestID), deref(e.IPAddress), changes,
+		})
+	}
+	w.Flush()
+}
+
+func deref(s *string) string {
+	if s == nil { return "" }
+	return *s
+}
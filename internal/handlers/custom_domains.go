@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/den/internal/dns"
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const customDomainColumns = `id, user_id, hostname, target_port, verification_token, status, verification_error,
+	verified_at, last_checked_at, cert_status, cert_error, cert_expires_at, cert_checked_at, created_at`
+
+func scanCustomDomain(row interface{ Scan(...interface{}) error }) (models.CustomDomain, error) {
+	var d models.CustomDomain
+	err := row.Scan(&d.ID, &d.UserID, &d.Hostname, &d.TargetPort, &d.VerificationToken, &d.Status, &d.VerificationError,
+		&d.VerifiedAt, &d.LastCheckedAt, &d.CertStatus, &d.CertError, &d.CertExpiresAt, &d.CertCheckedAt, &d.CreatedAt)
+	return d, err
+}
+
+func (h *Handler) listCustomDomains(userID int) ([]models.CustomDomain, error) {
+	rows, err := h.db.Query(`SELECT `+customDomainColumns+` FROM custom_domains WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	domains := []models.CustomDomain{}
+	for rows.Next() {
+		d, err := scanCustomDomain(rows)
+		if err != nil { return nil, err }
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// customDomainLimit is how many custom domains the user's plan allows. Plans
+// without their own limits use the default plan's.
+func (h *Handler) customDomainLimit(userID int) int {
+	var limit int
+	if err := h.db.QueryRow(`
+		SELECT COALESCE(p.max_custom_domains, d.max_custom_domains, 0)
+		FROM users u
+		LEFT JOIN plan_limits p ON p.plan = u.plan
+		LEFT JOIN plan_limits d ON d.plan = 'default'
+		WHERE u.id = $1
+	`, userID).Scan(&limit); err != nil { return 0 }
+	return limit
+}
+
+// customDomainProps is what the subdomains page needs to list custom domains
+// and explain how to verify them.
+func (h *Handler) customDomainProps(user *models.User) gin.H {
+	domains, err := h.listCustomDomains(user.ID)
+	if err != nil { domains = []models.CustomDomain{} }
+	return gin.H{
+		"domains":          domains,
+		"limit":            h.customDomainLimit(user.ID),
+		"cname_target":     h.dns.CustomDomainTarget(user.Username),
+		"challenge_prefix": dns.ChallengePrefix,
+	}
+}
+
+func (h *Handler) ListCustomDomains(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	c.JSON(http.StatusOK, h.customDomainProps(user))
+}
+
+func (h *Handler) enqueueCustomDomainCheck(userID, domainID int) (int, error) {
+	jb, _ := json.Marshal(map[string]int{"custom_domain_id": domainID})
+	var jobID int
+	// DNS changes take a while to show up, so give it a few tries before
+	// leaving it to the periodic check
+	err := h.db.QueryRow(`INSERT INTO jobs (type, status, payload, user_id, max_attempts) VALUES ('verify_custom_domain','queued',$1,$2,6) RETURNING id`, string(jb), userID).Scan(&jobID)
+	return jobID, err
+}
+
+func (h *Handler) CreateCustomDomain(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	if rejectSuspended(c, user) { return }
+	var req struct {
+		Hostname   string `json:"hostname" binding:"required"`
+		TargetPort int    `json:"target_port" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	hostname, err := h.dns.NormalizeCustomDomain(req.Hostname)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if user.ContainerID == nil { c.JSON(http.StatusBadRequest, gin.H{"error": "no container available"}); return }
+	var ports pq.Int64Array
+	if err := h.db.QueryRow("SELECT allocated_ports FROM containers WHERE id = $1", *user.ContainerID).Scan(&ports); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get allocated ports"}); return }
+	allocated := make([]int, len(ports))
+	for i, p := range ports { allocated[i] = int(p) }
+	if err := h.dns.ValidateUserPort(req.TargetPort, allocated); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+
+	// unverified claims on a name don't block anyone, the first to verify wins
+	var taken bool
+	if err := h.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM custom_domains WHERE hostname = $1 AND status = 'verified' AND user_id <> $2)`, hostname, user.ID).Scan(&taken); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"}); return }
+	if taken { c.JSON(http.StatusConflict, gin.H{"error": "domain is already in use"}); return }
+
+	limit := h.customDomainLimit(user.ID)
+	var count int
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM custom_domains WHERE user_id = $1`, user.ID).Scan(&count); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"}); return }
+	if count >= limit { c.JSON(http.StatusForbidden, gin.H{"error": "custom domain limit reached for your plan", "limit": limit}); return }
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"}); return }
+	d, err := scanCustomDomain(h.db.QueryRow(`
+		INSERT INTO custom_domains (user_id, hostname, target_port, verification_token)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+customDomainColumns, user.ID, hostname, req.TargetPort, hex.EncodeToString(b)))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { c.JSON(http.StatusConflict, gin.H{"error": "domain already added"}); return }
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add domain"}); return
+	}
+	jobID, err := h.enqueueCustomDomainCheck(user.ID, d.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue verification"}); return }
+	c.JSON(http.StatusCreated, gin.H{
+		"domain": d,
+		"job_id": jobID,
+		"verification": gin.H{
+			"txt_name":     dns.ChallengePrefix + "." + hostname,
+			"txt_value":    "den-verify=" + d.VerificationToken,
+			"cname_target": h.dns.CustomDomainTarget(user.Username),
+		},
+	})
+}
+
+// VerifyCustomDomain queues another ownership and certificate check.
+func (h *Handler) VerifyCustomDomain(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	if rejectSuspended(c, user) { return }
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid domain id"}); return }
+	var status string
+	if err := h.db.QueryRow(`SELECT status FROM custom_domains WHERE id = $1 AND user_id = $2`, id, user.ID).Scan(&status); err != nil { c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"}); return }
+	// a failed domain gets a fresh window
+	if status == "failed" {
+		if _, err := h.db.Exec(`UPDATE custom_domains SET status = 'pending', pending_since = NOW(), ownership_failures = 0, updated_at = NOW() WHERE id = $1`, id); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"}); return }
+	}
+	jobID, err := h.enqueueCustomDomainCheck(user.ID, id)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue verification"}); return }
+	c.JSON(http.StatusAccepted, gin.H{"job_id": jobID})
+}
+
+func (h *Handler) DeleteCustomDomain(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid domain id"}); return }
+	var hostname, status string
+	err = h.db.QueryRow(`DELETE FROM custom_domains WHERE id = $1 AND user_id = $2 RETURNING hostname, status`, id, user.ID).Scan(&hostname, &status)
+	if err == sql.ErrNoRows { c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"}); return }
+	if status == "verified" {
+		if err := h.dns.RemoveCustomDomainRoute(hostname); err != nil { log.Printf("failed to remove route for %s: %v", hostname, err) }
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "domain removed"})
+}
+
+// CaddyAsk is Caddy's on_demand ask endpoint: it only lets Caddy get a
+// certificate for verified custom domains of users who aren't suspended.
+// Caddy sends the name as ?domain=.
+func (h *Handler) CaddyAsk(c *gin.Context) {
+	hostname := strings.ToLower(strings.TrimSuffix(c.Query("domain"), "."))
+	var ok bool
+	err := h.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM custom_domains d JOIN users u ON d.user_id = u.id
+			WHERE d.hostname = $1 AND d.status = 'verified' AND u.suspended_at IS NULL)
+	`, hostname).Scan(&ok)
+	if err != nil { c.Status(http.StatusInternalServerError); return }
+	if !ok { c.Status(http.StatusNotFound); return }
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) AdminListPlanLimits(c *gin.Context) {
+	rows, err := h.db.Query(`SELECT plan, max_custom_domains, created_at, updated_at FROM plan_limits ORDER BY plan`)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	limits := []models.PlanLimits{}
+	for rows.Next() {
+		var l models.PlanLimits
+		if err := rows.Scan(&l.Plan, &l.MaxCustomDomains, &l.CreatedAt, &l.UpdatedAt); err == nil {
+			limits = append(limits, l)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"limits": limits})
+}
+
+// AdminUpsertPlanLimits sets a plan's quotas.
+func (h *Handler) AdminUpsertPlanLimits(c *gin.Context) {
+	plan := c.Param("plan")
+	if !planNamePattern.MatchString(plan) { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan name"}); return }
+	var req struct {
+		MaxCustomDomains *int `json:"max_custom_domains" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if *req.MaxCustomDomains < 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "max_custom_domains must not be negative"}); return }
+	var prev *int
+	var old int
+	if err := h.db.QueryRow(`SELECT max_custom_domains FROM plan_limits WHERE plan = $1`, plan).Scan(&old); err == nil { prev = &old }
+	l := models.PlanLimits{Plan: plan, MaxCustomDomains: *req.MaxCustomDomains}
+	err := h.db.QueryRow(`
+		INSERT INTO plan_limits (plan, max_custom_domains) VALUES ($1, $2)
+		ON CONFLICT (plan) DO UPDATE SET max_custom_domains = EXCLUDED.max_custom_domains, updated_at = NOW()
+		RETURNING created_at, updated_at
+	`, l.Plan, l.MaxCustomDomains).Scan(&l.CreatedAt, &l.UpdatedAt)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save limits"}); return }
+	changes := gin.H{"max_custom_domains": auditChange(nil, l.MaxCustomDomains)}
+	if prev != nil { changes = gin.H{"max_custom_domains": auditChange(*prev, l.MaxCustomDomains)} }
+	h.audit(c, "plan_limits.upsert", "plan_limits", plan, changes)
+	c.JSON(http.StatusOK, gin.H{"limits": l})
+}
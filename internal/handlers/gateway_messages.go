@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/den/internal/models"
+	denssh "github.com/den/internal/ssh"
+	"github.com/gin-gonic/gin"
+)
+
+type gatewayMessage struct {
+	Name      string     `json:"name"`
+	Body      string     `json:"body"`
+	Default   string     `json:"default"`
+	IsDefault bool       `json:"is_default"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// AdminListGatewayMessages returns every SSH gateway message with its current
+// and built-in template. Templates can use {{.Username}}, {{.ContainerID}},
+// {{.ContainerStatus}}, {{.NodeHostname}}, {{.DashboardURL}} and {{.MaxSessions}}.
+func (h *Handler) AdminListGatewayMessages(c *gin.Context) {
+	overrides := map[string]gatewayMessage{}
+	rows, err := h.db.Query(`SELECT name, body, updated_at FROM gateway_messages`)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	for rows.Next() {
+		var m gatewayMessage
+		var updatedAt time.Time
+		if err := rows.Scan(&m.Name, &m.Body, &updatedAt); err == nil {
+			m.UpdatedAt = &updatedAt
+			overrides[m.Name] = m
+		}
+	}
+	messages := []gatewayMessage{}
+	for _, name := range denssh.GatewayMessageNames() {
+		def, _ := denssh.DefaultGatewayMessage(name)
+		m, ok := overrides[name]
+		if !ok {
+			m = gatewayMessage{Name: name, Body: def, IsDefault: true}
+		}
+		m.Default = def
+		messages = append(messages, m)
+	}
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+func (h *Handler) AdminUpdateGatewayMessage(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	name := c.Param("name")
+	if _, ok := denssh.DefaultGatewayMessage(name); !ok { c.JSON(http.StatusNotFound, gin.H{"error": "unknown message"}); return }
+	var req struct{ Body *string `json:"body" binding:"required"` }
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "body is required"}); return }
+	if err := denssh.ValidateGatewayMessage(*req.Body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid template: " + err.Error()}); return }
+	var prev *string
+	_ = h.db.QueryRow(`SELECT body FROM gateway_messages WHERE name = $1`, name).Scan(&prev)
+	_, err := h.db.Exec(`
+		INSERT INTO gateway_messages (name, body, updated_by) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET body = EXCLUDED.body, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+	`, name, *req.Body, admin.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save message"}); return }
+	h.audit(c, "gateway_message.update", "gateway_message", name, gin.H{"body": auditChange(prev, *req.Body)})
+	c.JSON(http.StatusOK, gin.H{"message": "saved"})
+}
+
+// AdminResetGatewayMessage drops the override so the built-in template is used again.
+func (h *Handler) AdminResetGatewayMessage(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := denssh.DefaultGatewayMessage(name); !ok { c.JSON(http.StatusNotFound, gin.H{"error": "unknown message"}); return }
+	if _, err := h.db.Exec(`DELETE FROM gateway_messages WHERE name = $1`, name); err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset message"}); return
+	}
+	h.audit(c, "gateway_message.reset", "gateway_message", name, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "reset to default"})
+}
@@ -21,29 +21,45 @@ import (
 	
 	"github.com/lib/pq"
 	denemail "github.com/den/internal/email"
+	denssh "github.com/den/internal/ssh"
 
 	"github.com/den/internal/auth"
+	"github.com/den/internal/config"
 	"github.com/den/internal/database"
 	"github.com/den/internal/dns"
+	"github.com/den/internal/jobs"
 	"github.com/den/internal/models"
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	auth *auth.Service
-	db   *database.DB
-	dns  *dns.Service
+	auth    *auth.Service
+	cfg     config.Config
+	db      *database.DB
+	dns     *dns.Service
+	gateway *denssh.Gateway
+	jobFeed *jobs.Feed
 }
 
-func New(authService *auth.Service, db *database.DB) *Handler {
+func New(authService *auth.Service, db *database.DB, gateway *denssh.Gateway) *Handler {
 	return &Handler{
-		auth: authService,
-		db:   db,
-		dns:  dns.NewService(),
+		auth:    authService,
+		cfg:     config.FromEnv(),
+		db:      db,
+		dns:     dns.NewService(),
+		gateway: gateway,
+		jobFeed: jobs.NewFeed(database.URL()),
 	}
 }
 
 func (h *Handler) inertia(c *gin.Context, component string, props gin.H) {
+	if props == nil {
+		props = gin.H{}
+	}
+	// every page can show hostnames under the configured domain
+	if _, ok := props["domain"]; !ok {
+		props["domain"] = h.cfg.Domain
+	}
 	page := gin.H{
 		"component": component,
 		"props":     props,
@@ -70,6 +86,10 @@ func (h *Handler) inertia(c *gin.Context, component string, props gin.H) {
 
 func (h *Handler) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if token := bearerToken(c); auth.IsAccessToken(token) {
+			h.authenticateAccessToken(c, token)
+			return
+		}
 		sessionID, err := c.Cookie("session")
 		if err != nil {
 			c.Redirect(http.StatusFound, "/login")
@@ -79,11 +99,17 @@ func (h *Handler) RequireAuth() gin.HandlerFunc {
 
 		user, err := h.auth.GetUserBySession(sessionID)
 		if err != nil {
+			if _, perr := h.auth.GetPendingMFAUser(sessionID); perr == nil {
+				c.Redirect(http.StatusFound, "/login/2fa")
+				c.Abort()
+				return
+			}
 			c.SetCookie("session", "", -1, "/", "", false, true)
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
 		}
+		h.auth.TouchSession(sessionID, c.ClientIP())
 
         c.Set("user", user)
         if !user.AgreedToTOS || !user.AgreedToPrivacy {
@@ -330,19 +356,29 @@ func (h *Handler) RequireAdmin() gin.HandlerFunc {
 			return
 		}
 
-		u := user.(*models.User)
-		if !u.IsAdmin {
+		// any role gets into the admin area; RequirePermission narrows it per route
+		permissions := auth.Permissions(user.(*models.User))
+		if len(permissions) == 0 {
 			c.JSON(http.StatusForbidden, gin.H{"error": "admin required"})
 			c.Abort()
 			return
 		}
+		if !user.(*models.User).TOTPEnabled && h.auth.RequireAdminTOTP() {
+			if c.Request.Method == http.MethodGet && c.FullPath() == "/admin/" {
+				c.Redirect(http.StatusFound, "/user/2fa?required=1")
+			} else {
+				c.JSON(http.StatusForbidden, gin.H{"error": auth.ErrTOTPRequiredForRole.Error(), "enroll": "/user/2fa"})
+			}
+			c.Abort()
+			return
+		}
 
+		c.Set("permissions", permissions)
 		c.Next()
 	}
 }
 func (h *Handler) AdminExportUserContainer(c *gin.Context) {
     user := c.MustGet("user").(*models.User)
-    if !user.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
     idStr := c.Param("id")
     targetUserID, err := strconv.Atoi(idStr)
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
@@ -380,9 +416,10 @@ func (h *Handler) AdminExportUserContainer(c *gin.Context) {
         "target_username": targetUsername,
     }
     jb, _ := json.Marshal(payload)
-    if _, err := h.db.Exec(`INSERT INTO jobs (type, status, payload) VALUES ('export_container','queued',$1)`, string(jb)); err != nil {
+    if _, err := h.db.Exec(`INSERT INTO jobs (type, status, payload, user_id) VALUES ('export_container','queued',$1,$2)`, string(jb), targetUserID); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job"}); return
     }
+    h.audit(c, "container.export", "user", idStr, gin.H{"export_id": exportID, "container_id": containerID, "ttl_days": req.TTLDays, "email_user": req.EmailUser})
     c.JSON(http.StatusOK, gin.H{"export_id": exportID, "queued": true, "expires_at": expiresAt})
 }
 func (h *Handler) UserExportContainer(c *gin.Context) {
@@ -412,7 +449,7 @@ func (h *Handler) UserExportContainer(c *gin.Context) {
         "ttl_days":      req.TTLDays,
     }
     jb, _ := json.Marshal(payload)
-    if _, err := h.db.Exec(`INSERT INTO jobs (type, status, payload) VALUES ('export_container','queued',$1)`, string(jb)); err != nil {
+    if _, err := h.db.Exec(`INSERT INTO jobs (type, status, payload, user_id) VALUES ('export_container','queued',$1,$2)`, string(jb), user.ID); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job"}); return
     }
     go func(u *models.User, contID string, expires time.Time) {
@@ -464,11 +501,16 @@ func (h *Handler) RequireCLIAuth() gin.HandlerFunc {
         var containerID string
         var userID int
         var nodeHostname string
-        err := h.db.QueryRow(`SELECT c.id, c.user_id, n.hostname FROM containers c JOIN nodes n ON c.node_id = n.id WHERE c.container_token = $1`, token).Scan(&containerID, &userID, &nodeHostname)
+        var suspended bool
+        err := h.db.QueryRow(`SELECT c.id, c.user_id, n.hostname, u.suspended_at IS NOT NULL FROM containers c JOIN nodes n ON c.node_id = n.id JOIN users u ON u.id = c.user_id WHERE c.container_token = $1`, token).Scan(&containerID, &userID, &nodeHostname, &suspended)
         if err != nil {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid container token"})
             c.Abort(); return
         }
+        if suspended {
+            c.JSON(http.StatusForbidden, gin.H{"error": "account suspended"})
+            c.Abort(); return
+        }
         c.Set("cli_container_id", containerID)
         c.Set("cli_user_id", userID)
         c.Set("cli_node_hostname", nodeHostname)
@@ -572,7 +614,7 @@ func (h *Handler) Home(c *gin.Context) {
 }
 
 func (h *Handler) LoginPage(c *gin.Context) {
-	h.inertia(c, "Login", gin.H{})
+	h.inertia(c, "Login", gin.H{"providers": h.auth.Providers()})
 }
 
 func (h *Handler) LegalPage(c *gin.Context) {
@@ -588,38 +630,6 @@ func (h *Handler) Logout(c *gin.Context) {
 	h.inertia(c, "Logout", gin.H{"message": "you've been successfully logged out"})
 }
 
-func (h *Handler) GitHubAuth(c *gin.Context) {
-	state := generateState()
-	c.SetCookie("oauth_state", state, 300, "/", "", false, true)
-	authURL := h.auth.GetAuthURL(state)
-	c.Redirect(http.StatusFound, authURL)
-}
-
-func (h *Handler) GitHubCallback(c *gin.Context) {
-	storedState, err := c.Cookie("oauth_state")
-	if err != nil || storedState != c.Query("state") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
-		return
-	}
-	code := c.Query("code")
-	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing code"})
-		return
-	}
-	user, err := h.auth.HandleCallback(code)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	sessionID, err := h.auth.CreateSession(user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
-		return
-	}
-	c.SetCookie("session", sessionID, 3600*24*7, "/", "", false, true)
-	c.SetCookie("oauth_state", "", -1, "/", "", false, true)
-	c.Redirect(http.StatusFound, "/user/dashboard")
-}
 func (h *Handler) UserDashboard(c *gin.Context) {
 	user := c.MustGet("user").(*models.User)
 	if strings.ToLower(strings.TrimSpace(user.ApprovalStatus)) != "approved" {
@@ -663,7 +673,7 @@ func (h *Handler) UserDashboard(c *gin.Context) {
 			subdomains = append(subdomains, subdomain)
 		}
 	}
-	h.inertia(c, "Dashboard", gin.H{"user": user, "container": container, "subdomains": subdomains})
+	h.inertia(c, "Dashboard", gin.H{"user": user, "container": container, "subdomains": subdomains, "recent_logins": h.recentSSHLogins(user.ID, 10)})
 }
 
 func (h *Handler) ContainerStatus(c *gin.Context) {
@@ -803,6 +813,7 @@ func (h *Handler) GetNewPort(c *gin.Context) {
 func (h *Handler) CreateContainer(c *gin.Context) {
 	user := c.MustGet("user").(*models.User)
     requestID := c.GetString("request_id")
+	if rejectSuspended(c, user) { return }
 	if strings.ToLower(strings.TrimSpace(user.ApprovalStatus)) != "approved" {
 		c.JSON(http.StatusForbidden, gin.H{"error": "account not approved"})
 		return
@@ -818,12 +829,13 @@ func (h *Handler) CreateContainer(c *gin.Context) {
 		"username": user.Username,
 	}
 	jb, _ := json.Marshal(payload)
-	if _, err := h.db.Exec(`INSERT INTO jobs (type, status, payload) VALUES ('create_container','queued',$1)`, string(jb)); err != nil {
+	var jobID int
+	if err := h.db.QueryRow(`INSERT INTO jobs (type, status, payload, user_id) VALUES ('create_container','queued',$1,$2) RETURNING id`, string(jb), user.ID).Scan(&jobID); err != nil {
 		log.Printf("rid=%s CreateContainer: enqueue failed: %v", requestID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"queued": true})
+	c.JSON(http.StatusOK, gin.H{"queued": true, "job_id": jobID})
 }
 
 func (h *Handler) SubdomainManagement(c *gin.Context) {
@@ -885,7 +897,7 @@ func (h *Handler) SubdomainManagement(c *gin.Context) {
 		ORDER BY created_at DESC
 	`, user.ID)
 	if err != nil { 
-		h.inertia(c, "Subdomains", gin.H{"user": user, "container": container, "subdomains": []models.Subdomain{}})
+		h.inertia(c, "Subdomains", gin.H{"user": user, "container": container, "subdomains": []models.Subdomain{}, "custom_domains": h.customDomainProps(user)})
 		return 
 	}
 	defer rows.Close()
@@ -898,11 +910,12 @@ func (h *Handler) SubdomainManagement(c *gin.Context) {
 		}
 	}
 	
-	h.inertia(c, "Subdomains", gin.H{"user": user, "container": container, "subdomains": subdomains})
+	h.inertia(c, "Subdomains", gin.H{"user": user, "container": container, "subdomains": subdomains, "custom_domains": h.customDomainProps(user)})
 }
 
 func (h *Handler) CreateSubdomain(c *gin.Context) {
 	user := c.MustGet("user").(*models.User)
+	if rejectSuspended(c, user) { return }
 	
 	var req struct {
 		Subdomain     string `json:"subdomain" binding:"required"`
@@ -990,12 +1003,7 @@ func (h *Handler) CreateSubdomain(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create DNS record"})
 		return
 	}
-	var fullSubdomain string
-	if req.SubdomainType == "username" {
-		fullSubdomain = req.Subdomain + ".hack.kim"
-	} else {
-		fullSubdomain = req.Subdomain + "." + user.Username + ".hack.kim"
-	}
+	fullSubdomain := h.cfg.Hostname(req.Subdomain, user.Username, req.SubdomainType)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":       "subdomain created successfully",
@@ -1080,7 +1088,8 @@ func (h *Handler) AdminDashboard(c *gin.Context) {
 	h.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
 	h.db.QueryRow("SELECT COUNT(*) FROM nodes").Scan(&nodeCount)
 	h.db.QueryRow("SELECT COUNT(*) FROM containers").Scan(&containerCount)
-	h.inertia(c, "Admin", gin.H{"user_count": userCount, "node_count": nodeCount, "container_count": containerCount})
+	h.inertia(c, "Admin", gin.H{"user_count": userCount, "node_count": nodeCount, "container_count": containerCount,
+		"permissions": c.MustGet("permissions"), "roles": auth.Roles})
 }
 
 func (h *Handler) NodeManagement(c *gin.Context) {
@@ -1143,6 +1152,10 @@ func (h *Handler) CreateNode(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create node"})
 		return
 	}
+	h.audit(c, "node.create", "node", strconv.Itoa(nodeID), gin.H{
+		"name": auditChange(nil, req.Name), "hostname": auditChange(nil, req.Hostname), "public_hostname": auditChange(nil, req.PublicHostname),
+		"max_memory_mb": auditChange(nil, req.MaxMemoryMB), "max_cpu_cores": auditChange(nil, req.MaxCPUCores), "max_storage_gb": auditChange(nil, req.MaxStorageGB),
+	})
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Node created successfully",
@@ -1165,6 +1178,7 @@ func (h *Handler) GenerateNodeToken(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 		return
 	}
+	h.audit(c, "node.token_rotate", "node", c.Param("id"), nil)
 
 	c.JSON(http.StatusOK, gin.H{"token": token})
 }
@@ -1176,11 +1190,14 @@ func (h *Handler) DeleteNode(c *gin.Context) {
 		return
 	}
 
+	var name, hostname string
+	_ = h.db.QueryRow("SELECT name, hostname FROM nodes WHERE id = $1", nodeID).Scan(&name, &hostname)
 	_, err = h.db.Exec("DELETE FROM nodes WHERE id = $1", nodeID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 		return
 	}
+	h.audit(c, "node.delete", "node", c.Param("id"), gin.H{"name": auditChange(name, nil), "hostname": auditChange(hostname, nil)})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Node deleted successfully"})
 }
@@ -1188,7 +1205,10 @@ func (h *Handler) DeleteNode(c *gin.Context) {
 func (h *Handler) UserManagement(c *gin.Context) {
 	rows, err := h.db.Query(`
 		SELECT id, username, email, display_name, is_admin, container_id, created_at,
-		       approval_status, approved_by, approved_at, rejection_reason
+		       approval_status, approved_by, approved_at, rejection_reason,
+		       suspended_at, suspended_until, suspension_reason,
+		       EXISTS(SELECT 1 FROM user_totp t WHERE t.user_id = users.id AND t.enabled_at IS NOT NULL),
+		       ARRAY(SELECT role FROM user_roles r WHERE r.user_id = users.id ORDER BY role)
 		FROM users ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -1200,12 +1220,15 @@ func (h *Handler) UserManagement(c *gin.Context) {
 	var users []models.User
 	for rows.Next() {
 		var user models.User
+		var roles pq.StringArray
 		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.DisplayName,
 			&user.IsAdmin, &user.ContainerID, &user.CreatedAt,
-			&user.ApprovalStatus, &user.ApprovedBy, &user.ApprovedAt, &user.RejectionReason)
+			&user.ApprovalStatus, &user.ApprovedBy, &user.ApprovedAt, &user.RejectionReason,
+			&user.SuspendedAt, &user.SuspendedUntil, &user.SuspensionReason, &user.TOTPEnabled, &roles)
 		if err != nil {
 			continue
 		}
+		user.Roles = []string(roles)
 		users = append(users, user)
 	}
 
@@ -1214,14 +1237,14 @@ func (h *Handler) UserManagement(c *gin.Context) {
 
 func (h *Handler) AdminApproveUser(c *gin.Context) {
 	admin := c.MustGet("user").(*models.User)
-	if !admin.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
 	idStr := c.Param("id")
 	userID, err := strconv.Atoi(idStr)
 	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
-    var email, username string
-    _ = h.db.QueryRow(`SELECT email, username FROM users WHERE id=$1`, userID).Scan(&email, &username)
+    var email, username, prevStatus string
+    _ = h.db.QueryRow(`SELECT email, username, approval_status FROM users WHERE id=$1`, userID).Scan(&email, &username, &prevStatus)
 	_, err = h.db.Exec(`UPDATE users SET approval_status = 'approved', approved_by = $1, approved_at = NOW(), rejection_reason = NULL, updated_at = NOW() WHERE id = $2`, admin.ID, userID)
 	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve user"}); return }
+	h.audit(c, "user.approve", "user", idStr, gin.H{"approval_status": auditChange(prevStatus, "approved")})
     if strings.TrimSpace(email) != "" {
         go func(to, uname string) {
             client, err := denemail.NewFromEnv(); if err != nil { return }
@@ -1238,7 +1261,6 @@ func (h *Handler) AdminApproveUser(c *gin.Context) {
 
 func (h *Handler) AdminRejectUser(c *gin.Context) {
 	admin := c.MustGet("user").(*models.User)
-	if !admin.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
 	idStr := c.Param("id")
 	userID, err := strconv.Atoi(idStr)
 	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
@@ -1246,10 +1268,12 @@ func (h *Handler) AdminRejectUser(c *gin.Context) {
 	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"}); return }
 	var reasonPtr *string
 	if strings.TrimSpace(req.Reason) != "" { r := strings.TrimSpace(req.Reason); reasonPtr = &r }
-    var email, username string
-    _ = h.db.QueryRow(`SELECT email, username FROM users WHERE id=$1`, userID).Scan(&email, &username)
+    var email, username, prevStatus string
+    var prevReason *string
+    _ = h.db.QueryRow(`SELECT email, username, approval_status, rejection_reason FROM users WHERE id=$1`, userID).Scan(&email, &username, &prevStatus, &prevReason)
 	_, err = h.db.Exec(`UPDATE users SET approval_status = 'rejected', approved_by = $1, approved_at = NOW(), rejection_reason = $2, updated_at = NOW() WHERE id = $3`, admin.ID, reasonPtr, userID)
 	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reject user"}); return }
+	h.audit(c, "user.reject", "user", idStr, gin.H{"approval_status": auditChange(prevStatus, "rejected"), "rejection_reason": auditChange(prevReason, reasonPtr)})
     if strings.TrimSpace(email) != "" {
         go func(to, uname, reason string) {
             client, err := denemail.NewFromEnv(); if err != nil { return }
@@ -1269,8 +1293,6 @@ func (h *Handler) AdminRejectUser(c *gin.Context) {
 }
 
 func (h *Handler) AdminDeleteUserContainer(c *gin.Context) {
-    user := c.MustGet("user").(*models.User)
-    if !user.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
     userID, err := strconv.Atoi(c.Param("id"))
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"}); return }
 
@@ -1292,15 +1314,14 @@ func (h *Handler) AdminDeleteUserContainer(c *gin.Context) {
     }
     jb, _ := json.Marshal(payload)
     var jobID int
-    if err := h.db.QueryRow(`INSERT INTO jobs (type, status, payload) VALUES ('delete_container','queued',$1) RETURNING id`, string(jb)).Scan(&jobID); err != nil {
+    if err := h.db.QueryRow(`INSERT INTO jobs (type, status, payload, user_id) VALUES ('delete_container','queued',$1,$2) RETURNING id`, string(jb), userID).Scan(&jobID); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job"}); return
     }
+    h.audit(c, "container.delete", "user", c.Param("id"), gin.H{"container_id": auditChange(containerID, nil), "job_id": jobID})
     c.JSON(http.StatusOK, gin.H{"queued": true, "job_id": jobID})
 }
 
 func (h *Handler) AdminRotateUserContainerToken(c *gin.Context) {
-    admin := c.MustGet("user").(*models.User)
-    if !admin.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
     userID, err := strconv.Atoi(c.Param("id"))
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
     var containerID, nodeHostname string
@@ -1320,12 +1341,11 @@ func (h *Handler) AdminRotateUserContainerToken(c *gin.Context) {
     if err != nil { c.JSON(http.StatusBadGateway, gin.H{"error": "node unreachable"}); return }
     defer resp.Body.Close()
     if resp.StatusCode < 200 || resp.StatusCode >= 300 { c.JSON(http.StatusBadGateway, gin.H{"error": "node rejected token write"}); return }
+    h.audit(c, "container.token_rotate", "user", c.Param("id"), gin.H{"container_id": containerID})
     c.JSON(http.StatusOK, gin.H{"ok": true, "container_token": newTok})
 }
 
 func (h *Handler) AdminReinstallUserCLI(c *gin.Context) {
-    admin := c.MustGet("user").(*models.User)
-    if !admin.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
     userID, err := strconv.Atoi(c.Param("id"))
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
     var containerID, nodeHostname string
@@ -1339,17 +1359,25 @@ func (h *Handler) AdminReinstallUserCLI(c *gin.Context) {
     if err != nil { c.JSON(http.StatusBadGateway, gin.H{"error": "node unreachable"}); return }
     defer resp.Body.Close()
     if resp.StatusCode < 200 || resp.StatusCode >= 300 { b, _ := io.ReadAll(resp.Body); c.JSON(resp.StatusCode, gin.H{"error": strings.TrimSpace(string(b))}); return }
+    h.audit(c, "container.cli_reinstall", "user", c.Param("id"), gin.H{"container_id": containerID})
     c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
 func (h *Handler) AdminListJobs(c *gin.Context) {
-    user := c.MustGet("user").(*models.User)
-    if !user.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
     limit := 50
     if l := c.Query("limit"); l != "" {
         if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 200 { limit = v }
     }
-    rows, err := h.db.Query(`SELECT id, type, status, error, created_at, updated_at FROM jobs ORDER BY id DESC LIMIT $1`, limit)
+    where := ` WHERE 1=1`
+    args := []interface{}{}
+    for _, f := range []string{"status", "type"} {
+        if v := c.Query(f); v != "" {
+            args = append(args, v)
+            where += ` AND ` + f + ` = $` + strconv.Itoa(len(args))
+        }
+    }
+    args = append(args, limit)
+    rows, err := h.db.Query(`SELECT id, type, status, error, attempts, max_attempts, run_after, created_at, updated_at FROM jobs`+where+` ORDER BY id DESC LIMIT $`+strconv.Itoa(len(args)), args...)
     if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
         defer rows.Close()
     type jobRow struct {
@@ -1357,13 +1385,16 @@ func (h *Handler) AdminListJobs(c *gin.Context) {
         Type string `json:"type"`
         Status string `json:"status"`
         Error *string `json:"error"`
+        Attempts int `json:"attempts"`
+        MaxAttempts int `json:"max_attempts"`
+        RunAfter time.Time `json:"run_after"`
         CreatedAt time.Time `json:"created_at"`
         UpdatedAt time.Time `json:"updated_at"`
     }
     var out []jobRow
         for rows.Next() {
         var j jobRow
-        if err := rows.Scan(&j.ID, &j.Type, &j.Status, &j.Error, &j.CreatedAt, &j.UpdatedAt); err == nil {
+        if err := rows.Scan(&j.ID, &j.Type, &j.Status, &j.Error, &j.Attempts, &j.MaxAttempts, &j.RunAfter, &j.CreatedAt, &j.UpdatedAt); err == nil {
             out = append(out, j)
         }
     }
@@ -1371,24 +1402,32 @@ func (h *Handler) AdminListJobs(c *gin.Context) {
 }
 
 func (h *Handler) AdminGetJob(c *gin.Context) {
-    user := c.MustGet("user").(*models.User)
-    if !user.IsAdmin { c.JSON(http.StatusForbidden, gin.H{"error": "admin required"}); return }
     id, err := strconv.Atoi(c.Param("id"))
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"}); return }
     var j struct {
         ID int `json:"id"`
         Type string `json:"type"`
         Status string `json:"status"`
+        Payload json.RawMessage `json:"payload"`
         Error *string `json:"error"`
         Result *string `json:"result"`
+        Attempts int `json:"attempts"`
+        MaxAttempts int `json:"max_attempts"`
+        RunAfter time.Time `json:"run_after"`
+        LeaseOwner *string `json:"lease_owner"`
+        LeaseExpiresAt *time.Time `json:"lease_expires_at"`
         CreatedAt time.Time `json:"created_at"`
         UpdatedAt time.Time `json:"updated_at"`
+        UserID *int `json:"user_id"`
+        Events []jobs.Event `json:"events"`
+        Steps []jobs.StepStatus `json:"steps"`
     }
-    var resultBytes, errStr *string
-    err = h.db.QueryRow(`SELECT id, type, status, result, error, created_at, updated_at FROM jobs WHERE id=$1`, id).Scan(&j.ID, &j.Type, &j.Status, &resultBytes, &errStr, &j.CreatedAt, &j.UpdatedAt)
+    var payload []byte
+    err = h.db.QueryRow(`SELECT id, type, status, payload, result, error, attempts, max_attempts, run_after, lease_owner, lease_expires_at, created_at, updated_at, user_id FROM jobs WHERE id=$1`, id).Scan(&j.ID, &j.Type, &j.Status, &payload, &j.Result, &j.Error, &j.Attempts, &j.MaxAttempts, &j.RunAfter, &j.LeaseOwner, &j.LeaseExpiresAt, &j.CreatedAt, &j.UpdatedAt, &j.UserID)
     if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": "job not found"}); return }
-    j.Result = resultBytes
-    j.Error = errStr
+    j.Payload = json.RawMessage(payload)
+    if j.Events, err = jobs.ListEvents(h.db.DB, id, 0); err != nil { j.Events = []jobs.Event{} }
+    if j.Steps, err = jobs.ListSteps(h.db.DB, id); err != nil { j.Steps = []jobs.StepStatus{} }
     c.JSON(http.StatusOK, j)
 }
 
@@ -1457,6 +1496,7 @@ func (h *Handler) DeleteUser(c *gin.Context) {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
         return
     }
+    h.audit(c, "user.delete", "user", c.Param("id"), gin.H{"username": auditChange(username, nil), "container_id": auditChange(containerID, nil)})
 
     c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
@@ -1670,7 +1710,10 @@ func (h *Handler) APIUpdateContainerStatus(c *gin.Context) {
 		return
 	}
 	
-	_, err = h.db.Exec("UPDATE containers SET status = $1, updated_at = NOW() WHERE id = $2", req.Status, containerID)
+	_, err = h.db.Exec(`
+		UPDATE containers SET status = $1, updated_at = NOW(),
+		       idle_stopped_at = CASE WHEN $1 = 'RUNNING' THEN NULL ELSE idle_stopped_at END
+		WHERE id = $2`, req.Status, containerID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update container status"})
 		return
@@ -1679,12 +1722,6 @@ func (h *Handler) APIUpdateContainerStatus(c *gin.Context) {
 	log.Printf("Updated container %s status to %s", containerID, req.Status)
 	c.JSON(http.StatusOK, gin.H{"message": "status updated successfully"})
 }
-func generateState() string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
-}
-
 func generateNodeToken() string {
 	bytes := make([]byte, 32)
 	rand.Read(bytes)
@@ -1807,8 +1844,22 @@ func (h *Handler) SetContainerShell(c *gin.Context) {
 	c.Data(resp.StatusCode, "application/json", b)
 }
 
+// SetWakeOnConnect toggles whether the SSH gateway should start a stopped
+// container when its owner connects instead of turning them away.
+func (h *Handler) SetWakeOnConnect(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	var req struct{ Enabled bool `json:"enabled"` }
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if _, err := h.db.Exec(`UPDATE users SET wake_on_connect = $1, updated_at = NOW() WHERE id = $2`, req.Enabled, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update setting"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"wake_on_connect": req.Enabled})
+}
+
 func (h *Handler) ContainerStart(c *gin.Context) {
     user := c.MustGet("user").(*models.User)
+    if rejectSuspended(c, user) { return }
     if user.ContainerID == nil || *user.ContainerID == "" {
         c.JSON(http.StatusNotFound, gin.H{"error": "no container"})
         return
@@ -1859,6 +1910,7 @@ func (h *Handler) ContainerStop(c *gin.Context) {
 
 func (h *Handler) ContainerRestart(c *gin.Context) {
     user := c.MustGet("user").(*models.User)
+    if rejectSuspended(c, user) { return }
     if user.ContainerID == nil || *user.ContainerID == "" {
         c.JSON(http.StatusNotFound, gin.H{"error": "no container"})
         return
@@ -1951,7 +2003,7 @@ func (h *Handler) CreateVerificationSession(c *gin.Context) {
 		"workflow_id": os.Getenv("DIDIT_WORKFLOW_ID"),
 		"vendor_data": fmt.Sprintf("user-%d", user.ID),
 		"metadata": fmt.Sprintf(`{"account_id":"%d"}`, user.ID),
-		"callback_url": "https://hack.kim/user/dashboard",
+		"callback_url": h.cfg.DashboardURL + "/user/dashboard",
 	}
 	
 	payloadBytes, _ := json.Marshal(payload)
@@ -2073,6 +2125,25 @@ func (h *Handler) GetVerificationStatus(c *gin.Context) {
 	})
 }
 
+// AdminListUserVerifications shows a user's verification attempts to reviewers.
+func (h *Handler) AdminListUserVerifications(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	rows, err := h.db.Query(`
+		SELECT id, user_id, session_id, session_number, workflow_id, status, decision, created_at, updated_at
+		FROM verification_sessions WHERE user_id = $1 ORDER BY created_at DESC
+	`, userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	sessions := []models.VerificationSession{}
+	for rows.Next() {
+		var vs models.VerificationSession
+		if err := rows.Scan(&vs.ID, &vs.UserID, &vs.SessionID, &vs.SessionNumber, &vs.WorkflowID, &vs.Status, &vs.Decision, &vs.CreatedAt, &vs.UpdatedAt); err != nil { continue }
+		sessions = append(sessions, vs)
+	}
+	c.JSON(http.StatusOK, gin.H{"verifications": sessions})
+}
+
 func (h *Handler) VerificationWebhook(c *gin.Context) {
 	signature := c.GetHeader("X-Signature")
 	timestamp := c.GetHeader("X-Timestamp")
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/den/internal/auth"
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	loginFlowCookie = "oauth_state"
+	loginFlowMaxAge = 600
+	flowModeLogin   = "login"
+	flowModeLink    = "link"
+)
+
+// startLoginFlow sends the browser to the provider. The state, PKCE verifier
+// and nonce ride along in a short-lived cookie together with the provider
+// name and whether this is a login or linking another provider.
+func (h *Handler) startLoginFlow(c *gin.Context, p auth.Provider, mode string) {
+	flow := auth.NewLoginFlow()
+	authURL, err := p.AuthURL(c.Request.Context(), flow.State, flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Printf("failed to start %s login: %v", p.Name(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": p.DisplayName() + " is unavailable right now, try again later"})
+		return
+	}
+	value := strings.Join([]string{p.Name(), mode, flow.State, flow.Verifier, flow.Nonce}, ".")
+	c.SetCookie(loginFlowCookie, value, loginFlowMaxAge, "/", "", false, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (h *Handler) ProviderAuth(c *gin.Context) {
+	p, ok := h.auth.Provider(c.Param("provider"))
+	if !ok { h.NotFound(c); return }
+	h.startLoginFlow(c, p, flowModeLogin)
+}
+
+// GitHubCallback keeps the original callback URL working since it's the one
+// registered with the GitHub app.
+func (h *Handler) GitHubCallback(c *gin.Context) {
+	h.finishLoginFlow(c, "github")
+}
+
+func (h *Handler) ProviderCallback(c *gin.Context) {
+	h.finishLoginFlow(c, c.Param("provider"))
+}
+
+func (h *Handler) finishLoginFlow(c *gin.Context, providerName string) {
+	p, ok := h.auth.Provider(providerName)
+	if !ok { h.NotFound(c); return }
+	raw, err := c.Cookie(loginFlowCookie)
+	parts := strings.Split(raw, ".")
+	if err != nil || len(parts) != 5 || parts[0] != providerName ||
+		subtle.ConstantTimeCompare([]byte(parts[2]), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
+		return
+	}
+	c.SetCookie(loginFlowCookie, "", -1, "/", "", false, true)
+	mode, verifier, nonce := parts[1], parts[3], parts[4]
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": p.DisplayName() + " login failed: " + e})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing code"})
+		return
+	}
+	ext, err := p.Exchange(c.Request.Context(), code, verifier, nonce)
+	if err != nil {
+		log.Printf("%s login failed: %v", providerName, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": p.DisplayName() + " login failed"})
+		return
+	}
+
+	if mode == flowModeLink {
+		sessionID, _ := c.Cookie("session")
+		user, err := h.auth.GetUserBySession(sessionID)
+		if err != nil { c.Redirect(http.StatusFound, "/login"); return }
+		if err := h.auth.LinkIdentity(user.ID, ext); err != nil {
+			msg := err.Error()
+			if !errors.Is(err, auth.ErrIdentityInUse) {
+				log.Printf("failed to link %s identity for user %d: %v", providerName, user.ID, err)
+				msg = "failed to link account"
+			}
+			c.Redirect(http.StatusFound, "/user/identities?error="+url.QueryEscape(msg))
+			return
+		}
+		c.Redirect(http.StatusFound, "/user/identities?linked="+url.QueryEscape(providerName))
+		return
+	}
+
+	user, err := h.auth.LoginWithIdentity(ext)
+	if err != nil {
+		log.Printf("failed to log in %s identity %s: %v", providerName, ext.Subject, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create/update user"})
+		return
+	}
+	createSession, next := h.auth.CreateSession, "/user/dashboard"
+	if user.TOTPEnabled {
+		createSession, next = h.auth.CreatePendingSession, "/login/2fa"
+	}
+	sessionID, err := createSession(user.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+	// the cookie lives as long as the session possibly could; the server
+	// enforces the sliding expiry
+	c.SetCookie("session", sessionID, int(auth.SessionMaxLifetime.Seconds()), "/", "", false, true)
+	c.Redirect(http.StatusFound, next)
+}
+
+func (h *Handler) IdentitiesPage(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	identities, err := h.auth.ListIdentities(user.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	h.inertia(c, "Identities", gin.H{
+		"user":       user,
+		"identities": identities,
+		"providers":  h.auth.Providers(),
+		"error":      c.Query("error"),
+		"linked":     c.Query("linked"),
+	})
+}
+
+func (h *Handler) ListIdentities(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	identities, err := h.auth.ListIdentities(user.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+func (h *Handler) LinkIdentity(c *gin.Context) {
+	p, ok := h.auth.Provider(c.Param("provider"))
+	if !ok { c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"}); return }
+	h.startLoginFlow(c, p, flowModeLink)
+}
+
+func (h *Handler) UnlinkIdentity(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid identity id"}); return }
+	ok, err := h.auth.UnlinkIdentity(user.ID, id)
+	if errors.Is(err, auth.ErrLastIdentity) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlink"}); return }
+	if !ok { c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"}); return }
+	c.JSON(http.StatusOK, gin.H{"message": "unlinked"})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+var planNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+func (h *Handler) AdminListIdlePolicies(c *gin.Context) {
+	rows, err := h.db.Query(`
+		SELECT plan, enabled, idle_hours, max_cpu_percent, max_network_bytes_per_hour, created_at, updated_at
+		FROM idle_policies ORDER BY plan
+	`)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	policies := []models.IdlePolicy{}
+	for rows.Next() {
+		var p models.IdlePolicy
+		if err := rows.Scan(&p.Plan, &p.Enabled, &p.IdleHours, &p.MaxCPUPercent, &p.MaxNetworkBytesPerHour, &p.CreatedAt, &p.UpdatedAt); err == nil {
+			policies = append(policies, p)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// AdminUpsertIdlePolicy creates or replaces the idle thresholds for a plan.
+func (h *Handler) AdminUpsertIdlePolicy(c *gin.Context) {
+	plan := c.Param("plan")
+	if !planNamePattern.MatchString(plan) { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan name"}); return }
+	var req struct {
+		Enabled                *bool    `json:"enabled"`
+		IdleHours              int      `json:"idle_hours" binding:"required"`
+		MaxCPUPercent          *float64 `json:"max_cpu_percent"`
+		MaxNetworkBytesPerHour *int64   `json:"max_network_bytes_per_hour"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if req.IdleHours <= 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "idle_hours must be positive"}); return }
+	p := models.IdlePolicy{Plan: plan, Enabled: true, IdleHours: req.IdleHours, MaxCPUPercent: 2.0, MaxNetworkBytesPerHour: 10 << 20}
+	if req.Enabled != nil { p.Enabled = *req.Enabled }
+	if req.MaxCPUPercent != nil { p.MaxCPUPercent = *req.MaxCPUPercent }
+	if req.MaxNetworkBytesPerHour != nil { p.MaxNetworkBytesPerHour = *req.MaxNetworkBytesPerHour }
+	if p.MaxCPUPercent < 0 || p.MaxNetworkBytesPerHour < 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "thresholds must not be negative"}); return }
+	var prev *models.IdlePolicy
+	var old models.IdlePolicy
+	if err := h.db.QueryRow(`SELECT enabled, idle_hours, max_cpu_percent, max_network_bytes_per_hour FROM idle_policies WHERE plan = $1`, plan).
+		Scan(&old.Enabled, &old.IdleHours, &old.MaxCPUPercent, &old.MaxNetworkBytesPerHour); err == nil {
+		prev = &old
+	}
+	err := h.db.QueryRow(`
+		INSERT INTO idle_policies (plan, enabled, idle_hours, max_cpu_percent, max_network_bytes_per_hour)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (plan) DO UPDATE SET enabled = EXCLUDED.enabled, idle_hours = EXCLUDED.idle_hours,
+			max_cpu_percent = EXCLUDED.max_cpu_percent, max_network_bytes_per_hour = EXCLUDED.max_network_bytes_per_hour,
+			updated_at = NOW()
+		RETURNING created_at, updated_at
+	`, p.Plan, p.Enabled, p.IdleHours, p.MaxCPUPercent, p.MaxNetworkBytesPerHour).Scan(&p.CreatedAt, &p.UpdatedAt)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save policy"}); return }
+	changes := gin.H{"enabled": auditChange(nil, p.Enabled), "idle_hours": auditChange(nil, p.IdleHours),
+		"max_cpu_percent": auditChange(nil, p.MaxCPUPercent), "max_network_bytes_per_hour": auditChange(nil, p.MaxNetworkBytesPerHour)}
+	if prev != nil {
+		changes = gin.H{}
+		if prev.Enabled != p.Enabled { changes["enabled"] = auditChange(prev.Enabled, p.Enabled) }
+		if prev.IdleHours != p.IdleHours { changes["idle_hours"] = auditChange(prev.IdleHours, p.IdleHours) }
+		if prev.MaxCPUPercent != p.MaxCPUPercent { changes["max_cpu_percent"] = auditChange(prev.MaxCPUPercent, p.MaxCPUPercent) }
+		if prev.MaxNetworkBytesPerHour != p.MaxNetworkBytesPerHour { changes["max_network_bytes_per_hour"] = auditChange(prev.MaxNetworkBytesPerHour, p.MaxNetworkBytesPerHour) }
+	}
+	h.audit(c, "idle_policy.upsert", "idle_policy", plan, changes)
+	c.JSON(http.StatusOK, gin.H{"policy": p})
+}
+
+func (h *Handler) AdminDeleteIdlePolicy(c *gin.Context) {
+	plan := c.Param("plan")
+	if plan == "default" { c.JSON(http.StatusBadRequest, gin.H{"error": "the default policy can be disabled but not deleted"}); return }
+	res, err := h.db.Exec(`DELETE FROM idle_policies WHERE plan = $1`, plan)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete policy"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"}); return }
+	h.audit(c, "idle_policy.delete", "idle_policy", plan, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "policy deleted"})
+}
+
+func (h *Handler) AdminSetUserPlan(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	var req struct{ Plan string `json:"plan" binding:"required"` }
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if !planNamePattern.MatchString(req.Plan) { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan name"}); return }
+	var prev string
+	if err := h.db.QueryRow(`SELECT plan FROM users WHERE id = $1`, userID).Scan(&prev); err != nil { c.JSON(http.StatusNotFound, gin.H{"error": "user not found"}); return }
+	res, err := h.db.Exec(`UPDATE users SET plan = $1, updated_at = NOW() WHERE id = $2`, req.Plan, userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update plan"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusNotFound, gin.H{"error": "user not found"}); return }
+	h.audit(c, "user.set_plan", "user", c.Param("id"), gin.H{"plan": auditChange(prev, req.Plan)})
+	c.JSON(http.StatusOK, gin.H{"plan": req.Plan})
+}
+
+func (h *Handler) AdminListIdleExemptions(c *gin.Context) {
+	rows, err := h.db.Query(`
+		SELECT e.user_id, u.username, e.reason, e.created_by, e.created_at
+		FROM idle_exemptions e JOIN users u ON u.id = e.user_id
+		ORDER BY e.created_at DESC
+	`)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	exemptions := []models.IdleExemption{}
+	for rows.Next() {
+		var e models.IdleExemption
+		if err := rows.Scan(&e.UserID, &e.Username, &e.Reason, &e.CreatedBy, &e.CreatedAt); err == nil {
+			exemptions = append(exemptions, e)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"exemptions": exemptions})
+}
+
+func (h *Handler) AdminCreateIdleExemption(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	var req struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	var reason *string
+	if req.Reason != "" { reason = &req.Reason }
+	_, err := h.db.Exec(`
+		INSERT INTO idle_exemptions (user_id, reason, created_by) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET reason = EXCLUDED.reason, created_by = EXCLUDED.created_by
+	`, req.UserID, reason, admin.ID)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "failed to create exemption"}); return }
+	h.audit(c, "idle_exemption.create", "user", strconv.Itoa(req.UserID), gin.H{"reason": reason})
+	c.JSON(http.StatusCreated, gin.H{"message": "user exempted from idle stop"})
+}
+
+func (h *Handler) AdminDeleteIdleExemption(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	res, err := h.db.Exec(`DELETE FROM idle_exemptions WHERE user_id = $1`, userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete exemption"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusNotFound, gin.H{"error": "exemption not found"}); return }
+	h.audit(c, "idle_exemption.delete", "user", c.Param("user_id"), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "exemption removed"})
+}
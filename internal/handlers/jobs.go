@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/den/internal/jobs"
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// bindRunAfter reads the optional schedule override for retry and requeue:
+// either an absolute run_after or delay_seconds from now. No body means now.
+func bindRunAfter(c *gin.Context) (time.Time, bool) {
+	var req struct {
+		RunAfter     *time.Time `json:"run_after"`
+		DelaySeconds int        `json:"delay_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "run_after must be an RFC3339 time"})
+		return time.Time{}, false
+	}
+	if req.DelaySeconds < 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "delay_seconds must not be negative"}); return time.Time{}, false }
+	if req.RunAfter != nil { return *req.RunAfter, true }
+	return time.Now().Add(time.Duration(req.DelaySeconds) * time.Second), true
+}
+
+func (h *Handler) jobStatus(c *gin.Context) (int, string, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"}); return 0, "", false }
+	var status string
+	if err := h.db.QueryRow(`SELECT status FROM jobs WHERE id = $1`, id).Scan(&status); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"}); return 0, "", false
+	}
+	return id, status, true
+}
+
+// AdminCancelJob stops a queued job from running. A running job loses its
+// lease: the worker's context is cancelled and its outcome is discarded.
+func (h *Handler) AdminCancelJob(c *gin.Context) {
+	id, prev, ok := h.jobStatus(c)
+	if !ok { return }
+	res, err := h.db.Exec(`
+		UPDATE jobs SET status = 'cancelled', lease_owner = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status IN ('queued', 'running')
+	`, id)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusConflict, gin.H{"error": "only queued or running jobs can be cancelled", "status": prev}); return }
+	h.jobEvent(id, jobs.LevelWarn, "cancelled", "cancelled by an admin")
+	h.audit(c, "job.cancel", "job", c.Param("id"), gin.H{"status": auditChange(prev, "cancelled")})
+	c.JSON(http.StatusOK, gin.H{"message": "job cancelled"})
+}
+
+// AdminRetryJob gives a failed or cancelled job a fresh set of attempts.
+func (h *Handler) AdminRetryJob(c *gin.Context) {
+	id, prev, ok := h.jobStatus(c)
+	if !ok { return }
+	runAfter, ok := bindRunAfter(c)
+	if !ok { return }
+	res, err := h.db.Exec(`
+		UPDATE jobs SET status = 'queued', attempts = 0, error = NULL, result = NULL, run_after = $2, updated_at = NOW()
+		WHERE id = $1 AND status IN ('failed', 'cancelled')
+	`, id, runAfter)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusConflict, gin.H{"error": "only failed or cancelled jobs can be retried", "status": prev}); return }
+	h.jobEvent(id, jobs.LevelInfo, "queued", "retried by an admin")
+	h.audit(c, "job.retry", "job", c.Param("id"), gin.H{"status": auditChange(prev, "queued"), "run_after": runAfter})
+	c.JSON(http.StatusOK, gin.H{"message": "job queued", "run_after": runAfter})
+}
+
+// AdminRequeueJob reschedules a queued job, or puts a running one that is
+// stuck back in the queue. Attempts are kept.
+func (h *Handler) AdminRequeueJob(c *gin.Context) {
+	id, prev, ok := h.jobStatus(c)
+	if !ok { return }
+	runAfter, ok := bindRunAfter(c)
+	if !ok { return }
+	res, err := h.db.Exec(`
+		UPDATE jobs SET status = 'queued', run_after = $2, lease_owner = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status IN ('queued', 'running')
+	`, id, runAfter)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusConflict, gin.H{"error": "only queued or running jobs can be requeued, use retry for finished ones", "status": prev}); return }
+	h.jobEvent(id, jobs.LevelInfo, "queued", "requeued by an admin")
+	h.audit(c, "job.requeue", "job", c.Param("id"), gin.H{"status": auditChange(prev, "queued"), "run_after": runAfter})
+	c.JSON(http.StatusOK, gin.H{"message": "job requeued", "run_after": runAfter})
+}
+
+// AdminListFailedJobs is the dead-letter view: jobs that used up their
+// attempts, with the payload and last error, plus counts per type.
+func (h *Handler) AdminListFailedJobs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 { page = 1 }
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "50"))
+	if perPage < 1 || perPage > 200 { perPage = 50 }
+	where := ` WHERE status = 'failed'`
+	args := []interface{}{}
+	if v := c.Query("type"); v != "" {
+		args = append(args, v)
+		where += ` AND type = $` + strconv.Itoa(len(args))
+	}
+	if v := c.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC3339 time"}); return }
+		args = append(args, t)
+		where += ` AND updated_at >= $` + strconv.Itoa(len(args))
+	}
+	var total int
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM jobs`+where, args...).Scan(&total); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	rows, err := h.db.Query(`
+		SELECT id, type, payload, error, attempts, max_attempts, created_at, updated_at FROM jobs`+where+`
+		ORDER BY updated_at DESC LIMIT $`+strconv.Itoa(len(args)+1)+` OFFSET $`+strconv.Itoa(len(args)+2),
+		append(args, perPage, (page-1)*perPage)...)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	type failedJob struct {
+		ID          int             `json:"id"`
+		Type        string          `json:"type"`
+		Payload     json.RawMessage `json:"payload"`
+		Error       *string         `json:"error"`
+		Attempts    int             `json:"attempts"`
+		MaxAttempts int             `json:"max_attempts"`
+		CreatedAt   time.Time       `json:"created_at"`
+		UpdatedAt   time.Time       `json:"updated_at"`
+	}
+	out := []failedJob{}
+	for rows.Next() {
+		var j failedJob
+		var payload []byte
+		if err := rows.Scan(&j.ID, &j.Type, &payload, &j.Error, &j.Attempts, &j.MaxAttempts, &j.CreatedAt, &j.UpdatedAt); err != nil { continue }
+		j.Payload = json.RawMessage(payload)
+		out = append(out, j)
+	}
+
+	byType := map[string]int{}
+	if trows, err := h.db.Query(`SELECT type, COUNT(*) FROM jobs WHERE status = 'failed' GROUP BY type`); err == nil {
+		defer trows.Close()
+		for trows.Next() {
+			var t string
+			var n int
+			if trows.Scan(&t, &n) == nil { byType[t] = n }
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": out, "total": total, "page": page, "per_page": perPage, "by_type": byType})
+}
+
+// AdminBulkRetryJobs requeues every failed job of a type, e.g. once the
+// outage that made them fail is fixed.
+func (h *Handler) AdminBulkRetryJobs(c *gin.Context) {
+	var req struct {
+		Type         string     `json:"type" binding:"required"`
+		Since        *time.Time `json:"since"`
+		RunAfter     *time.Time `json:"run_after"`
+		DelaySeconds int        `json:"delay_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "type is required"}); return }
+	if req.DelaySeconds < 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "delay_seconds must not be negative"}); return }
+	runAfter := time.Now().Add(time.Duration(req.DelaySeconds) * time.Second)
+	if req.RunAfter != nil { runAfter = *req.RunAfter }
+	rows, err := h.db.Query(`
+		UPDATE jobs SET status = 'queued', attempts = 0, error = NULL, result = NULL, run_after = $2, updated_at = NOW()
+		WHERE status = 'failed' AND type = $1 AND ($3::timestamptz IS NULL OR updated_at >= $3)
+		RETURNING id
+	`, req.Type, runAfter, req.Since)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil { ids = append(ids, id) }
+	}
+	if len(ids) > 0 {
+		h.audit(c, "job.bulk_retry", "job_type", req.Type, gin.H{"job_ids": ids, "run_after": runAfter})
+	}
+	c.JSON(http.StatusOK, gin.H{"retried": len(ids), "job_ids": ids, "run_after": runAfter})
+}
+
+func (h *Handler) jobEvent(jobID int, level, step, message string) {
+	if err := jobs.RecordEvent(h.db.DB, jobID, level, step, message); err != nil {
+		log.Printf("record %s event on job %d: %v", step, jobID, err)
+	}
+}
+
+// userJob is what a user sees of their own job. Payload and result stay
+// admin-only since they can carry tokens.
+type userJob struct {
+	ID        int          `json:"id"`
+	Type      string       `json:"type"`
+	Status    string       `json:"status"`
+	Error     *string      `json:"error"`
+	Step      *string      `json:"step"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Events    []jobs.Event `json:"events,omitempty"`
+}
+
+const userJobColumns = `j.id, j.type, j.status, j.error,
+	(SELECT step FROM job_events e WHERE e.job_id = j.id ORDER BY e.id DESC LIMIT 1),
+	j.created_at, j.updated_at`
+
+func (h *Handler) listUserJobs(c *gin.Context, userID int) {
+	rows, err := h.db.Query(`SELECT `+userJobColumns+` FROM jobs j WHERE j.user_id = $1 ORDER BY j.id DESC LIMIT 50`, userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	out := []userJob{}
+	for rows.Next() {
+		var j userJob
+		if err := rows.Scan(&j.ID, &j.Type, &j.Status, &j.Error, &j.Step, &j.CreatedAt, &j.UpdatedAt); err != nil { continue }
+		out = append(out, j)
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": out})
+}
+
+// userJobID parses :id and checks the job belongs to the user. Other users'
+// jobs look the same as missing ones.
+func (h *Handler) userJobID(c *gin.Context, userID int) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"}); return 0, false }
+	var owner *int
+	if err := h.db.QueryRow(`SELECT user_id FROM jobs WHERE id = $1`, id).Scan(&owner); err != nil || owner == nil || *owner != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"}); return 0, false
+	}
+	return id, true
+}
+
+func (h *Handler) UserListJobs(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	h.listUserJobs(c, user.ID)
+}
+
+func (h *Handler) UserGetJob(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	id, ok := h.userJobID(c, user.ID)
+	if !ok { return }
+	var j userJob
+	err := h.db.QueryRow(`SELECT `+userJobColumns+` FROM jobs j WHERE j.id = $1`, id).Scan(&j.ID, &j.Type, &j.Status, &j.Error, &j.Step, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": "job not found"}); return }
+	if j.Events, err = jobs.ListEvents(h.db.DB, id, 0); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, j)
+}
+
+func (h *Handler) UserJobEvents(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	id, ok := h.userJobID(c, user.ID)
+	if !ok { return }
+	h.streamJobEvents(c, id)
+}
+
+func (h *Handler) AdminJobEvents(c *gin.Context) {
+	id, _, ok := h.jobStatus(c)
+	if !ok { return }
+	h.streamJobEvents(c, id)
+}
+
+func (h *Handler) CLIListJobs(c *gin.Context) {
+	h.listUserJobs(c, c.GetInt("cli_user_id"))
+}
+
+func (h *Handler) CLIJobEvents(c *gin.Context) {
+	id, ok := h.userJobID(c, c.GetInt("cli_user_id"))
+	if !ok { return }
+	h.streamJobEvents(c, id)
+}
+
+// streamJobEvents follows a job as server-sent events: a "progress" event
+// per job event, then a "done" event once the job is finished. Reconnecting
+// clients resume after Last-Event-ID (or ?after=).
+func (h *Handler) streamJobEvents(c *gin.Context, jobID int) {
+	after, _ := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64)
+	if v := c.Query("after"); v != "" { after, _ = strconv.ParseInt(v, 10, 64) }
+
+	// subscribe before the first read so nothing lands in between
+	wake, unsubscribe := h.jobFeed.Subscribe(jobID)
+	defer unsubscribe()
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		events, err := jobs.ListEvents(h.db.DB, jobID, after)
+		if err != nil { return }
+		for _, e := range events {
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: progress\ndata: %s\n\n", e.ID, data)
+			after = e.ID
+		}
+		var status string
+		var jobErr *string
+		if err := h.db.QueryRow(`SELECT status, error FROM jobs WHERE id = $1`, jobID).Scan(&status, &jobErr); err != nil { return }
+		if status == "success" || status == "failed" || status == "cancelled" {
+			data, _ := json.Marshal(gin.H{"status": status, "error": jobErr})
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			w.Flush()
+			return
+		}
+		w.Flush()
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-wake:
+		case <-keepalive.C:
+			// also re-reads, in case the LISTEN connection is down
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/den/internal/auth"
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission runs after RequireAdmin and checks one named permission.
+func (h *Handler) RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perms, _ := c.Get("permissions")
+		granted, _ := perms.([]string)
+		for _, p := range granted {
+			if p == perm { c.Next(); return }
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "missing permission " + perm})
+		c.Abort()
+	}
+}
+
+func (h *Handler) AdminListRoles(c *gin.Context) {
+	assignments, err := h.auth.ListRoleAssignments()
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, gin.H{"roles": auth.RolePermissions(), "assignments": assignments})
+}
+
+func (h *Handler) AdminGrantRole(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "role is required"}); return }
+	if !auth.IsValidRole(req.Role) { c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"}); return }
+	var exists bool
+	if err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err := h.auth.GrantRole(userID, req.Role, admin.ID); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to grant role"}); return }
+	h.audit(c, "role.grant", "user", c.Param("id"), gin.H{"role": auditChange(nil, req.Role)})
+	c.JSON(http.StatusOK, gin.H{"message": "role granted"})
+}
+
+func (h *Handler) AdminRevokeRole(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	ok, err := h.auth.RevokeRole(userID, c.Param("role"))
+	if errors.Is(err, auth.ErrLastSuperadmin) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke role"}); return }
+	if !ok { c.JSON(http.StatusNotFound, gin.H{"error": "user does not have that role"}); return }
+	h.audit(c, "role.revoke", "user", c.Param("id"), gin.H{"role": auditChange(c.Param("role"), nil)})
+	c.JSON(http.StatusOK, gin.H{"message": "role revoked"})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/den/internal/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) AdminListSchedules(c *gin.Context) {
+	rows, err := h.db.Query(`
+		SELECT s.id, s.name, s.cron, s.job_type, s.payload, s.paused, s.next_run_at, s.last_run_at, s.last_job_id, j.status
+		FROM schedules s LEFT JOIN jobs j ON j.id = s.last_job_id
+		ORDER BY s.name
+	`)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	type schedule struct {
+		ID            int             `json:"id"`
+		Name          string          `json:"name"`
+		Cron          string          `json:"cron"`
+		JobType       string          `json:"job_type"`
+		Payload       json.RawMessage `json:"payload"`
+		Paused        bool            `json:"paused"`
+		NextRunAt     *time.Time      `json:"next_run_at"`
+		LastRunAt     *time.Time      `json:"last_run_at"`
+		LastJobID     *int            `json:"last_job_id"`
+		LastJobStatus *string         `json:"last_job_status"`
+	}
+	out := []schedule{}
+	for rows.Next() {
+		var s schedule
+		var payload []byte
+		if err := rows.Scan(&s.ID, &s.Name, &s.Cron, &s.JobType, &payload, &s.Paused, &s.NextRunAt, &s.LastRunAt, &s.LastJobID, &s.LastJobStatus); err != nil { continue }
+		s.Payload = json.RawMessage(payload)
+		out = append(out, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": out})
+}
+
+func (h *Handler) AdminPauseSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"}); return }
+	res, err := h.db.Exec(`UPDATE schedules SET paused = true, updated_at = NOW() WHERE id = $1`, id)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"}); return }
+	h.audit(c, "schedule.pause", "schedule", c.Param("id"), gin.H{"paused": auditChange(false, true)})
+	c.JSON(http.StatusOK, gin.H{"message": "schedule paused"})
+}
+
+// AdminResumeSchedule picks up from the next tick after now rather than
+// running the ticks missed while paused.
+func (h *Handler) AdminResumeSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"}); return }
+	var expr string
+	if err := h.db.QueryRow(`SELECT cron FROM schedules WHERE id = $1`, id).Scan(&expr); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"}); return
+	}
+	cron, err := jobs.ParseCron(expr)
+	if err != nil { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	var next *time.Time
+	if t := cron.Next(time.Now()); !t.IsZero() { next = &t }
+	if _, err := h.db.Exec(`UPDATE schedules SET paused = false, next_run_at = $2, updated_at = NOW() WHERE id = $1`, id, next); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return
+	}
+	h.audit(c, "schedule.resume", "schedule", c.Param("id"), gin.H{"paused": auditChange(true, false)})
+	c.JSON(http.StatusOK, gin.H{"message": "schedule resumed", "next_run_at": next})
+}
+
+// AdminTriggerSchedule runs a schedule's job now, paused or not.
+func (h *Handler) AdminTriggerSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"}); return }
+	jobID, err := jobs.TriggerSchedule(h.db.DB, id)
+	if err == sql.ErrNoRows { c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue job"}); return }
+	h.audit(c, "schedule.trigger", "schedule", c.Param("id"), gin.H{"job_id": jobID})
+	c.JSON(http.StatusOK, gin.H{"queued": true, "job_id": jobID})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) SessionsPage(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	current, _ := c.Cookie("session")
+	sessions, err := h.auth.ListSessions(user.ID, current)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	h.inertia(c, "Sessions", gin.H{"user": user, "sessions": sessions})
+}
+
+func (h *Handler) ListSessions(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	current, _ := c.Cookie("session")
+	sessions, err := h.auth.ListSessions(user.ID, current)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func (h *Handler) RevokeSession(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"}); return }
+	ok, err := h.auth.RevokeSession(user.ID, id)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"}); return }
+	if !ok { c.JSON(http.StatusNotFound, gin.H{"error": "session not found"}); return }
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeAllSessions logs the user out everywhere, including this browser.
+func (h *Handler) RevokeAllSessions(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	n, err := h.auth.RevokeUserSessions(user.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"}); return }
+	c.SetCookie("session", "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out everywhere", "revoked": n})
+}
+
+func (h *Handler) AdminRevokeUserSessions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	n, err := h.auth.RevokeUserSessions(id)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"}); return }
+	h.audit(c, "user.revoke_sessions", "user", c.Param("id"), gin.H{"revoked": n})
+	c.JSON(http.StatusOK, gin.H{"revoked": n})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/ssh"
+)
+
+type certificateRequest struct {
+	PublicKey string `json:"public_key" binding:"required"`
+	Hours     int    `json:"hours"`
+}
+
+func (h *Handler) issueCertificate(c *gin.Context, userID int, issuedVia string) {
+	var req certificateRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "public_key is required"}); return }
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(req.PublicKey)))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid public key"}); return }
+	var username string
+	if err := h.db.QueryRow(`SELECT username FROM users WHERE id = $1`, userID).Scan(&username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "user lookup failed"}); return
+	}
+	cert, err := h.gateway.CertAuthority().Issue(userID, username, pub, time.Duration(req.Hours)*time.Hour, issuedVia)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, cert)
+}
+
+// IssueSSHCertificate signs the caller's public key with the den CA.
+func (h *Handler) IssueSSHCertificate(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	h.issueCertificate(c, user.ID, "web")
+}
+
+func (h *Handler) CLIIssueSSHCertificate(c *gin.Context) {
+	h.issueCertificate(c, c.GetInt("cli_user_id"), "cli")
+}
+
+func (h *Handler) SSHCAPublicKey(c *gin.Context) {
+	key, err := h.gateway.CertAuthority().PublicKey()
+	if err != nil { c.JSON(http.StatusServiceUnavailable, gin.H{"error": "certificate authority unavailable"}); return }
+	c.String(http.StatusOK, key)
+}
+
+func (h *Handler) listCertificates(userID int, limit int) ([]models.SSHCertificate, error) {
+	query := `
+		SELECT sc.serial, sc.user_id, u.username, sc.key_id, sc.key_fingerprint, sc.principals, sc.valid_after,
+		       sc.valid_before, sc.issued_via, sc.revoked_at, sc.created_at
+		FROM ssh_certificates sc JOIN users u ON u.id = sc.user_id`
+	args := []interface{}{}
+	if userID > 0 {
+		args = append(args, userID)
+		query += ` WHERE sc.user_id = $1`
+	}
+	args = append(args, limit)
+	query += ` ORDER BY sc.created_at DESC LIMIT $` + strconv.Itoa(len(args))
+	rows, err := h.db.Query(query, args...)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	certs := []models.SSHCertificate{}
+	for rows.Next() {
+		var sc models.SSHCertificate
+		var principals pq.StringArray
+		if err := rows.Scan(&sc.Serial, &sc.UserID, &sc.Username, &sc.KeyID, &sc.KeyFingerprint, &principals, &sc.ValidAfter,
+			&sc.ValidBefore, &sc.IssuedVia, &sc.RevokedAt, &sc.CreatedAt); err == nil {
+			sc.Principals = principals
+			certs = append(certs, sc)
+		}
+	}
+	return certs, nil
+}
+
+func (h *Handler) ListSSHCertificates(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	certs, err := h.listCertificates(user.ID, 100)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, gin.H{"certificates": certs})
+}
+
+func (h *Handler) RevokeSSHCertificate(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	serial, err := strconv.ParseInt(c.Param("serial"), 10, 64)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid serial"}); return }
+	res, err := h.db.Exec(`UPDATE ssh_certificates SET revoked_at = NOW(), revoked_by = $1 WHERE serial = $2 AND user_id = $1 AND revoked_at IS NULL`, user.ID, serial)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke certificate"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusNotFound, gin.H{"error": "certificate not found"}); return }
+	c.JSON(http.StatusOK, gin.H{"message": "certificate revoked"})
+}
+
+func (h *Handler) AdminListSSHCertificates(c *gin.Context) {
+	userID := 0
+	if v := c.Query("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"}); return }
+		userID = id
+	}
+	certs, err := h.listCertificates(userID, 500)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, gin.H{"certificates": certs})
+}
+
+func (h *Handler) AdminRevokeSSHCertificate(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	serial, err := strconv.ParseInt(c.Param("serial"), 10, 64)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid serial"}); return }
+	res, err := h.db.Exec(`UPDATE ssh_certificates SET revoked_at = NOW(), revoked_by = $1 WHERE serial = $2 AND revoked_at IS NULL`, admin.ID, serial)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke certificate"}); return }
+	if n, _ := res.RowsAffected(); n == 0 { c.JSON(http.StatusNotFound, gin.H{"error": "certificate not found or already revoked"}); return }
+	h.audit(c, "ssh_certificate.revoke", "ssh_certificate", c.Param("serial"), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "certificate revoked"})
+}
+
+func (h *Handler) AdminRevokeUserSSHCertificates(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	res, err := h.db.Exec(`UPDATE ssh_certificates SET revoked_at = NOW(), revoked_by = $1 WHERE user_id = $2 AND revoked_at IS NULL AND valid_before > NOW()`, admin.ID, userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke certificates"}); return }
+	n, _ := res.RowsAffected()
+	h.audit(c, "ssh_certificate.revoke_all", "user", c.Param("id"), gin.H{"revoked": n})
+	c.JSON(http.StatusOK, gin.H{"revoked": n})
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) AdminListLiveSSHSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"sessions": h.gateway.LiveSessions()})
+}
+
+func (h *Handler) AdminKillSSHSession(c *gin.Context) {
+	if !h.gateway.KillSession(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	h.audit(c, "ssh_session.kill", "ssh_session", c.Param("id"), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "session closed"})
+}
+
+func (h *Handler) AdminKillUserSSHSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	closed := h.gateway.KillUserSessions(userID)
+	h.audit(c, "ssh_session.kill_all", "user", c.Param("id"), gin.H{"closed": closed})
+	c.JSON(http.StatusOK, gin.H{"closed": closed})
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) recentSSHLogins(userID, limit int) []models.SSHAuthAttempt {
+	attempts := []models.SSHAuthAttempt{}
+	rows, err := h.db.Query(`
+		SELECT id, user_id, username, client_ip, method, key_fingerprint, success, reason, created_at
+		FROM ssh_auth_attempts WHERE user_id = $1
+		ORDER BY created_at DESC LIMIT $2
+	`, userID, limit)
+	if err != nil { return attempts }
+	defer rows.Close()
+	for rows.Next() {
+		var a models.SSHAuthAttempt
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Username, &a.ClientIP, &a.Method, &a.KeyFingerprint, &a.Success, &a.Reason, &a.CreatedAt); err == nil {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts
+}
+
+func (h *Handler) UserSSHLogins(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	limit := 50
+	if l := c.Query("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 200 { limit = v }
+	}
+	c.JSON(http.StatusOK, gin.H{"logins": h.recentSSHLogins(user.ID, limit)})
+}
+
+func (h *Handler) AdminListSSHAuthAttempts(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 500 { limit = v }
+	}
+	query := `
+		SELECT id, user_id, username, client_ip, method, key_fingerprint, success, reason, created_at
+		FROM ssh_auth_attempts WHERE 1=1`
+	args := []interface{}{}
+	if v := c.Query("username"); v != "" {
+		args = append(args, v)
+		query += ` AND username = $` + strconv.Itoa(len(args))
+	}
+	if v := c.Query("ip"); v != "" {
+		args = append(args, v)
+		query += ` AND client_ip = $` + strconv.Itoa(len(args))
+	}
+	if v := c.Query("success"); v != "" {
+		ok, err := strconv.ParseBool(v)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid success filter"}); return }
+		args = append(args, ok)
+		query += ` AND success = $` + strconv.Itoa(len(args))
+	}
+	args = append(args, limit)
+	query += ` ORDER BY created_at DESC LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	attempts := []models.SSHAuthAttempt{}
+	for rows.Next() {
+		var a models.SSHAuthAttempt
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Username, &a.ClientIP, &a.Method, &a.KeyFingerprint, &a.Success, &a.Reason, &a.CreatedAt); err == nil {
+			attempts = append(attempts, a)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+}
+
+// AdminListIPBans returns active bans; pass ?all=true to include expired ones.
+func (h *Handler) AdminListIPBans(c *gin.Context) {
+	query := `SELECT id, ip, reason, expires_at, created_by, created_at FROM ip_bans`
+	if c.Query("all") != "true" {
+		query += ` WHERE expires_at IS NULL OR expires_at > NOW()`
+	}
+	query += ` ORDER BY created_at DESC`
+	rows, err := h.db.Query(query)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	bans := []models.IPBan{}
+	for rows.Next() {
+		var b models.IPBan
+		if err := rows.Scan(&b.ID, &b.IP, &b.Reason, &b.ExpiresAt, &b.CreatedBy, &b.CreatedAt); err == nil {
+			bans = append(bans, b)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"bans": bans})
+}
+
+// AdminCreateIPBan bans an address from the SSH gateway. A duration of 0 (or
+// none) makes the ban permanent until it is lifted.
+func (h *Handler) AdminCreateIPBan(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	var req struct {
+		IP              string `json:"ip" binding:"required"`
+		Reason          string `json:"reason"`
+		DurationMinutes int    `json:"duration_minutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"}); return }
+	if net.ParseIP(req.IP) == nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ip address"}); return }
+	if req.DurationMinutes < 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "duration must not be negative"}); return }
+	var expiresAt *time.Time
+	if req.DurationMinutes > 0 {
+		t := time.Now().Add(time.Duration(req.DurationMinutes) * time.Minute)
+		expiresAt = &t
+	}
+	var reason *string
+	if req.Reason != "" { reason = &req.Reason }
+	var b models.IPBan
+	err := h.db.QueryRow(`
+		INSERT INTO ip_bans (ip, reason, expires_at, created_by) VALUES ($1, $2, $3, $4)
+		RETURNING id, ip, reason, expires_at, created_by, created_at
+	`, req.IP, reason, expiresAt, admin.ID).Scan(&b.ID, &b.IP, &b.Reason, &b.ExpiresAt, &b.CreatedBy, &b.CreatedAt)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create ban"}); return }
+	h.audit(c, "ip_ban.create", "ip_ban", strconv.Itoa(b.ID), gin.H{"ip": b.IP, "reason": reason, "expires_at": expiresAt})
+	c.JSON(http.StatusCreated, gin.H{"ban": b})
+}
+
+func (h *Handler) AdminDeleteIPBan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ban id"}); return }
+	var ip string
+	err = h.db.QueryRow(`DELETE FROM ip_bans WHERE id = $1 RETURNING ip`, id).Scan(&ip)
+	if err == sql.ErrNoRows { c.JSON(http.StatusNotFound, gin.H{"error": "ban not found"}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lift ban"}); return }
+	h.audit(c, "ip_ban.delete", "ip_ban", c.Param("id"), gin.H{"ip": auditChange(ip, nil)})
+	c.JSON(http.StatusOK, gin.H{"message": "ban lifted"})
+}
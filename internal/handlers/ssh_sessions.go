@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/den/internal/models"
+	"github.com/den/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) AdminListSSHSessions(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 500 { limit = v }
+	}
+	query := `
+		SELECT id, user_id, username, container_id, node_hostname, client_ip, started_at, ended_at,
+		       bytes_in, bytes_out, recording_key IS NOT NULL, recording_expires_at
+		FROM ssh_sessions WHERE 1=1`
+	args := []interface{}{}
+	if v := c.Query("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"}); return }
+		args = append(args, id)
+		query += ` AND user_id = $` + strconv.Itoa(len(args))
+	}
+	if v := c.Query("container_id"); v != "" {
+		args = append(args, v)
+		query += ` AND container_id = $` + strconv.Itoa(len(args))
+	}
+	args = append(args, limit)
+	query += ` ORDER BY started_at DESC LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	defer rows.Close()
+	sessions := []models.SSHSession{}
+	for rows.Next() {
+		var s models.SSHSession
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Username, &s.ContainerID, &s.NodeHostname, &s.ClientIP, &s.StartedAt, &s.EndedAt,
+			&s.BytesIn, &s.BytesOut, &s.HasRecording, &s.RecordingExpiresAt); err == nil {
+			sessions = append(sessions, s)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// AdminDownloadSSHRecording redirects to a short-lived link for the session's
+// asciicast file, which can be replayed with `asciinema play`.
+func (h *Handler) AdminDownloadSSHRecording(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"}); return }
+	var key *string
+	if err := h.db.QueryRow(`SELECT recording_key FROM ssh_sessions WHERE id = $1`, id).Scan(&key); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"}); return
+	}
+	if key == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no recording for this session"}); return
+	}
+	r2, err := storage.NewR2ClientFromEnv()
+	if err != nil { c.JSON(http.StatusServiceUnavailable, gin.H{"error": "storage not configured"}); return }
+	url, err := r2.PresignedGet(context.Background(), *key, 15*time.Minute)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "presign failed"}); return }
+	h.audit(c, "ssh_recording.download", "ssh_session", c.Param("id"), nil)
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, gin.H{"download_url": url})
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// rejectSuspended answers 403 for suspended users. Suspended users can still
+// sign in to the dashboard to see why, but can't bring anything back up.
+func rejectSuspended(c *gin.Context, user *models.User) bool {
+	if user.SuspendedAt == nil { return false }
+	c.JSON(http.StatusForbidden, gin.H{"error": "account suspended", "suspension_reason": user.SuspensionReason, "suspended_until": user.SuspendedUntil})
+	return true
+}
+
+// stopUserContainer asks the node to stop the user's container, if they have one.
+func (h *Handler) stopUserContainer(userID int) error {
+	var containerID, nodeHostname string
+	err := h.db.QueryRow(`
+		SELECT c.id, n.hostname FROM users u
+		JOIN containers c ON u.container_id = c.id
+		JOIN nodes n ON c.node_id = n.id
+		WHERE u.id = $1
+	`, userID).Scan(&containerID, &nodeHostname)
+	if err != nil { return nil }
+	body, _ := json.Marshal(map[string]string{"action": "stop"})
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(fmt.Sprintf("http://%s:8081/api/control/containers/%s", nodeHostname, containerID), "application/json", bytes.NewReader(body))
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 { return fmt.Errorf("node returned %d", resp.StatusCode) }
+	_, _ = h.db.Exec(`UPDATE containers SET status = 'STOPPED', updated_at = NOW() WHERE id = $1`, containerID)
+	return nil
+}
+
+// AdminSuspendUser suspends an account, optionally until a given time. The
+// container is stopped, SSH sessions are closed and web routes are taken
+// offline; nothing is deleted.
+func (h *Handler) AdminSuspendUser(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	if userID == admin.ID { c.JSON(http.StatusBadRequest, gin.H{"error": "you can't suspend yourself"}); return }
+	var req struct {
+		Reason          string     `json:"reason" binding:"required"`
+		DurationMinutes int        `json:"duration_minutes"`
+		Until           *time.Time `json:"until"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"}); return }
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" { c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"}); return }
+	if req.DurationMinutes < 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "duration must not be negative"}); return }
+	until := req.Until
+	if until == nil && req.DurationMinutes > 0 {
+		t := time.Now().Add(time.Duration(req.DurationMinutes) * time.Minute)
+		until = &t
+	}
+	if until != nil && !until.After(time.Now()) { c.JSON(http.StatusBadRequest, gin.H{"error": "until must be in the future"}); return }
+
+	var prevReason *string
+	var prevUntil *time.Time
+	var wasSuspended bool
+	err = h.db.QueryRow(`SELECT suspended_at IS NOT NULL, suspension_reason, suspended_until FROM users WHERE id = $1`, userID).Scan(&wasSuspended, &prevReason, &prevUntil)
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": "user not found"}); return }
+	_, err = h.db.Exec(`
+		UPDATE users SET suspended_at = COALESCE(suspended_at, NOW()), suspended_until = $1, suspension_reason = $2, suspended_by = $3, updated_at = NOW()
+		WHERE id = $4
+	`, until, req.Reason, admin.ID, userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to suspend user"}); return }
+
+	rid := c.GetString("request_id")
+	if err := h.stopUserContainer(userID); err != nil {
+		log.Printf("rid=%s suspend: failed to stop container for user %d: %v", rid, userID, err)
+	}
+	if err := h.dns.DisableUserRoutes(h.db.DB, userID); err != nil {
+		log.Printf("rid=%s suspend: failed to disable routes for user %d: %v", rid, userID, err)
+	}
+	closed := h.gateway.KillUserSessions(userID)
+
+	h.audit(c, "user.suspend", "user", c.Param("id"), gin.H{
+		"suspended":         auditChange(wasSuspended, true),
+		"suspension_reason": auditChange(prevReason, req.Reason),
+		"suspended_until":   auditChange(prevUntil, until),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "user suspended", "suspended_until": until, "ssh_sessions_closed": closed})
+}
+
+func (h *Handler) AdminUnsuspendUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	var prevReason *string
+	if err := h.db.QueryRow(`SELECT suspension_reason FROM users WHERE id = $1 AND suspended_at IS NOT NULL`, userID).Scan(&prevReason); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user is not suspended"}); return
+	}
+	_, err = h.db.Exec(`
+		UPDATE users SET suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL, suspended_by = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lift suspension"}); return }
+	if err := h.dns.RestoreUserRoutes(h.db.DB, userID); err != nil {
+		log.Printf("rid=%s unsuspend: failed to restore routes for user %d: %v", c.GetString("request_id"), userID, err)
+	}
+	h.audit(c, "user.unsuspend", "user", c.Param("id"), gin.H{"suspended": auditChange(true, false), "suspension_reason": auditChange(prevReason, nil)})
+	c.JSON(http.StatusOK, gin.H{"message": "suspension lifted"})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/den/internal/auth"
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// patRouteScopes lists the routes personal access tokens may call and the
+// scope each one needs. Anything not listed here is refused, so new routes
+// stay browser-only until they are added deliberately.
+var patRouteScopes = map[string]string{
+	"GET /user/container":            auth.ScopeRead,
+	"GET /user/container/stats":      auth.ScopeRead,
+	"GET /user/container/shell":      auth.ScopeRead,
+	"GET /user/api/subdomains":       auth.ScopeRead,
+	"GET /user/api/logins":           auth.ScopeRead,
+	"GET /user/custom-domains":       auth.ScopeRead,
+	"GET /user/ssh/certificates":     auth.ScopeRead,
+	"GET /user/ssh/ca.pub":           auth.ScopeRead,
+	"GET /user/jobs":                 auth.ScopeRead,
+	"GET /user/jobs/:id":             auth.ScopeRead,
+	"GET /user/jobs/:id/events":      auth.ScopeRead,
+	"POST /user/container/start":     auth.ScopeContainerControl,
+	"POST /user/container/stop":      auth.ScopeContainerControl,
+	"POST /user/container/restart":   auth.ScopeContainerControl,
+	"POST /user/container/shell":     auth.ScopeContainerControl,
+	"POST /user/container/ports/new": auth.ScopeContainerControl,
+	"POST /user/subdomains":          auth.ScopeSubdomainsWrite,
+	"DELETE /user/subdomains/:id":    auth.ScopeSubdomainsWrite,
+	"POST /user/custom-domains":      auth.ScopeSubdomainsWrite,
+	"POST /user/custom-domains/:id/verify": auth.ScopeSubdomainsWrite,
+	"DELETE /user/custom-domains/:id":      auth.ScopeSubdomainsWrite,
+	"POST /user/container/export":    auth.ScopeExports,
+}
+
+func (h *Handler) authenticateAccessToken(c *gin.Context, token string) {
+	user, scopes, err := h.auth.GetUserByAccessToken(token, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired access token"})
+		c.Abort(); return
+	}
+	required, ok := patRouteScopes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "this endpoint is not available to personal access tokens"})
+		c.Abort(); return
+	}
+	granted := false
+	for _, s := range scopes {
+		if s == required { granted = true; break }
+	}
+	if !granted {
+		c.JSON(http.StatusForbidden, gin.H{"error": "token is missing the " + required + " scope"})
+		c.Abort(); return
+	}
+	if !user.AgreedToTOS || !user.AgreedToPrivacy {
+		c.JSON(http.StatusForbidden, gin.H{"error": "accept the acceptable use policy in the dashboard first"})
+		c.Abort(); return
+	}
+	c.Set("user", user)
+	c.Set("token_scopes", scopes)
+	c.Next()
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func (h *Handler) TokensPage(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	tokens, err := h.auth.ListAccessTokens(user.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	h.inertia(c, "Tokens", gin.H{"user": user, "tokens": tokens, "scopes": auth.AccessTokenScopes})
+}
+
+func (h *Handler) ListAccessTokens(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	tokens, err := h.auth.ListAccessTokens(user.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+func (h *Handler) CreateAccessToken(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	var req struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes" binding:"required"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "name and scopes are required"}); return }
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 { c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-100 characters"}); return }
+	if len(req.Scopes) == 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "pick at least one scope"}); return }
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > 365 { c.JSON(http.StatusBadRequest, gin.H{"error": "expiry must be between 1 and 365 days, or 0 for none"}); return }
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+	token, pat, err := h.auth.CreateAccessToken(user.ID, req.Name, req.Scopes, expiresAt)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, gin.H{"token": token, "access_token": pat})
+}
+
+func (h *Handler) DeleteAccessToken(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"}); return }
+	ok, err := h.auth.DeleteAccessToken(user.ID, id)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete token"}); return }
+	if !ok { c.JSON(http.StatusNotFound, gin.H{"error": "token not found"}); return }
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/den/internal/auth"
+	"github.com/den/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+type codeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// checkSecondFactor verifies a TOTP or recovery code for the session's user
+// and answers the request itself when the code is wrong.
+func (h *Handler) checkSecondFactor(c *gin.Context, userID int, sessionID, code string) bool {
+	err := h.auth.VerifySecondFactor(userID, code)
+	if err == nil { return true }
+	if !errors.Is(err, auth.ErrInvalidCode) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check code"})
+		return false
+	}
+	if sessionID != "" && h.auth.RecordMFAFailure(sessionID) {
+		c.SetCookie("session", "", -1, "/", "", false, true)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "too many wrong codes, log in again", "redirect": "/login"})
+		return false
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+	return false
+}
+
+// RequireStepUp guards sensitive actions. Users with two-factor enabled must
+// have entered a code within auth.StepUpWindow; the UI catches
+// step_up_required, asks for a code and retries. Personal access tokens are
+// already scoped and were created behind this check, so they pass.
+func (h *Handler) RequireStepUp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("token_scopes"); ok { c.Next(); return }
+		user := c.MustGet("user").(*models.User)
+		if !user.TOTPEnabled { c.Next(); return }
+		sessionID, _ := c.Cookie("session")
+		if h.auth.RecentlyReauthenticated(sessionID) { c.Next(); return }
+		c.JSON(http.StatusForbidden, gin.H{"error": "enter your two-factor code to continue", "step_up_required": true})
+		c.Abort()
+	}
+}
+
+func (h *Handler) twoFactorProps(user *models.User) gin.H {
+	remaining := 0
+	if user.TOTPEnabled { remaining, _ = h.auth.RecoveryCodesRemaining(user.ID) }
+	return gin.H{
+		"user":                     user,
+		"enabled":                  user.TOTPEnabled,
+		"recovery_codes_remaining": remaining,
+		"required":                 len(auth.Permissions(user)) > 0 && h.auth.RequireAdminTOTP(),
+	}
+}
+
+func (h *Handler) TwoFactorPage(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	h.inertia(c, "TwoFactor", h.twoFactorProps(user))
+}
+
+func (h *Handler) TwoFactorStatus(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	c.JSON(http.StatusOK, h.twoFactorProps(user))
+}
+
+func (h *Handler) BeginTwoFactor(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	secret, uri, err := h.auth.BeginTOTPEnrolment(user)
+	if errors.Is(err, auth.ErrTOTPAlreadyEnabled) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrolment"}); return }
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "provisioning_uri": uri})
+}
+
+func (h *Handler) ConfirmTwoFactor(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"}); return }
+	codes, err := h.auth.ConfirmTOTP(user.ID, req.Code)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "that code didn't match, check your device's clock and try again"}); return
+	case errors.Is(err, auth.ErrTOTPNotEnrolled), errors.Is(err, auth.ErrTOTPAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor"}); return
+	}
+	sessionID, _ := c.Cookie("session")
+	_ = h.auth.MarkReauthenticated(sessionID)
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor enabled", "recovery_codes": codes})
+}
+
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"}); return }
+	if !user.TOTPEnabled { c.JSON(http.StatusConflict, gin.H{"error": "two-factor is not enabled"}); return }
+	sessionID, _ := c.Cookie("session")
+	if !h.checkSecondFactor(c, user.ID, sessionID, req.Code) { return }
+	err := h.auth.DisableTOTP(user)
+	if errors.Is(err, auth.ErrTOTPRequiredForRole) { c.JSON(http.StatusForbidden, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor"}); return }
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor disabled"})
+}
+
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"}); return }
+	if !user.TOTPEnabled { c.JSON(http.StatusConflict, gin.H{"error": "two-factor is not enabled"}); return }
+	sessionID, _ := c.Cookie("session")
+	if !h.checkSecondFactor(c, user.ID, sessionID, req.Code) { return }
+	codes, err := h.auth.RegenerateRecoveryCodes(user.ID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"}); return }
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// StepUp re-checks the second factor so RequireStepUp lets the next few
+// sensitive requests through.
+func (h *Handler) StepUp(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"}); return }
+	sessionID, _ := c.Cookie("session")
+	if !h.checkSecondFactor(c, user.ID, sessionID, req.Code) { return }
+	if err := h.auth.MarkReauthenticated(sessionID); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"}); return }
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (h *Handler) TwoFactorLoginPage(c *gin.Context) {
+	sessionID, _ := c.Cookie("session")
+	user, err := h.auth.GetPendingMFAUser(sessionID)
+	if err != nil { c.Redirect(http.StatusFound, "/login"); return }
+	h.inertia(c, "TwoFactorLogin", gin.H{"username": user.Username})
+}
+
+func (h *Handler) TwoFactorLogin(c *gin.Context) {
+	sessionID, _ := c.Cookie("session")
+	user, err := h.auth.GetPendingMFAUser(sessionID)
+	if err != nil { c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, start again", "redirect": "/login"}); return }
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"}); return }
+	if !h.checkSecondFactor(c, user.ID, sessionID, req.Code) { return }
+	if err := h.auth.CompleteMFASession(sessionID); err != nil {
+		log.Printf("failed to complete 2fa login for %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log in"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redirect": "/user/dashboard"})
+}
+
+// AdminResetTwoFactor removes two-factor from an account whose owner lost
+// both their device and their recovery codes.
+func (h *Handler) AdminResetTwoFactor(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	ok, err := h.auth.ResetTOTP(userID)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset two-factor"}); return }
+	if !ok { c.JSON(http.StatusNotFound, gin.H{"error": "user has no two-factor enrolment"}); return }
+	h.audit(c, "user.reset_2fa", "user", c.Param("id"), gin.H{"totp_enabled": auditChange(true, false)})
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor reset"})
+}
+
+func (h *Handler) AdminGetSecuritySettings(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"require_admin_2fa": h.auth.RequireAdminTOTP()})
+}
+
+func (h *Handler) AdminUpdateSecuritySettings(c *gin.Context) {
+	admin := c.MustGet("user").(*models.User)
+	var req struct {
+		RequireAdmin2FA *bool `json:"require_admin_2fa" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "require_admin_2fa is required"}); return }
+	// turning it on without 2fa yourself would lock you out on the next request
+	if *req.RequireAdmin2FA && !admin.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "enable two-factor on your own account first"}); return
+	}
+	prev := h.auth.RequireAdminTOTP()
+	if err := h.auth.SetRequireAdminTOTP(*req.RequireAdmin2FA, admin.ID); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save setting"}); return }
+	h.audit(c, "settings.update", "setting", "require_admin_2fa", gin.H{"require_admin_2fa": auditChange(prev, *req.RequireAdmin2FA)})
+	c.JSON(http.StatusOK, gin.H{"require_admin_2fa": *req.RequireAdmin2FA})
+}
@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@fortnightly",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Monday
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	at := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", base, at(2024, 1, 15, 10, 31)},
+		{"*/15 * * * *", base, at(2024, 1, 15, 10, 45)},
+		// strictly after, and seconds are dropped
+		{"*/15 * * * *", at(2024, 1, 15, 10, 45), at(2024, 1, 15, 11, 0)},
+		{"*/15 * * * *", base.Add(14*time.Minute + 59*time.Second), at(2024, 1, 15, 10, 45)},
+		{"5/20 * * * *", base, at(2024, 1, 15, 10, 45)},
+		{"10-20/5 * * * *", base, at(2024, 1, 15, 11, 10)},
+		{"0,50 * * * *", base, at(2024, 1, 15, 10, 50)},
+		{"@hourly", base, at(2024, 1, 15, 11, 0)},
+		{"@daily", base, at(2024, 1, 16, 0, 0)},
+		{"@monthly", base, at(2024, 2, 1, 0, 0)},
+		{"@yearly", base, at(2025, 1, 1, 0, 0)},
+		{"0 0 1 1 *", at(2024, 12, 31, 23, 59), at(2025, 1, 1, 0, 0)},
+		{"0 12 * jan,jul *", base, at(2024, 1, 15, 12, 0)},
+		{"0 12 * JUL *", base, at(2024, 7, 1, 12, 0)},
+		// Sunday is 0, 7 or sun
+		{"0 0 * * 0", base, at(2024, 1, 21, 0, 0)},
+		{"0 0 * * 7", base, at(2024, 1, 21, 0, 0)},
+		{"0 0 * * sun", base, at(2024, 1, 21, 0, 0)},
+		{"@weekly", base, at(2024, 1, 21, 0, 0)},
+		{"0 9 * * mon-fri", base, at(2024, 1, 16, 9, 0)},
+		{"0 9 * * 5-7", base, at(2024, 1, 19, 9, 0)},
+		// both day fields restricted: either one matching is enough
+		{"0 0 20 * 3", base, at(2024, 1, 17, 0, 0)},
+		{"0 0 16 * 5", base, at(2024, 1, 16, 0, 0)},
+		// only one restricted: that one decides
+		{"0 0 20 * *", base, at(2024, 1, 20, 0, 0)},
+		{"0 0 */10 * *", base, at(2024, 1, 21, 0, 0)},
+		{"0 0 31 * *", at(2024, 2, 1, 0, 0), at(2024, 3, 31, 0, 0)},
+		{"0 0 29 2 *", at(2024, 3, 1, 0, 0), at(2028, 2, 29, 0, 0)},
+		// evaluated in UTC whatever the input's zone
+		{"0 12 * * *", time.Date(2024, 1, 15, 13, 30, 0, 0, time.FixedZone("CEST", 2*60*60)), at(2024, 1, 15, 12, 0)},
+		// no such date
+		{"0 0 30 2 *", base, time.Time{}},
+		{"0 0 31 4,6,9,11 *", base, time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := c.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q from %s: Next = %s, want %s", tt.expr, tt.from.Format(time.RFC3339), got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+		}
+	}
+}
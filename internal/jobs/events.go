@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// eventsChannel is notified with the job id whenever a job gets an event or
+// changes status.
+const eventsChannel = "den_job_events"
+
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Event is one progress step of a job, as shown to users following it.
+type Event struct {
+	ID        int64     `json:"id"`
+	JobID     int       `json:"job_id"`
+	Level     string    `json:"level"`
+	Step      string    `json:"step"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordEvent appends a progress event to a job.
+func RecordEvent(db *sql.DB, jobID int, level, step, message string) error {
+	_, err := db.Exec(`INSERT INTO job_events (job_id, level, step, message) VALUES ($1, $2, $3, $4)`, jobID, level, step, message)
+	return err
+}
+
+// Progress records a step from inside a handler. Failing to record it never
+// fails the job.
+func (j *Job) Progress(step, message string) {
+	if j.db == nil {
+		return
+	}
+	if err := RecordEvent(j.db, j.ID, LevelInfo, step, message); err != nil {
+		log.Printf("jobs: record %s event on #%d: %v", step, j.ID, err)
+	}
+}
+
+func (r *Runner) event(jobID int, level, step, message string) {
+	if err := RecordEvent(r.db, jobID, level, step, message); err != nil {
+		log.Printf("jobs: record %s event on #%d: %v", step, jobID, err)
+	}
+}
+
+// ListEvents returns a job's events after the given event id, oldest first.
+func ListEvents(db *sql.DB, jobID int, afterID int64) ([]Event, error) {
+	rows, err := db.Query(`
+		SELECT id, job_id, level, step, message, created_at FROM job_events
+		WHERE job_id = $1 AND id > $2 ORDER BY id
+	`, jobID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.JobID, &e.Level, &e.Step, &e.Message, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Feed fans den_job_events notifications out to the streams following each
+// job, over a single LISTEN connection opened on first use.
+type Feed struct {
+	dsn  string
+	once sync.Once
+	mu   sync.Mutex
+	subs map[int]map[chan struct{}]struct{}
+}
+
+func NewFeed(dsn string) *Feed {
+	return &Feed{dsn: dsn, subs: map[int]map[chan struct{}]struct{}{}}
+}
+
+// Subscribe returns a channel that is signalled when the job may have new
+// events. Signals are coalesced, so callers should re-read everything after
+// their last event id. Call the returned func to unsubscribe.
+func (f *Feed) Subscribe(jobID int) (<-chan struct{}, func()) {
+	f.once.Do(func() { go f.listen() })
+	ch := make(chan struct{}, 1)
+	f.mu.Lock()
+	if f.subs[jobID] == nil {
+		f.subs[jobID] = map[chan struct{}]struct{}{}
+	}
+	f.subs[jobID][ch] = struct{}{}
+	f.mu.Unlock()
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subs[jobID], ch)
+		if len(f.subs[jobID]) == 0 {
+			delete(f.subs, jobID)
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *Feed) notify(jobID int, all bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, chans := range f.subs {
+		if !all && id != jobID {
+			continue
+		}
+		for ch := range chans {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (f *Feed) listen() {
+	l := pq.NewListener(f.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("jobs: event listener: %v", err)
+		}
+	})
+	if err := l.Listen(eventsChannel); err != nil {
+		log.Printf("jobs: listen %s: %v", eventsChannel, err)
+	}
+	for {
+		select {
+		case n := <-l.Notify:
+			if n == nil {
+				f.notify(0, true)
+				continue
+			}
+			if id, err := strconv.Atoi(n.Extra); err == nil {
+				f.notify(id, false)
+			}
+		case <-time.After(90 * time.Second):
+			go l.Ping()
+		}
+	}
+}
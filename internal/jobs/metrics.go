@@ -0,0 +1,88 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "den_jobs_queue_depth", Help: "Jobs that are due and waiting for a worker"},
+		[]string{"type"},
+	)
+	jobsRunning = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "den_jobs_running", Help: "Jobs currently being processed"},
+		[]string{"type"},
+	)
+	queueLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "den_jobs_queue_latency_seconds", Help: "Time from a job coming due to a worker claiming it", Buckets: []float64{.01, .05, .1, .5, 1, 5, 15, 30, 60, 300}},
+		[]string{"type"},
+	)
+	duration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "den_jobs_duration_seconds", Help: "Time spent running a job", Buckets: []float64{.1, .5, 1, 5, 15, 30, 60, 300, 900, 1800}},
+		[]string{"type"},
+	)
+	failures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "den_jobs_failures_total", Help: "Failed job attempts, including ones that will be retried"},
+		[]string{"type"},
+	)
+	processed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "den_jobs_processed_total", Help: "Job attempts by outcome (success, retry, failed, lease_lost)"},
+		[]string{"type", "outcome"},
+	)
+	leasesExpired = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "den_jobs_leases_expired_total", Help: "Running jobs requeued or failed because their worker stopped renewing the lease"},
+		[]string{"type"},
+	)
+	scheduledRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "den_jobs_scheduled_total", Help: "Jobs enqueued by a schedule tick"},
+		[]string{"schedule"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, jobsRunning, queueLatency, duration, failures, processed, leasesExpired, scheduledRuns)
+}
+
+func (r *Runner) reportQueueDepth(ctx context.Context) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := r.updateQueueDepth(); err != nil {
+			log.Printf("jobs: queue depth: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) updateQueueDepth() error {
+	rows, err := r.db.Query(`SELECT type, COUNT(*) FROM jobs WHERE status = 'queued' AND run_after <= NOW() GROUP BY type`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	depth := map[string]int{}
+	for q := range r.queues {
+		depth[q] = 0
+	}
+	for rows.Next() {
+		var t string
+		var n int
+		if err := rows.Scan(&t, &n); err != nil {
+			return err
+		}
+		depth[t] = n
+	}
+	queueDepth.Reset()
+	for t, n := range depth {
+		queueDepth.WithLabelValues(t).Set(float64(n))
+	}
+	return rows.Err()
+}
@@ -0,0 +1,454 @@
+// Package jobs runs the background work queued in the jobs table. Each job
+// type gets its own pool of workers so a slow export can't hold up container
+// creation, and workers are woken by Postgres NOTIFY instead of polling.
+// Recurring work is enqueued from cron schedules in the schedules table.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// notifyChannel is what the jobs_notify_queued trigger notifies, with the
+// job type as the payload.
+const notifyChannel = "den_jobs"
+
+// Job is a claimed row from the jobs table.
+type Job struct {
+	ID          int
+	Type        string
+	Payload     []byte
+	Attempts    int
+	MaxAttempts int
+
+	db *sql.DB
+}
+
+// HandlerFunc runs one job. The result is stored on success. An error is
+// recorded on the job, which is retried with backoff until it runs out of
+// attempts.
+type HandlerFunc func(ctx context.Context, job *Job) ([]byte, error)
+
+type Config struct {
+	// Workers sets the pool size per job type. Types not listed get
+	// DefaultWorkers.
+	Workers        map[string]int
+	DefaultWorkers int
+	// PollInterval is the fallback check for jobs whose run_after has come
+	// due and for notifications lost while the listener was reconnecting.
+	PollInterval time.Duration
+	// ShutdownTimeout is how long shutdown waits for running jobs.
+	ShutdownTimeout time.Duration
+	// LeaseDuration is how long a claimed job stays ours without a renewal.
+	// Leases are renewed at a third of this while the handler runs, so a job
+	// whose worker died is requeued within about this long.
+	LeaseDuration time.Duration
+}
+
+// ConfigFromEnv reads JOB_WORKERS (e.g. "export_container=1,create_container=4"),
+// JOB_DEFAULT_WORKERS, JOB_POLL_INTERVAL, JOB_SHUTDOWN_TIMEOUT and
+// JOB_LEASE_DURATION.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Workers:         map[string]int{},
+		DefaultWorkers:  2,
+		PollInterval:    15 * time.Second,
+		ShutdownTimeout: 2 * time.Minute,
+		LeaseDuration:   time.Minute,
+	}
+	for _, part := range strings.Split(os.Getenv("JOB_WORKERS"), ",") {
+		name, count, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(count)); err == nil && n > 0 {
+			cfg.Workers[strings.TrimSpace(name)] = n
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("JOB_DEFAULT_WORKERS")); err == nil && n > 0 {
+		cfg.DefaultWorkers = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("JOB_POLL_INTERVAL")); err == nil && d > 0 {
+		cfg.PollInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("JOB_SHUTDOWN_TIMEOUT")); err == nil && d > 0 {
+		cfg.ShutdownTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("JOB_LEASE_DURATION")); err == nil && d >= 3*time.Second {
+		cfg.LeaseDuration = d
+	}
+	return cfg
+}
+
+type queue struct {
+	jobType string
+	workers int
+	handler HandlerFunc
+	// wake has room for one pending signal; a worker that claims a job passes
+	// the signal on so idle siblings drain a burst in parallel.
+	wake chan struct{}
+}
+
+func (q *queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+type Runner struct {
+	db  *sql.DB
+	dsn string
+	cfg Config
+	// owner identifies this process in jobs.lease_owner.
+	owner  string
+	queues map[string]*queue
+	// workflows holds the steps of job types registered with HandleWorkflow.
+	workflows map[string][]Step
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewRunner needs the connection string as well as the pool because LISTEN
+// holds a dedicated connection.
+func NewRunner(db *sql.DB, dsn string, cfg Config) *Runner {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	owner := fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b))
+	return &Runner{db: db, dsn: dsn, cfg: cfg, owner: owner, queues: map[string]*queue{}, workflows: map[string][]Step{}}
+}
+
+// Handle registers the handler for a job type. It must be called before Start.
+func (r *Runner) Handle(jobType string, handler HandlerFunc) {
+	workers := r.cfg.Workers[jobType]
+	if workers <= 0 {
+		workers = r.cfg.DefaultWorkers
+	}
+	r.queues[jobType] = &queue{jobType: jobType, workers: workers, handler: handler, wake: make(chan struct{}, 1)}
+}
+
+func (r *Runner) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	for _, q := range r.queues {
+		log.Printf("jobs: starting %d workers for %s", q.workers, q.jobType)
+		for i := 0; i < q.workers; i++ {
+			r.wg.Add(1)
+			go r.work(ctx, q)
+		}
+	}
+	go r.listen(ctx)
+	go r.reapExpiredLeases(ctx)
+	go r.reportQueueDepth(ctx)
+	go r.runSchedules(ctx)
+	if len(r.workflows) > 0 {
+		go r.compensateAbandoned(ctx)
+	}
+}
+
+// Shutdown stops claiming new jobs and waits for running ones to finish or
+// for ctx to expire. Jobs are not interrupted.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) work(ctx context.Context, q *queue) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		job, err := r.claim(q.jobType)
+		if err != nil {
+			log.Printf("jobs: claim %s: %v", q.jobType, err)
+		}
+		if job != nil {
+			q.signal()
+			r.run(q, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) claim(jobType string) (*Job, error) {
+	job := &Job{Type: jobType, db: r.db}
+	var waited float64
+	err := r.db.QueryRow(`
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, updated_at = NOW(),
+		       lease_owner = $2, lease_expires_at = NOW() + $3 * INTERVAL '1 second'
+		WHERE id = (
+			SELECT id FROM jobs WHERE status = 'queued' AND type = $1 AND run_after <= NOW()
+			ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, payload, attempts, max_attempts, EXTRACT(EPOCH FROM NOW() - run_after)
+	`, jobType, r.owner, r.cfg.LeaseDuration.Seconds()).Scan(&job.ID, &job.Payload, &job.Attempts, &job.MaxAttempts, &waited)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	queueLatency.WithLabelValues(jobType).Observe(waited)
+	r.event(job.ID, LevelInfo, "started", fmt.Sprintf("attempt %d of %d", job.Attempts, maxAttempts(job)))
+	return job, nil
+}
+
+func (r *Runner) run(q *queue, job *Job) {
+	jobsRunning.WithLabelValues(q.jobType).Inc()
+	defer jobsRunning.WithLabelValues(q.jobType).Dec()
+	ctx, cancel := context.WithCancel(context.Background())
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		r.renewLease(ctx, cancel, job)
+	}()
+	start := time.Now()
+	result, err := safeRun(ctx, q.handler, job)
+	cancel()
+	<-renewed
+	duration.WithLabelValues(q.jobType).Observe(time.Since(start).Seconds())
+	if err != nil {
+		failures.WithLabelValues(q.jobType).Inc()
+		log.Printf("jobs: %s #%d attempt %d failed: %v", q.jobType, job.ID, job.Attempts, err)
+	}
+	outcome, ferr := r.finish(q, job, result, err)
+	if ferr == errLeaseLost {
+		log.Printf("jobs: %s #%d was cancelled, requeued or reaped while running, outcome not recorded", q.jobType, job.ID)
+		outcome = "lease_lost"
+	} else if ferr != nil {
+		log.Printf("jobs: failed to record outcome of %s #%d: %v", q.jobType, job.ID, ferr)
+	}
+	processed.WithLabelValues(q.jobType, outcome).Inc()
+}
+
+// renewLease extends the job's lease until ctx is done. If the lease turns
+// out to have been taken over, the handler's context is cancelled.
+func (r *Runner) renewLease(ctx context.Context, cancel context.CancelFunc, job *Job) {
+	ticker := time.NewTicker(r.cfg.LeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		res, err := r.db.Exec(`
+			UPDATE jobs SET lease_expires_at = NOW() + $3 * INTERVAL '1 second'
+			WHERE id = $1 AND lease_owner = $2 AND status = 'running'
+		`, job.ID, r.owner, r.cfg.LeaseDuration.Seconds())
+		if err != nil {
+			log.Printf("jobs: renew lease on %s #%d: %v", job.Type, job.ID, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("jobs: %s #%d is no longer ours, cancelling", job.Type, job.ID)
+			cancel()
+			return
+		}
+	}
+}
+
+// safeRun keeps a panicking handler from taking the worker down with it.
+func safeRun(ctx context.Context, handler HandlerFunc, job *Job) (result []byte, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+var errLeaseLost = errors.New("lease lost")
+
+// finish records the outcome: success, another attempt after an exponential
+// backoff, or failure once max_attempts is used up. Nothing is written if
+// another worker took the job over after our lease expired.
+func (r *Runner) finish(q *queue, job *Job, result []byte, jobErr error) (string, error) {
+	var outcome string
+	var res sql.Result
+	var err error
+	switch {
+	case jobErr == nil:
+		var stored interface{}
+		if len(result) > 0 {
+			stored = string(result)
+		}
+		outcome = "success"
+		res, err = r.db.Exec(`
+			UPDATE jobs SET status = 'success', result = $3, lease_owner = NULL, lease_expires_at = NULL, updated_at = NOW()
+			WHERE id = $1 AND lease_owner = $2
+		`, job.ID, r.owner, stored)
+	case job.Attempts < maxAttempts(job):
+		delay := backoff(job.Attempts)
+		outcome = "retry"
+		res, err = r.db.Exec(`
+			UPDATE jobs SET status = 'queued', run_after = NOW() + $3 * INTERVAL '1 second', error = $4,
+			       lease_owner = NULL, lease_expires_at = NULL, updated_at = NOW()
+			WHERE id = $1 AND lease_owner = $2
+		`, job.ID, r.owner, int(delay.Seconds()), jobErr.Error())
+		if err == nil {
+			time.AfterFunc(delay, q.signal)
+		}
+	default:
+		outcome = "failed"
+		res, err = r.db.Exec(`
+			UPDATE jobs SET status = 'failed', error = $3, lease_owner = NULL, lease_expires_at = NULL, updated_at = NOW()
+			WHERE id = $1 AND lease_owner = $2
+		`, job.ID, r.owner, jobErr.Error())
+	}
+	if err != nil {
+		return outcome, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return outcome, errLeaseLost
+	}
+	switch outcome {
+	case "success":
+		r.event(job.ID, LevelInfo, "done", "")
+	case "retry":
+		r.event(job.ID, LevelWarn, "retrying", fmt.Sprintf("%v; trying again in %s", jobErr, backoff(job.Attempts)))
+	default:
+		r.event(job.ID, LevelError, "failed", jobErr.Error())
+	}
+	return outcome, nil
+}
+
+func maxAttempts(job *Job) int {
+	if job.MaxAttempts <= 0 {
+		return 3
+	}
+	return job.MaxAttempts
+}
+
+// reapExpiredLeases requeues jobs whose worker stopped renewing its lease,
+// typically because the master crashed or was killed mid-job. Jobs that
+// have used up their attempts are failed instead. Running rows without a
+// lease predate leases and are treated as expired.
+func (r *Runner) reapExpiredLeases(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.LeaseDuration / 2)
+	defer ticker.Stop()
+	for {
+		if err := r.reap(); err != nil {
+			log.Printf("jobs: reaping expired leases: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) reap() error {
+	rows, err := r.db.Query(`
+		UPDATE jobs SET
+			status = CASE WHEN attempts < COALESCE(NULLIF(max_attempts, 0), 3) THEN 'queued' ELSE 'failed' END,
+			error = 'lease expired, worker ' || COALESCE(lease_owner, 'unknown') || ' stopped responding',
+			run_after = NOW(), lease_owner = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE status = 'running' AND (lease_expires_at IS NULL OR lease_expires_at < NOW())
+		RETURNING id, type, status
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type reapedJob struct {
+		id     int
+		status string
+	}
+	var reaped []reapedJob
+	for rows.Next() {
+		var id int
+		var jobType, status string
+		if err := rows.Scan(&id, &jobType, &status); err != nil {
+			return err
+		}
+		leasesExpired.WithLabelValues(jobType).Inc()
+		log.Printf("jobs: %s #%d lease expired, now %s", jobType, id, status)
+		reaped = append(reaped, reapedJob{id, status})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, j := range reaped {
+		if j.status == "queued" {
+			r.event(j.id, LevelWarn, "lease_expired", "the worker running this job stopped responding; it will be retried")
+		} else {
+			r.event(j.id, LevelError, "lease_expired", "the worker running this job stopped responding and it has no attempts left")
+		}
+	}
+	return nil
+}
+
+// backoff is 5s after the first attempt, doubling up to five minutes.
+func backoff(attempts int) time.Duration {
+	secs := math.Pow(2, float64(attempts-1)) * 5
+	if secs > 300 {
+		secs = 300
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func (r *Runner) listen(ctx context.Context) {
+	l := pq.NewListener(r.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("jobs: listener: %v", err)
+		}
+	})
+	defer l.Close()
+	if err := l.Listen(notifyChannel); err != nil {
+		log.Printf("jobs: listen failed, falling back to polling every %s: %v", r.cfg.PollInterval, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-l.Notify:
+			if n == nil {
+				// reconnected; anything sent while we were away is lost
+				for _, q := range r.queues {
+					q.signal()
+				}
+				continue
+			}
+			if q, ok := r.queues[n.Extra]; ok {
+				q.signal()
+			}
+		case <-time.After(90 * time.Second):
+			go l.Ping()
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{4, 40 * time.Second},
+		{5, 80 * time.Second},
+		{6, 160 * time.Second},
+		{7, 5 * time.Minute},
+		{8, 5 * time.Minute},
+		{1000, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// fakeJobs is a database/sql driver that hands out queued job ids to the
+// claim query and accepts every other statement, so the worker pool can run
+// without Postgres. LISTEN needs a real server and isn't covered here.
+type fakeJobs struct {
+	mu       sync.Mutex
+	pending  []int
+	claims   int
+	finished []int
+}
+
+func (f *fakeJobs) queue(ids ...int) {
+	f.mu.Lock()
+	f.pending = append(f.pending, ids...)
+	f.mu.Unlock()
+}
+
+func (f *fakeJobs) counts() (claims, finished int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.claims, len(f.finished)
+}
+
+func (f *fakeJobs) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeJobs) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ f *fakeJobs }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.f, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("no transactions") }
+
+type fakeStmt struct {
+	f     *fakeJobs
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "status = 'success'") {
+		s.f.mu.Lock()
+		s.f.finished = append(s.f.finished, int(args[0].(int64)))
+		s.f.mu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &fakeRows{cols: []string{"id", "payload", "attempts", "max_attempts", "waited"}}
+	if !strings.Contains(s.query, "status = 'running'") {
+		return rows, nil
+	}
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	s.f.claims++
+	if len(s.f.pending) > 0 {
+		rows.vals = [][]driver.Value{{int64(s.f.pending[0]), []byte("{}"), int64(1), int64(3), float64(0)}}
+		s.f.pending = s.f.pending[1:]
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+// startWorkers starts the worker pools the way Start does, without the
+// listener and the other background loops.
+func startWorkers(r *Runner) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	for _, q := range r.queues {
+		for i := 0; i < q.workers; i++ {
+			r.wg.Add(1)
+			go r.work(ctx, q)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandleWorkers(t *testing.T) {
+	r := NewRunner(nil, "", Config{Workers: map[string]int{"export": 1, "create": 4}, DefaultWorkers: 2})
+	for _, jobType := range []string{"export", "create", "other"} {
+		r.Handle(jobType, nil)
+	}
+	for jobType, want := range map[string]int{"export": 1, "create": 4, "other": 2} {
+		if got := r.queues[jobType].workers; got != want {
+			t.Errorf("%s has %d workers, want %d", jobType, got, want)
+		}
+	}
+}
+
+func TestQueueSignalCoalesces(t *testing.T) {
+	q := &queue{wake: make(chan struct{}, 1)}
+	q.signal()
+	q.signal()
+	if len(q.wake) != 1 {
+		t.Fatalf("%d pending signals, want 1", len(q.wake))
+	}
+}
+
+func TestRunnerWakeAndShutdown(t *testing.T) {
+	f := &fakeJobs{}
+	db := sql.OpenDB(f)
+	defer db.Close()
+	// a long poll interval so only signals wake the workers
+	r := NewRunner(db, "", Config{DefaultWorkers: 2, PollInterval: time.Hour, LeaseDuration: time.Minute})
+	started := make(chan int, 2)
+	release := make(chan struct{})
+	r.Handle("test", func(ctx context.Context, job *Job) ([]byte, error) {
+		started <- job.ID
+		<-release
+		return nil, nil
+	})
+	startWorkers(r)
+
+	// both workers find nothing and go to sleep
+	waitFor(t, "the initial claims", func() bool { claims, _ := f.counts(); return claims == 2 })
+
+	// one signal wakes a worker, which passes it on so its sibling picks up
+	// the second job in parallel
+	f.queue(1, 2)
+	r.queues["test"].signal()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of 2 jobs started", i)
+		}
+	}
+
+	// shutdown waits for running jobs rather than interrupting them
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown with jobs running: err = %v, want deadline exceeded", err)
+	}
+	close(release)
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, finished := f.counts(); finished != 2 {
+		t.Fatalf("%d jobs recorded as done, want 2", finished)
+	}
+
+	// stopped workers don't claim anything else
+	claims, _ := f.counts()
+	f.queue(3)
+	r.queues["test"].signal()
+	time.Sleep(20 * time.Millisecond)
+	if after, _ := f.counts(); after != claims {
+		t.Fatalf("a stopped runner made %d more claims", after-claims)
+	}
+}
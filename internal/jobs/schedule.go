@@ -0,0 +1,128 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// scheduleInterval is how often the runner looks for schedules that are due.
+// Cron has minute resolution, so this only bounds how late a tick can be.
+const scheduleInterval = 10 * time.Second
+
+// runSchedules enqueues a job for every schedule that has come due. Each
+// master runs this loop; the row lock on the schedule and the advanced
+// next_run_at mean only one of them enqueues a given tick.
+func (r *Runner) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.enqueueDue(); err != nil {
+			log.Printf("jobs: schedules: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) enqueueDue() error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`
+		SELECT s.id, s.name, s.cron, s.job_type, s.payload, COALESCE(j.status IN ('queued', 'running'), false)
+		FROM schedules s LEFT JOIN jobs j ON j.id = s.last_job_id
+		WHERE NOT s.paused AND s.next_run_at <= NOW()
+		FOR UPDATE OF s SKIP LOCKED
+	`)
+	if err != nil {
+		return err
+	}
+	type due struct {
+		id                  int
+		name, cron, jobType string
+		payload             []byte
+		busy                bool
+	}
+	var schedules []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.name, &d.cron, &d.jobType, &d.payload, &d.busy); err != nil {
+			rows.Close()
+			return err
+		}
+		schedules = append(schedules, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, d := range schedules {
+		cron, err := ParseCron(d.cron)
+		if err != nil {
+			log.Printf("jobs: schedule %s: %v", d.name, err)
+			continue
+		}
+		// ticks missed while no master was running are skipped, not replayed
+		next := cron.Next(now)
+		if d.busy {
+			// the last run is still going; don't pile another one behind it
+			log.Printf("jobs: schedule %s: previous run still in progress, skipping tick", d.name)
+			if _, err := tx.Exec(`UPDATE schedules SET next_run_at = $2, updated_at = NOW() WHERE id = $1`, d.id, nullTime(next)); err != nil {
+				return err
+			}
+			continue
+		}
+		var jobID int
+		if err := tx.QueryRow(`INSERT INTO jobs (type, status, payload) VALUES ($1, 'queued', $2) RETURNING id`, d.jobType, string(d.payload)).Scan(&jobID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			UPDATE schedules SET next_run_at = $2, last_run_at = NOW(), last_job_id = $3, updated_at = NOW() WHERE id = $1
+		`, d.id, nullTime(next), jobID); err != nil {
+			return err
+		}
+		scheduledRuns.WithLabelValues(d.name).Inc()
+	}
+	return tx.Commit()
+}
+
+// TriggerSchedule enqueues a schedule's job right away, e.g. from the admin
+// UI. The regular ticks are left as they were.
+func TriggerSchedule(db *sql.DB, scheduleID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var jobType string
+	var payload []byte
+	if err := tx.QueryRow(`SELECT job_type, payload FROM schedules WHERE id = $1 FOR UPDATE`, scheduleID).Scan(&jobType, &payload); err != nil {
+		return 0, err
+	}
+	var jobID int
+	if err := tx.QueryRow(`INSERT INTO jobs (type, status, payload) VALUES ($1, 'queued', $2) RETURNING id`, jobType, string(payload)).Scan(&jobID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE schedules SET last_run_at = NOW(), last_job_id = $2, updated_at = NOW() WHERE id = $1`, scheduleID, jobID); err != nil {
+		return 0, err
+	}
+	return jobID, tx.Commit()
+}
+
+// nullTime stores a cron that never fires again as NULL, which the due
+// query never matches.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
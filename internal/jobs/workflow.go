@@ -0,0 +1,302 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Step is one named stage of a workflow job. Steps that finished are not run
+// again when the job is retried, so a retry resumes at the step that failed.
+type Step struct {
+	Name string
+	// Attempts is how many times Run is tried within one job attempt before
+	// the job attempt fails. Defaults to 3.
+	Attempts int
+	Run      func(ctx context.Context, f *Flow) error
+	// Compensate undoes Run once the job is abandoned (failed for good or
+	// cancelled). It runs for steps that finished and for the one that was
+	// in progress, so it must cope with Run having done only part of its
+	// work. Optional.
+	Compensate func(ctx context.Context, f *Flow) error
+}
+
+// Flow is what steps see: the job, and state shared between steps that is
+// saved after every step so a retry starts where the last attempt left off.
+type Flow struct {
+	Job    *Job
+	state  map[string]json.RawMessage
+	result []byte
+}
+
+// Get decodes the state saved under key into v and reports whether it was set.
+func (f *Flow) Get(key string, v interface{}) bool {
+	raw, ok := f.state[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+func (f *Flow) Set(key string, v interface{}) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("jobs: workflow state %s: %v", key, err))
+	}
+	f.state[key] = raw
+}
+
+// SetResult sets what is stored as the job's result when the workflow
+// completes.
+func (f *Flow) SetResult(v interface{}) {
+	f.result, _ = json.Marshal(v)
+}
+
+// HandleWorkflow registers a job type that runs as a sequence of steps. It
+// must be called before Start.
+func (r *Runner) HandleWorkflow(jobType string, steps ...Step) {
+	r.workflows[jobType] = steps
+	r.Handle(jobType, func(ctx context.Context, job *Job) ([]byte, error) {
+		return r.runWorkflow(ctx, job, steps)
+	})
+}
+
+func (r *Runner) runWorkflow(ctx context.Context, job *Job, steps []Step) ([]byte, error) {
+	f, err := r.loadFlow(job)
+	if err != nil {
+		return nil, err
+	}
+	done, err := r.stepStatuses(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i, step := range steps {
+		if done[i] == "done" {
+			continue
+		}
+		if _, err := r.db.Exec(`
+			INSERT INTO job_steps (job_id, position, name, status, started_at) VALUES ($1, $2, $3, 'running', NOW())
+			ON CONFLICT (job_id, position) DO UPDATE SET status = 'running', error = NULL, started_at = NOW(), finished_at = NULL
+		`, job.ID, i, step.Name); err != nil {
+			return nil, err
+		}
+		stepErr := r.runStep(ctx, f, step, i)
+		if err := r.saveFlow(f); err != nil {
+			return nil, err
+		}
+		if stepErr != nil {
+			_, _ = r.db.Exec(`UPDATE job_steps SET status = 'failed', error = $3, finished_at = NOW() WHERE job_id = $1 AND position = $2`, job.ID, i, stepErr.Error())
+			return nil, fmt.Errorf("step %s: %w", step.Name, stepErr)
+		}
+		if _, err := r.db.Exec(`UPDATE job_steps SET status = 'done', finished_at = NOW() WHERE job_id = $1 AND position = $2`, job.ID, i); err != nil {
+			return nil, err
+		}
+	}
+	return f.result, nil
+}
+
+// runStep tries a step up to its attempt budget with a short pause between
+// tries, for blips that aren't worth a whole job retry.
+func (r *Runner) runStep(ctx context.Context, f *Flow, step Step, position int) error {
+	attempts := step.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	var err error
+	for n := 1; n <= attempts; n++ {
+		_, _ = r.db.Exec(`UPDATE job_steps SET attempts = attempts + 1 WHERE job_id = $1 AND position = $2`, f.Job.ID, position)
+		if err = safeStep(ctx, step.Run, f); err == nil || ctx.Err() != nil {
+			return err
+		}
+		if n < attempts {
+			r.event(f.Job.ID, LevelWarn, step.Name, fmt.Sprintf("try %d of %d failed: %v", n, attempts, err))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(n) * 2 * time.Second):
+			}
+		}
+	}
+	return err
+}
+
+func safeStep(ctx context.Context, fn func(context.Context, *Flow) error, f *Flow) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn(ctx, f)
+}
+
+func (r *Runner) loadFlow(job *Job) (*Flow, error) {
+	var raw []byte
+	if err := r.db.QueryRow(`SELECT state FROM jobs WHERE id = $1`, job.ID).Scan(&raw); err != nil {
+		return nil, err
+	}
+	f := &Flow{Job: job, state: map[string]json.RawMessage{}}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &f.state); err != nil {
+			return nil, fmt.Errorf("workflow state: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func (r *Runner) saveFlow(f *Flow) error {
+	raw, _ := json.Marshal(f.state)
+	res, err := r.db.Exec(`UPDATE jobs SET state = $3 WHERE id = $1 AND lease_owner = $2`, f.Job.ID, r.owner, string(raw))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+func (r *Runner) stepStatuses(jobID int) (map[int]string, error) {
+	rows, err := r.db.Query(`SELECT position, status FROM job_steps WHERE job_id = $1`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := map[int]string{}
+	for rows.Next() {
+		var pos int
+		var status string
+		if err := rows.Scan(&pos, &status); err != nil {
+			return nil, err
+		}
+		statuses[pos] = status
+	}
+	return statuses, rows.Err()
+}
+
+// compensateAbandoned undoes the steps of workflow jobs that failed for good
+// or were cancelled. Like the reaper it runs on every master; a job is
+// claimed with a lease so only one of them compensates it.
+func (r *Runner) compensateAbandoned(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.LeaseDuration)
+	defer ticker.Stop()
+	for {
+		for ctx.Err() == nil {
+			ok, err := r.compensateOne(ctx)
+			if err != nil {
+				log.Printf("jobs: compensating: %v", err)
+			}
+			if !ok || err != nil {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) compensateOne(ctx context.Context) (bool, error) {
+	types := make([]string, 0, len(r.workflows))
+	for t := range r.workflows {
+		types = append(types, t)
+	}
+	job := &Job{db: r.db}
+	var state []byte
+	// updated_at is held back a lease so a cancelled job's worker has noticed
+	// before its steps are undone
+	err := r.db.QueryRow(`
+		UPDATE jobs SET lease_owner = $2, lease_expires_at = NOW() + $3 * INTERVAL '1 second'
+		WHERE id = (
+			SELECT j.id FROM jobs j
+			WHERE j.type = ANY($1) AND j.status IN ('failed', 'cancelled')
+			  AND j.updated_at < NOW() - $3 * INTERVAL '1 second'
+			  AND (j.lease_expires_at IS NULL OR j.lease_expires_at < NOW())
+			  AND EXISTS (SELECT 1 FROM job_steps s WHERE s.job_id = j.id AND s.status IN ('running', 'failed', 'done'))
+			ORDER BY j.id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, type, payload, state
+	`, pq.Array(types), r.owner, r.cfg.LeaseDuration.Seconds()).Scan(&job.ID, &job.Type, &job.Payload, &state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	defer r.db.Exec(`UPDATE jobs SET lease_owner = NULL, lease_expires_at = NULL WHERE id = $1 AND lease_owner = $2`, job.ID, r.owner)
+
+	f := &Flow{Job: job, state: map[string]json.RawMessage{}}
+	if len(state) > 0 {
+		_ = json.Unmarshal(state, &f.state)
+	}
+	statuses, err := r.stepStatuses(job.ID)
+	if err != nil {
+		return true, err
+	}
+	steps := r.workflows[job.Type]
+	failed := false
+	for i := len(steps) - 1; i >= 0; i-- {
+		switch statuses[i] {
+		case "running", "failed", "done":
+		default:
+			continue
+		}
+		status, msg := "compensated", ""
+		if steps[i].Compensate != nil {
+			if err := safeStep(ctx, steps[i].Compensate, f); err != nil {
+				status, msg, failed = "compensation_failed", err.Error(), true
+				r.event(job.ID, LevelError, steps[i].Name, "undo failed: "+msg)
+			}
+		}
+		if _, err := r.db.Exec(`UPDATE job_steps SET status = $3, error = COALESCE(NULLIF($4, ''), error), finished_at = NOW() WHERE job_id = $1 AND position = $2`, job.ID, i, status, msg); err != nil {
+			return true, err
+		}
+	}
+	if failed {
+		log.Printf("jobs: %s #%d: some steps could not be undone", job.Type, job.ID)
+		return true, nil
+	}
+	// a later retry starts the workflow from the beginning
+	_, _ = r.db.Exec(`UPDATE jobs SET state = NULL WHERE id = $1`, job.ID)
+	r.event(job.ID, LevelInfo, "compensated", "undid the steps of the abandoned job")
+	log.Printf("jobs: %s #%d abandoned, steps undone", job.Type, job.ID)
+	return true, nil
+}
+
+// StepStatus is a workflow step as shown in the admin job view.
+type StepStatus struct {
+	Position   int        `json:"position"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	Error      *string    `json:"error"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// ListSteps returns a workflow job's steps in order. Plain jobs have none.
+func ListSteps(db *sql.DB, jobID int) ([]StepStatus, error) {
+	rows, err := db.Query(`
+		SELECT position, name, status, attempts, error, started_at, finished_at
+		FROM job_steps WHERE job_id = $1 ORDER BY position
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	steps := []StepStatus{}
+	for rows.Next() {
+		var s StepStatus
+		if err := rows.Scan(&s.Position, &s.Name, &s.Status, &s.Attempts, &s.Error, &s.StartedAt, &s.FinishedAt); err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+	return steps, rows.Err()
+}
@@ -8,24 +8,30 @@ import (
 )
 
 type User struct {
-	ID              int        `json:"id" db:"id"`
-	GitHubID        string     `json:"github_id" db:"github_id"`
-	Username        string     `json:"username" db:"username"`
-	Email           string     `json:"email" db:"email"`
-	DisplayName     string     `json:"display_name" db:"display_name"`
-	IsAdmin         bool       `json:"is_admin" db:"is_admin"`
-	ContainerID     *string    `json:"container_id" db:"container_id"`
-	SSHPassword     *string    `json:"-" db:"ssh_password"`
-	SSHPublicKey    *string    `json:"ssh_public_key" db:"ssh_public_key"`
-	AgreedToTOS     bool       `json:"agreed_to_tos" db:"agreed_to_tos"`
-	AgreedToPrivacy bool       `json:"agreed_to_privacy" db:"agreed_to_privacy"`
-	TOSQuestions    []int      `json:"tos_questions" db:"tos_questions"`
-	ApprovalStatus  string     `json:"approval_status" db:"approval_status"`
-	ApprovedBy      *int       `json:"approved_by" db:"approved_by"`
-	ApprovedAt      *time.Time `json:"approved_at" db:"approved_at"`
-	RejectionReason *string    `json:"rejection_reason" db:"rejection_reason"`
-	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+	ID               int        `json:"id" db:"id"`
+	GitHubID         *string    `json:"github_id" db:"github_id"`
+	Username         string     `json:"username" db:"username"`
+	Email            string     `json:"email" db:"email"`
+	DisplayName      string     `json:"display_name" db:"display_name"`
+	IsAdmin          bool       `json:"is_admin" db:"is_admin"`
+	ContainerID      *string    `json:"container_id" db:"container_id"`
+	SSHPassword      *string    `json:"-" db:"ssh_password"`
+	SSHPublicKey     *string    `json:"ssh_public_key" db:"ssh_public_key"`
+	AgreedToTOS      bool       `json:"agreed_to_tos" db:"agreed_to_tos"`
+	AgreedToPrivacy  bool       `json:"agreed_to_privacy" db:"agreed_to_privacy"`
+	TOSQuestions     []int      `json:"tos_questions" db:"tos_questions"`
+	ApprovalStatus   string     `json:"approval_status" db:"approval_status"`
+	ApprovedBy       *int       `json:"approved_by" db:"approved_by"`
+	ApprovedAt       *time.Time `json:"approved_at" db:"approved_at"`
+	RejectionReason  *string    `json:"rejection_reason" db:"rejection_reason"`
+	WakeOnConnect    bool       `json:"wake_on_connect" db:"wake_on_connect"`
+	SuspendedAt      *time.Time `json:"suspended_at" db:"suspended_at"`
+	SuspendedUntil   *time.Time `json:"suspended_until" db:"suspended_until"`
+	SuspensionReason *string    `json:"suspension_reason" db:"suspension_reason"`
+	TOTPEnabled      bool       `json:"totp_enabled" db:"-"`
+	Roles            []string   `json:"roles" db:"-"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 type Question struct {
@@ -124,6 +130,86 @@ type Job struct {
     UpdatedAt   time.Time   `json:"updated_at" db:"updated_at"`
 }
 
+type SSHSession struct {
+    ID                 int        `json:"id" db:"id"`
+    UserID             *int       `json:"user_id" db:"user_id"`
+    Username           string     `json:"username" db:"username"`
+    ContainerID        string     `json:"container_id" db:"container_id"`
+    NodeHostname       string     `json:"node_hostname" db:"node_hostname"`
+    ClientIP           string     `json:"client_ip" db:"client_ip"`
+    StartedAt          time.Time  `json:"started_at" db:"started_at"`
+    EndedAt            *time.Time `json:"ended_at" db:"ended_at"`
+    BytesIn            int64      `json:"bytes_in" db:"bytes_in"`
+    BytesOut           int64      `json:"bytes_out" db:"bytes_out"`
+    HasRecording       bool       `json:"has_recording" db:"-"`
+    RecordingExpiresAt *time.Time `json:"recording_expires_at" db:"recording_expires_at"`
+}
+
+type SSHAuthAttempt struct {
+    ID             int64     `json:"id" db:"id"`
+    UserID         *int      `json:"user_id,omitempty" db:"user_id"`
+    Username       string    `json:"username" db:"username"`
+    ClientIP       string    `json:"client_ip" db:"client_ip"`
+    Method         string    `json:"method" db:"method"`
+    KeyFingerprint *string   `json:"key_fingerprint,omitempty" db:"key_fingerprint"`
+    Success        bool      `json:"success" db:"success"`
+    Reason         *string   `json:"reason,omitempty" db:"reason"`
+    CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+type IPBan struct {
+    ID        int        `json:"id" db:"id"`
+    IP        string     `json:"ip" db:"ip"`
+    Reason    *string    `json:"reason" db:"reason"`
+    ExpiresAt *time.Time `json:"expires_at" db:"expires_at"`
+    CreatedBy *int       `json:"created_by,omitempty" db:"created_by"`
+    CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type IdlePolicy struct {
+    Plan                   string    `json:"plan" db:"plan"`
+    Enabled                bool      `json:"enabled" db:"enabled"`
+    IdleHours              int       `json:"idle_hours" db:"idle_hours"`
+    MaxCPUPercent          float64   `json:"max_cpu_percent" db:"max_cpu_percent"`
+    MaxNetworkBytesPerHour int64     `json:"max_network_bytes_per_hour" db:"max_network_bytes_per_hour"`
+    CreatedAt              time.Time `json:"created_at" db:"created_at"`
+    UpdatedAt              time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type IdleExemption struct {
+    UserID    int       `json:"user_id" db:"user_id"`
+    Username  string    `json:"username" db:"-"`
+    Reason    *string   `json:"reason" db:"reason"`
+    CreatedBy *int      `json:"created_by,omitempty" db:"created_by"`
+    CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type SSHCertificate struct {
+    Serial         int64      `json:"serial" db:"serial"`
+    UserID         int        `json:"user_id" db:"user_id"`
+    Username       string     `json:"username,omitempty" db:"-"`
+    KeyID          string     `json:"key_id" db:"key_id"`
+    KeyFingerprint string     `json:"key_fingerprint" db:"key_fingerprint"`
+    Principals     []string   `json:"principals" db:"principals"`
+    ValidAfter     time.Time  `json:"valid_after" db:"valid_after"`
+    ValidBefore    time.Time  `json:"valid_before" db:"valid_before"`
+    IssuedVia      string     `json:"issued_via" db:"issued_via"`
+    RevokedAt      *time.Time `json:"revoked_at" db:"revoked_at"`
+    CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+type PersonalAccessToken struct {
+    ID         int        `json:"id" db:"id"`
+    UserID     int        `json:"user_id" db:"user_id"`
+    Name       string     `json:"name" db:"name"`
+    Prefix     string     `json:"prefix" db:"token_prefix"`
+    Scopes     []string   `json:"scopes" db:"scopes"`
+    ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+    LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+    LastUsedIP *string    `json:"last_used_ip" db:"last_used_ip"`
+    CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
 type JSONB map[string]interface{}
 
 func (j JSONB) Value() (driver.Value, error) {
@@ -160,3 +246,69 @@ type VerificationSession struct {
     CreatedAt     time.Time `json:"created_at" db:"created_at"`
     UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
 }
+
+type Identity struct {
+    ID          int        `json:"id" db:"id"`
+    UserID      int        `json:"user_id" db:"user_id"`
+    Provider    string     `json:"provider" db:"provider"`
+    Subject     string     `json:"subject" db:"subject"`
+    Email       *string    `json:"email" db:"email"`
+    CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+    LastLoginAt *time.Time `json:"last_login_at" db:"last_login_at"`
+}
+
+type WebSession struct {
+    ID                int        `json:"id" db:"public_id"`
+    UserAgent         *string    `json:"user_agent" db:"user_agent"`
+    IPAddress         *string    `json:"ip_address" db:"ip_address"`
+    CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+    LastSeenAt        time.Time  `json:"last_seen_at" db:"last_seen_at"`
+    ExpiresAt         time.Time  `json:"expires_at" db:"expires_at"`
+    AbsoluteExpiresAt time.Time  `json:"absolute_expires_at" db:"absolute_expires_at"`
+    Current           bool       `json:"current" db:"-"`
+}
+
+type UserRole struct {
+    UserID    int        `json:"user_id" db:"user_id"`
+    Username  string     `json:"username" db:"-"`
+    Role      string     `json:"role" db:"role"`
+    GrantedBy *int       `json:"granted_by" db:"granted_by"`
+    GrantedAt time.Time  `json:"granted_at" db:"granted_at"`
+}
+
+type AuditEntry struct {
+    ID            int64      `json:"id" db:"id"`
+    ActorID       *int       `json:"actor_id" db:"actor_id"`
+    ActorUsername *string    `json:"actor_username" db:"actor_username"`
+    Action        string     `json:"action" db:"action"`
+    TargetType    string     `json:"target_type" db:"target_type"`
+    TargetID      *string    `json:"target_id" db:"target_id"`
+    RequestID     *string    `json:"request_id" db:"request_id"`
+    IPAddress     *string    `json:"ip_address" db:"ip_address"`
+    Changes       JSONB      `json:"changes" db:"changes"`
+    CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CustomDomain struct {
+    ID                int        `json:"id" db:"id"`
+    UserID            int        `json:"user_id" db:"user_id"`
+    Hostname          string     `json:"hostname" db:"hostname"`
+    TargetPort        int        `json:"target_port" db:"target_port"`
+    VerificationToken string     `json:"verification_token" db:"verification_token"`
+    Status            string     `json:"status" db:"status"`
+    VerificationError *string    `json:"verification_error" db:"verification_error"`
+    VerifiedAt        *time.Time `json:"verified_at" db:"verified_at"`
+    LastCheckedAt     *time.Time `json:"last_checked_at" db:"last_checked_at"`
+    CertStatus        string     `json:"cert_status" db:"cert_status"`
+    CertError         *string    `json:"cert_error" db:"cert_error"`
+    CertExpiresAt     *time.Time `json:"cert_expires_at" db:"cert_expires_at"`
+    CertCheckedAt     *time.Time `json:"cert_checked_at" db:"cert_checked_at"`
+    CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+}
+
+type PlanLimits struct {
+    Plan             string    `json:"plan" db:"plan"`
+    MaxCustomDomains int       `json:"max_custom_domains" db:"max_custom_domains"`
+    CreatedAt        time.Time `json:"created_at" db:"created_at"`
+    UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+}
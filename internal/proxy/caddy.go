@@ -14,14 +14,18 @@ type CaddyRoute map[string]interface{}
 
 type CaddyService struct {
 	adminURL string
+	// domain is what the *.domain catch-all route matches; managed routes go
+	// before it
+	domain string
 }
 
-func NewCaddyService(adminURL string) *CaddyService {
+func NewCaddyService(adminURL, domain string) *CaddyService {
 	if adminURL == "" {
 		adminURL = "http://localhost:2019"
 	}
 	return &CaddyService{
 		adminURL: adminURL,
+		domain:   domain,
 	}
 }
 
@@ -71,7 +75,7 @@ func (c *CaddyService) AddSubdomain(subdomain, targetHost string, targetPort int
 		}
 		finalRoutes = append(finalRoutes, route)
 	}
-	wildcardIndex := findWildcardIndex(finalRoutes)
+	wildcardIndex := findWildcardIndex(finalRoutes, c.domain)
 
 	if wildcardIndex != -1 {
 		finalRoutes = append(finalRoutes[:wildcardIndex], append([]CaddyRoute{newRoute}, finalRoutes[wildcardIndex:]...)...)
@@ -135,7 +139,15 @@ func (c *CaddyService) RebuildAllRoutes(db *sql.DB) error {
 		JOIN users u ON s.user_id = u.id
 		JOIN containers c ON u.container_id = c.id
 		JOIN nodes n ON c.node_id = n.id
-		WHERE s.is_active = true
+		WHERE s.is_active = true AND u.suspended_at IS NULL
+		UNION ALL
+		SELECT d.hostname, d.target_port, 'custom', u.username,
+		       COALESCE(n.public_hostname, n.hostname)
+		FROM custom_domains d
+		JOIN users u ON d.user_id = u.id
+		JOIN containers c ON u.container_id = c.id
+		JOIN nodes n ON c.node_id = n.id
+		WHERE d.status = 'verified' AND u.suspended_at IS NULL
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to query subdomains: %w", err)
@@ -152,10 +164,12 @@ func (c *CaddyService) RebuildAllRoutes(db *sql.DB) error {
 		}
 		
 		fullSubdomain := ""
-		if subdomainType == "username" {
-			fullSubdomain = fmt.Sprintf("%s.hack.kim", subdomain)
+		if subdomainType == "custom" {
+			fullSubdomain = subdomain
+		} else if subdomainType == "username" {
+			fullSubdomain = fmt.Sprintf("%s.%s", subdomain, c.domain)
 		} else {
-			fullSubdomain = fmt.Sprintf("%s.%s.hack.kim", subdomain, username)
+			fullSubdomain = fmt.Sprintf("%s.%s.%s", subdomain, username, c.domain)
 		}
 		
 		route := CaddyRoute{
@@ -171,7 +185,7 @@ func (c *CaddyService) RebuildAllRoutes(db *sql.DB) error {
 		managedRoutes = append(managedRoutes, route)
 	}
 
-	wildcardIndex := findWildcardIndex(baseRoutes)
+	wildcardIndex := findWildcardIndex(baseRoutes, c.domain)
 	var finalRoutes []CaddyRoute
 	if wildcardIndex != -1 {
 		finalRoutes = append(finalRoutes, baseRoutes[:wildcardIndex]...)
@@ -191,6 +205,61 @@ func (c *CaddyService) RebuildAllRoutes(db *sql.DB) error {
 	return nil
 }
 
+// EnableOnDemandTLS has Caddy get certificates for hosts it has no policy
+// for when they're first requested, as long as askURL answers 200 for the
+// host. Custom domains rely on it; the config is only reloaded if it changes.
+func (c *CaddyService) EnableOnDemandTLS(askURL string) error {
+	config, err := c.getCurrentConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get current config: %w", err)
+	}
+	apps, ok := config["apps"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("config missing 'apps' key")
+	}
+	tlsApp := childMap(apps, "tls")
+	automation := childMap(tlsApp, "automation")
+	changed := false
+	onDemand := childMap(automation, "on_demand")
+	if onDemand["ask"] != askURL {
+		onDemand["ask"] = askURL
+		changed = true
+	}
+
+	// the catch-all policy (no subjects) is the one unknown hosts fall to
+	policies, _ := automation["policies"].([]interface{})
+	var catchAll map[string]interface{}
+	for _, p := range policies {
+		if policy, ok := p.(map[string]interface{}); ok {
+			if subjects, _ := policy["subjects"].([]interface{}); len(subjects) == 0 {
+				catchAll = policy
+			}
+		}
+	}
+	if catchAll == nil {
+		catchAll = map[string]interface{}{}
+		automation["policies"] = append(policies, catchAll)
+	}
+	if catchAll["on_demand"] != true {
+		catchAll["on_demand"] = true
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return c.loadConfig(config)
+}
+
+// childMap returns m[key] as a map, adding an empty one if it isn't there.
+func childMap(m map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := m[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := map[string]interface{}{}
+	m[key] = child
+	return child
+}
+
 func (c *CaddyService) getCurrentConfig() (CaddyConfig, error) {
 	resp, err := http.Get(fmt.Sprintf("%s/config/", c.adminURL))
 	if err != nil {
@@ -258,12 +327,12 @@ func extractRoutesFromConfig(config CaddyConfig) ([]CaddyRoute, map[string]inter
 	return routes, srv0, nil
 }
 
-func findWildcardIndex(routes []CaddyRoute) int {
+func findWildcardIndex(routes []CaddyRoute, domain string) int {
 	for i, route := range routes {
 		if match, ok := route["match"].([]interface{}); ok && len(match) > 0 {
 			if matchCond, ok := match[0].(map[string]interface{}); ok {
 				if hosts, ok := matchCond["host"].([]interface{}); ok && len(hosts) > 0 {
-					if host, ok := hosts[0].(string); ok && host == "*.hack.kim" {
+					if host, ok := hosts[0].(string); ok && host == "*."+domain {
 						return i
 					}
 				}
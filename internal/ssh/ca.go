@@ -0,0 +1,190 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/den/internal/database"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultCAKeyPath = "/etc/den/ssh_ca_key"
+	// DefaultCertValidity is used when a caller doesn't ask for a specific lifetime.
+	DefaultCertValidity = 8 * time.Hour
+	// MaxCertValidity caps how long an issued certificate may live.
+	MaxCertValidity = 24 * time.Hour
+)
+
+// CertAuthority signs short-lived user certificates that the gateway accepts
+// in place of the key stored in users.ssh_public_key. Every certificate is
+// recorded in ssh_certificates so it can be listed and revoked.
+type CertAuthority struct {
+	db      *database.DB
+	keyPath string
+
+	mu     sync.Mutex
+	signer ssh.Signer
+}
+
+// IssuedCert is what callers get back after signing a key.
+type IssuedCert struct {
+	Serial      int64     `json:"serial"`
+	KeyID       string    `json:"key_id"`
+	Certificate string    `json:"certificate"`
+	Principals  []string  `json:"principals"`
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+func NewCertAuthority(db *database.DB) *CertAuthority {
+	keyPath := os.Getenv("SSH_CA_KEY_PATH")
+	if keyPath == "" {
+		keyPath = defaultCAKeyPath
+	}
+	return &CertAuthority{db: db, keyPath: keyPath}
+}
+
+// Signer loads the CA key, generating an ed25519 key on first use.
+func (ca *CertAuthority) Signer() (ssh.Signer, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if ca.signer != nil {
+		return ca.signer, nil
+	}
+	keyBytes, err := os.ReadFile(ca.keyPath)
+	if os.IsNotExist(err) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ca key: %w", err)
+		}
+		block, err := ssh.MarshalPrivateKey(priv, "den ssh ca")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ca key: %w", err)
+		}
+		keyBytes = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(ca.keyPath), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create ca key dir: %w", err)
+		}
+		if err := os.WriteFile(ca.keyPath, keyBytes, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write ca key: %w", err)
+		}
+		log.Printf("generated new ssh ca key at %s", ca.keyPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read ca key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ca key: %w", err)
+	}
+	ca.signer = signer
+	return signer, nil
+}
+
+// PublicKey returns the CA public key in authorized_keys format.
+func (ca *CertAuthority) PublicKey() (string, error) {
+	signer, err := ca.Signer()
+	if err != nil {
+		return "", err
+	}
+	return string(ssh.MarshalAuthorizedKey(signer.PublicKey())), nil
+}
+
+// Issue signs pub for the given user with their username as the only principal.
+func (ca *CertAuthority) Issue(userID int, username string, pub ssh.PublicKey, validity time.Duration, issuedVia string) (*IssuedCert, error) {
+	if _, isCert := pub.(*ssh.Certificate); isCert {
+		return nil, fmt.Errorf("cannot sign a certificate, pass the plain public key")
+	}
+	if validity <= 0 {
+		validity = DefaultCertValidity
+	}
+	if validity > MaxCertValidity {
+		validity = MaxCertValidity
+	}
+	signer, err := ca.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	var serialBytes [8]byte
+	if _, err := rand.Read(serialBytes[:]); err != nil {
+		return nil, err
+	}
+	// keep serials positive so they fit in a BIGINT
+	serial := int64(binary.BigEndian.Uint64(serialBytes[:]) >> 1)
+	now := time.Now()
+	// allow a little clock skew between whoever requested the cert and the gateway
+	validAfter := now.Add(-5 * time.Minute)
+	validBefore := now.Add(validity)
+	keyID := fmt.Sprintf("den:%s:%d", username, serial)
+
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          uint64(serial),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: []string{username},
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":              "",
+				"permit-port-forwarding":  "",
+				"permit-agent-forwarding": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	_, err = ca.db.Exec(`
+		INSERT INTO ssh_certificates (serial, user_id, key_id, key_fingerprint, principals, valid_after, valid_before, issued_via)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, serial, userID, keyID, ssh.FingerprintSHA256(pub), pq.Array(cert.ValidPrincipals), validAfter, validBefore, issuedVia)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record certificate: %w", err)
+	}
+
+	return &IssuedCert{
+		Serial:      serial,
+		KeyID:       keyID,
+		Certificate: string(ssh.MarshalAuthorizedKey(cert)),
+		Principals:  cert.ValidPrincipals,
+		ValidAfter:  validAfter,
+		ValidBefore: validBefore,
+	}, nil
+}
+
+// isRevoked fails closed: a certificate we have no record of, or whose
+// record we can't read, is treated as revoked.
+func (ca *CertAuthority) isRevoked(cert *ssh.Certificate) bool {
+	var revoked bool
+	err := ca.db.QueryRow(`SELECT revoked_at IS NOT NULL FROM ssh_certificates WHERE serial = $1`, int64(cert.Serial)).Scan(&revoked)
+	if err != nil {
+		return true
+	}
+	return revoked
+}
+
+func (ca *CertAuthority) checker() *ssh.CertChecker {
+	return &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			signer, err := ca.Signer()
+			if err != nil {
+				return false
+			}
+			return string(auth.Marshal()) == string(signer.PublicKey().Marshal())
+		},
+		IsRevoked: ca.isRevoked,
+	}
+}
@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/den/internal/config"
+)
+
+// GatewayConfig holds the tunables of the SSH gateway. Everything can be set
+// through the environment; see GatewayConfigFromEnv for the variable names.
+type GatewayConfig struct {
+	ListenAddr string
+	// HostKeyPaths are loaded in order, so the first key type is the one the
+	// gateway prefers. Missing files are generated, with the key type taken
+	// from the file name (rsa or ed25519).
+	HostKeyPaths []string
+	// IdleTimeout closes connections that have not sent or received anything
+	// for this long. Zero disables it.
+	IdleTimeout time.Duration
+	// MaxSessionsPerUser caps concurrent connections per user. Zero means no limit.
+	MaxSessionsPerUser int
+	// DashboardURL is used in the messages shown to users.
+	DashboardURL string
+
+	RecordSessions     bool
+	RecordingRetention time.Duration
+}
+
+func GatewayConfigFromEnv() GatewayConfig {
+	cfg := GatewayConfig{
+		ListenAddr:         ":22",
+		HostKeyPaths:       []string{"/etc/ssh/ssh_host_ed25519_key", "/etc/ssh/ssh_host_rsa_key"},
+		MaxSessionsPerUser: 10,
+		DashboardURL:       config.FromEnv().DashboardURL,
+		RecordSessions:     os.Getenv("SSH_RECORD_SESSIONS") == "true",
+		RecordingRetention: 30 * 24 * time.Hour,
+	}
+	if v := strings.TrimSpace(os.Getenv("SSH_LISTEN_ADDR")); v != "" {
+		if _, _, err := net.SplitHostPort(v); err == nil {
+			cfg.ListenAddr = v
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SSH_HOST_KEYS")); v != "" {
+		var paths []string
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) > 0 {
+			cfg.HostKeyPaths = paths
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("SSH_IDLE_TIMEOUT")); err == nil && d >= 0 {
+		cfg.IdleTimeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("SSH_MAX_SESSIONS_PER_USER")); err == nil && n >= 0 {
+		cfg.MaxSessionsPerUser = n
+	}
+	if days, err := strconv.Atoi(os.Getenv("SSH_RECORDING_RETENTION_DAYS")); err == nil && days > 0 {
+		cfg.RecordingRetention = time.Duration(days) * 24 * time.Hour
+	}
+	return cfg
+}
+
+// idleConn pushes the connection deadline forward on every read and write,
+// so a connection with no traffic for the timeout is closed.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
@@ -9,41 +9,97 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/den/internal/database"
+	"github.com/den/internal/storage"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh"
 )
 
 type Gateway struct {
 	db       *database.DB
-	hostKey  ssh.Signer
+	cfg      GatewayConfig
+	hostKeys []ssh.Signer
 	listener net.Listener
+	pool     *NodePool
+	throttle *authThrottle
+	ca       *CertAuthority
+
+	liveMu sync.Mutex
+	live   map[string]*liveConn
+
+	// ssh_sessions rows this gateway holds open and the connection each belongs
+	// to, kept fresh by heartbeatSessionRecords
+	recordsMu sync.Mutex
+	records   map[int]*liveConn
+
+	recordSessions     bool
+	recordingRetention time.Duration
+	recordingStore     *storage.R2Client
 }
 
 func NewGateway(db *database.DB) *Gateway {
+	return NewGatewayWithConfig(db, GatewayConfigFromEnv())
+}
+
+func NewGatewayWithConfig(db *database.DB, cfg GatewayConfig) *Gateway {
 	return &Gateway{
-		db: db,
+		db:                 db,
+		cfg:                cfg,
+		pool:               NewNodePool(),
+		throttle:           newAuthThrottle(),
+		ca:                 NewCertAuthority(db),
+		live:               make(map[string]*liveConn),
+		records:            make(map[int]*liveConn),
+		recordSessions:     cfg.RecordSessions,
+		recordingRetention: cfg.RecordingRetention,
 	}
 }
 
 func (g *Gateway) Start() error {
-	if err := g.loadHostKey(); err != nil {
+	if err := g.loadHostKeys(); err != nil {
 		return fmt.Errorf("failed to load host key: %w", err)
 	}
-		config := &ssh.ServerConfig{
+	if g.recordSessions {
+		store, err := storage.NewR2ClientFromEnv()
+		if err != nil {
+			log.Printf("warning: ssh session recording disabled, storage not configured: %v", err)
+			g.recordSessions = false
+		} else {
+			g.recordingStore = store
+		}
+	}
+	go g.throttle.run()
+	go g.heartbeatSessionRecords()
+	config := &ssh.ServerConfig{
 		PublicKeyCallback: g.authenticateUser,
 		PasswordCallback:  g.authenticatePassword,
+		BannerCallback: func(conn ssh.ConnMetadata) string {
+			data := MessageData{Username: conn.User()}
+			banner := g.renderMessage(MessageBanner, data)
+			if g.recordSessions {
+				banner += g.renderMessage(MessageRecording, data)
+			}
+			return banner
+		},
+	}
+	for _, key := range g.hostKeys {
+		config.AddHostKey(key)
 	}
-	config.AddHostKey(g.hostKey)
 
-	listener, err := net.Listen("tcp", ":22")
+	listener, err := net.Listen("tcp", g.cfg.ListenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on SSH port: %w", err)
 	}
 	g.listener = listener
 
-	log.Println("ssh Gateway listening on :22")
+	log.Printf("ssh Gateway listening on %s", g.cfg.ListenAddr)
 
 	for {
 		conn, err := listener.Accept()
@@ -51,48 +107,84 @@ func (g *Gateway) Start() error {
 			log.Printf("failed to accept ssh connection: %v", err)
 			continue
 		}
+		if g.cfg.IdleTimeout > 0 {
+			conn = &idleConn{Conn: conn, timeout: g.cfg.IdleTimeout}
+		}
 
 		go g.handleConnection(conn, config)
 	}
 }
 
+// CertAuthority returns the CA whose user certificates the gateway accepts.
+func (g *Gateway) CertAuthority() *CertAuthority {
+	return g.ca
+}
+
 func (g *Gateway) Stop() error {
+	g.pool.Close()
 	if g.listener != nil {
 		return g.listener.Close()
 	}
 	return nil
 }
 
-func (g *Gateway) loadHostKey() error {
-	keyPath := "/etc/ssh/ssh_host_rsa_key"
+func (g *Gateway) loadHostKeys() error {
+	g.hostKeys = nil
+	for _, keyPath := range g.cfg.HostKeyPaths {
+		key, err := loadOrGenerateHostKey(keyPath)
+		if err != nil {
+			log.Printf("skipping host key %s: %v", keyPath, err)
+			continue
+		}
+		g.hostKeys = append(g.hostKeys, key)
+	}
+	if len(g.hostKeys) == 0 {
+		return fmt.Errorf("no usable host keys")
+	}
+	return nil
+}
+
+func loadOrGenerateHostKey(keyPath string) (ssh.Signer, error) {
 	keyBytes, err := os.ReadFile(keyPath)
 	if err == nil {
 		key, err := ssh.ParsePrivateKey(keyBytes)
 		if err == nil {
-			g.hostKey = key
-			return nil
+			return key, nil
 		}
 	}
-	cmd := exec.Command("ssh-keygen", "-t", "rsa", "-b", "2048", "-f", keyPath, "-N", "")
+	args := []string{"-t", "ed25519"}
+	if strings.Contains(filepath.Base(keyPath), "rsa") {
+		args = []string{"-t", "rsa", "-b", "3072"}
+	}
+	cmd := exec.Command("ssh-keygen", append(args, "-f", keyPath, "-N", "")...)
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate host key: %w", err)
+		return nil, fmt.Errorf("failed to generate host key: %w", err)
 	}
 	keyBytes, err = os.ReadFile(keyPath)
 	if err != nil {
-		return fmt.Errorf("failed to read generated key: %w", err)
+		return nil, fmt.Errorf("failed to read generated key: %w", err)
 	}
 
 	key, err := ssh.ParsePrivateKey(keyBytes)
 	if err != nil {
-		return fmt.Errorf("failed to parse generated key: %w", err)
+		return nil, fmt.Errorf("failed to parse generated key: %w", err)
 	}
-
-	g.hostKey = key
-	return nil
+	return key, nil
 }
 
 func (g *Gateway) authenticateUser(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 	username := conn.User()
+	attempt := authAttempt{
+		username:    username,
+		clientIP:    remoteIP(conn.RemoteAddr()),
+		method:      "publickey",
+		fingerprint: ssh.FingerprintSHA256(key),
+	}
+	if g.throttle.isUserLocked(username) {
+		attempt.reason = "username locked"
+		g.authFailed(attempt, false)
+		return nil, fmt.Errorf("too many failed attempts")
+	}
 	var userID int
 	var containerID sql.NullString
 	var nodeHostname sql.NullString
@@ -110,8 +202,32 @@ func (g *Gateway) authenticateUser(conn ssh.ConnMetadata, key ssh.PublicKey) (*s
 
 	if err != nil {
 		log.Printf("User %s not found: %v", username, err)
+		attempt.reason = "unknown user"
+		g.authFailed(attempt, false)
 		return nil, fmt.Errorf("user not found")
 	}
+	attempt.userID = userID
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		attempt.method = "certificate"
+		attempt.fingerprint = ssh.FingerprintSHA256(cert.Key)
+		if _, err := g.ca.checker().Authenticate(conn, key); err != nil {
+			attempt.reason = "certificate rejected: " + err.Error()
+			g.authFailed(attempt, false)
+			return nil, fmt.Errorf("certificate rejected")
+		}
+		return &ssh.Permissions{
+			Extensions: map[string]string{
+				"user_id":          fmt.Sprintf("%d", userID),
+				"username":         username,
+				"container_id":     containerID.String,
+				"node_hostname":    nodeHostname.String,
+				"container_status": containerStatus.String,
+				"auth_method":      "certificate",
+				"key_fingerprint":  attempt.fingerprint,
+			},
+		}, nil
+	}
 
 	if (!storedKey.Valid || storedKey.String == "") && (!hasPassword.Valid || hasPassword.String == "") {
 		log.Printf("no SSH setup for user %s", username)
@@ -123,20 +239,28 @@ func (g *Gateway) authenticateUser(conn ssh.ConnMetadata, key ssh.PublicKey) (*s
 				"node_hostname":    nodeHostname.String,
 				"container_status": containerStatus.String,
 				"no_ssh_setup":     "true",
+				"auth_method":      "publickey",
+				"key_fingerprint":  attempt.fingerprint,
 			},
 		}
 		return permissions, nil
 	}
 
 	if !storedKey.Valid {
+		attempt.reason = "no public key configured"
+		g.authFailed(attempt, false)
 		return nil, fmt.Errorf("no public key configured")
 	}
 	storedPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(storedKey.String))
 	if err != nil {
 		log.Printf("Failed to parse stored key for %s: %v", username, err)
+		attempt.reason = "invalid stored key"
+		g.authFailed(attempt, false)
 		return nil, fmt.Errorf("invalid stored key")
 	}
 	if !bytes.Equal(key.Marshal(), storedPublicKey.Marshal()) {
+		attempt.reason = "key mismatch"
+		g.authFailed(attempt, false)
 		return nil, fmt.Errorf("key mismatch")
 	}
 	permissions := &ssh.Permissions{
@@ -146,6 +270,8 @@ func (g *Gateway) authenticateUser(conn ssh.ConnMetadata, key ssh.PublicKey) (*s
 			"container_id":     containerID.String,
 			"node_hostname":    nodeHostname.String,
 			"container_status": containerStatus.String,
+			"auth_method":      "publickey",
+			"key_fingerprint":  attempt.fingerprint,
 		},
 	}
 
@@ -155,7 +281,13 @@ func (g *Gateway) authenticateUser(conn ssh.ConnMetadata, key ssh.PublicKey) (*s
 func (g *Gateway) authenticatePassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 	username := conn.User()
 	log.Printf("ssh password auth attempt for user: %s", username)
-	
+	attempt := authAttempt{username: username, clientIP: remoteIP(conn.RemoteAddr()), method: "password"}
+	if g.throttle.isUserLocked(username) {
+		attempt.reason = "username locked"
+		g.authFailed(attempt, false)
+		return nil, fmt.Errorf("too many failed attempts")
+	}
+
 	var userID int
 	var containerID sql.NullString
 	var nodeHostname sql.NullString
@@ -172,14 +304,19 @@ func (g *Gateway) authenticatePassword(conn ssh.ConnMetadata, password []byte) (
 
 	if err != nil {
 		log.Printf("database lookup failed for user %s: %v", username, err)
+		attempt.reason = "unknown user"
+		g.authFailed(attempt, true)
 		return nil, fmt.Errorf("user not found")
 	}
+	attempt.userID = userID
 
 	log.Printf("user %s found - ID: %d, container: %s, Node: %s, hasPassword: %v", 
 		username, userID, containerID.String, nodeHostname.String, hashedPassword.Valid)
 
 	if !hashedPassword.Valid {
 		log.Printf("no password configured for user %s", username)
+		attempt.reason = "no password configured"
+		g.authFailed(attempt, false)
 		return nil, fmt.Errorf("no password configured")
 	}
 
@@ -187,6 +324,8 @@ func (g *Gateway) authenticatePassword(conn ssh.ConnMetadata, password []byte) (
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword.String), password)
 	if err != nil {
 		log.Printf("password mismatch for user %s", username)
+		attempt.reason = "password mismatch"
+		g.authFailed(attempt, true)
 		return nil, fmt.Errorf("password mismatch")
 	}
 	
@@ -198,6 +337,7 @@ func (g *Gateway) authenticatePassword(conn ssh.ConnMetadata, password []byte) (
 			"container_id":     containerID.String,
 			"node_hostname":    nodeHostname.String,
 			"container_status": containerStatus.String,
+			"auth_method":      "password",
 		},
 	}
 
@@ -206,6 +346,10 @@ func (g *Gateway) authenticatePassword(conn ssh.ConnMetadata, password []byte) (
 
 func (g *Gateway) handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 	defer conn.Close()
+	if ip := remoteIP(conn.RemoteAddr()); g.isIPBanned(ip) {
+		log.Printf("rejecting ssh connection from banned ip %s", ip)
+		return
+	}
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
 	if err != nil {
 		log.Printf("Failed to handshake: %v", err)
@@ -217,6 +361,16 @@ func (g *Gateway) handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 		log.Println("No permissions found")
 		return
 	}
+	userID, _ := strconv.Atoi(permissions.Extensions["user_id"])
+	clientIP := remoteIP(sshConn.RemoteAddr())
+	g.recordAuthAttempt(authAttempt{
+		userID:      userID,
+		username:    sshConn.User(),
+		clientIP:    clientIP,
+		method:      permissions.Extensions["auth_method"],
+		fingerprint: permissions.Extensions["key_fingerprint"],
+		success:     true,
+	})
 
 	containerID := permissions.Extensions["container_id"]
 	nodeHostname := permissions.Extensions["node_hostname"]
@@ -224,72 +378,65 @@ func (g *Gateway) handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 	containerStatus := permissions.Extensions["container_status"]
 	noSSHSetup := permissions.Extensions["no_ssh_setup"]
 
+	msgData := MessageData{
+		Username:        username,
+		ContainerID:     containerID,
+		ContainerStatus: containerStatus,
+		NodeHostname:    nodeHostname,
+		MaxSessions:     g.cfg.MaxSessionsPerUser,
+	}
+
 	if noSSHSetup == "true" {
-		g.handleNoSSHSetup(sshConn, chans, reqs, username)
+		g.replyAndClose(chans, reqs, g.renderMessage(MessageNoSSHSetup, msgData))
 		return
 	}
 
-	if containerID == "" {
-		g.handleNoContainer(sshConn, chans, reqs, username)
+	if g.suspension(userID, &msgData) {
+		log.Printf("rejecting ssh connection for suspended user %s", username)
+		g.replyAndClose(chans, reqs, g.renderMessage(MessageSuspended, msgData))
 		return
 	}
 
-	if containerStatus != "RUNNING" {
-		g.handleOfflineContainer(sshConn, chans, reqs, username, containerStatus)
+	if containerID == "" {
+		g.replyAndClose(chans, reqs, g.renderMessage(MessageNoContainer, msgData))
 		return
 	}
 
-	if nodeHostname == "" {
-		log.Printf("No node hostname for container %s", containerID)
+	if max := g.cfg.MaxSessionsPerUser; max > 0 && g.liveSessionCount(userID) >= max {
+		log.Printf("rejecting ssh connection for %s: %d sessions already open", username, max)
+		g.replyAndClose(chans, reqs, g.renderMessage(MessageTooManySessions, msgData))
 		return
 	}
-	g.routeToNode(sshConn, chans, reqs, nodeHostname, containerID, username)
-}
-
-func (g *Gateway) handleNoContainer(sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, username string) {
-	go ssh.DiscardRequests(reqs)
-
-	for newChannel := range chans {
-		if newChannel.ChannelType() != "session" {
-			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
-			continue
-		}
 
-		channel, _, err := newChannel.Accept()
-		if err != nil {
-			log.Printf("could not accept channel: %v", err)
-			continue
-		}
-		message := fmt.Sprintf("hey there %s!\n\nyou don't have an account yet.\nplease visit https://hack.kim/user/dashboard to create one.\n\n", username)
-		channel.Write([]byte(message))
-		channel.Close()
+	meta := sessionMeta{
+		userID:       userID,
+		username:     username,
+		containerID:  containerID,
+		nodeHostname: nodeHostname,
+		clientIP:     clientIP,
 	}
-}
-
-func (g *Gateway) handleOfflineContainer(sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, username, status string) {
-	go ssh.DiscardRequests(reqs)
+	meta.live = g.registerLive(sshConn, meta)
+	defer g.unregisterLive(meta.live)
 
-	for newChannel := range chans {
-		if newChannel.ChannelType() != "session" {
-			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
-			continue
+	if containerStatus != "RUNNING" {
+		if nodeHostname != "" && g.wakeOnConnectEnabled(userID) {
+			g.wakeAndRoute(sshConn, chans, reqs, meta)
+			return
 		}
+		g.replyAndClose(chans, reqs, g.renderMessage(MessageOffline, msgData))
+		return
+	}
 
-		channel, _, err := newChannel.Accept()
-		if err != nil {
-			log.Printf("could not accept channel: %v", err)
-			continue
-		}
-		message := fmt.Sprintf("\r\nhey you! yeah you!\r\n\r\ndid you know that your environment is offline?\r\nto get it back up and running again, just head to the dashboard at https://hack.kim!\r\n\r\n(current status: %s)\r\n\r\n", status)
-		_, err = channel.Write([]byte(message))
-		if err != nil {
-			log.Printf("failed to write offline message: %v", err)
-		}
-		channel.Close()
+	if nodeHostname == "" {
+		log.Printf("No node hostname for container %s", containerID)
+		return
 	}
+	g.routeToNode(sshConn, chans, reqs, meta)
 }
 
-func (g *Gateway) handleNoSSHSetup(sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, username string) {
+// replyAndClose answers every session channel with message and closes it,
+// for connections that can't be routed to a container.
+func (g *Gateway) replyAndClose(chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, message string) {
 	go ssh.DiscardRequests(reqs)
 
 	for newChannel := range chans {
@@ -303,51 +450,38 @@ func (g *Gateway) handleNoSSHSetup(sshConn *ssh.ServerConn, chans <-chan ssh.New
 			log.Printf("could not accept channel: %v", err)
 			continue
 		}
-		message := fmt.Sprintf("\r\noops! looks like you have no way of getting into this environment yet...\r\n\r\nnot to worry! you can easily fix this by going to https://hack.kim/user/ssh-setup\r\n\r\nhope that helps!\r\n\r\n")
-		_, err = channel.Write([]byte(message))
-		if err != nil {
-			log.Printf("failed to write no SSH setup message: %v", err)
+		if _, err := channel.Write([]byte(message)); err != nil {
+			log.Printf("failed to write message: %v", err)
 		}
 		channel.Close()
 	}
 }
 
-func (g *Gateway) routeToNode(sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, nodeHostname, containerID, username string) {
-	log.Printf("Routing SSH connection for user %s to container %s on node %s", username, containerID, nodeHostname)
-	masterKeyBytes, err := os.ReadFile("/root/.ssh/den_master_key")
-	if err != nil {
-		log.Printf("failed to read master key: %v", err)
-		return
-	}
-	
-	masterKey, err := ssh.ParsePrivateKey(masterKeyBytes)
-	if err != nil {
-		log.Printf("failed to parse master key: %v", err)
-		return
-	}
-	nodeConn, err := ssh.Dial("tcp", nodeHostname+":22", &ssh.ClientConfig{
-		User:            "root",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(masterKey)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	})
-	if err != nil {
-		log.Printf("failed to connect to node %s: %v", nodeHostname, err)
+func (g *Gateway) routeToNode(sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, meta sessionMeta) {
+	log.Printf("Routing SSH connection for user %s to container %s on node %s", meta.username, meta.containerID, meta.nodeHostname)
+	if _, err := g.pool.Get(meta.nodeHostname); err != nil {
+		log.Printf("%v", err)
 		return
 	}
-	defer nodeConn.Close()
-	go func() {
-		for req := range reqs {
-			if req.Type == "keepalive@openssh.com" {
-				if req.WantReply {
-					req.Reply(true, nil)
-				}
-				continue
-			}
+	go replyToGlobalRequests(reqs)
+	g.serveSessionChannels(chans, meta)
+}
+
+func replyToGlobalRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.Type == "keepalive@openssh.com" {
 			if req.WantReply {
 				req.Reply(true, nil)
 			}
+			continue
 		}
-	}()
+		if req.WantReply {
+			req.Reply(true, nil)
+		}
+	}
+}
+
+func (g *Gateway) serveSessionChannels(chans <-chan ssh.NewChannel, meta sessionMeta) {
 	for newChannel := range chans {
 		if newChannel.ChannelType() != "session" {
 			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
@@ -360,29 +494,58 @@ func (g *Gateway) routeToNode(sshConn *ssh.ServerConn, chans <-chan ssh.NewChann
 			continue
 		}
 
-		go g.handleLXCSession(nodeConn, channel, channelReqs, containerID, username)
+		go g.handleLXCSession(channel, channelReqs, meta)
 	}
 }
 
-func (g *Gateway) handleLXCSession(nodeConn *ssh.Client, channel ssh.Channel, reqs <-chan *ssh.Request, containerID, username string) {
+func (g *Gateway) handleLXCSession(channel ssh.Channel, reqs <-chan *ssh.Request, meta sessionMeta) {
 	defer channel.Close()
 	
-	session, err := nodeConn.NewSession()
+	session, release, err := g.pool.NewSession(meta.nodeHostname)
 	if err != nil {
 		log.Printf("failed to create session on node: %v", err)
 		return
 	}
+	defer release()
 	defer session.Close()
-	session.Stdout = channel
-	session.Stderr = channel
-	session.Stdin = channel
+	// tear the node side down as soon as the user's connection goes away,
+	// e.g. when an admin kills it
+	connGone := make(chan struct{})
+	go func() {
+		meta.live.conn.Wait()
+		close(connGone)
+		session.Close()
+	}()
+
+	var bytesIn, bytesOut int64
+	cols, rows, term := 80, 24, "xterm-256color"
+	// the ssh_sessions row is only opened once a shell starts; recordMu
+	// guards it and the recorder, which are set from the request loop
+	var recordMu sync.Mutex
+	var sessionID int
+	var recorder *sessionRecorder
+	defer func() {
+		recordMu.Lock()
+		id, rec := sessionID, recorder
+		recordMu.Unlock()
+		if id != 0 || rec != nil {
+			g.closeSessionRecord(id, meta, atomic.LoadInt64(&bytesIn), atomic.LoadInt64(&bytesOut), rec)
+		}
+	}()
 
 	var shellStarted bool
-	sessionDone := make(chan bool)
+	// closed when the shell exits, or when the channel's requests end
+	// without one ever starting (exec, subsystems, early disconnects)
+	sessionDone := make(chan struct{})
 
 
 	// go routines wooooo!!!
 	go func() {
+		defer func() {
+			if !shellStarted {
+				close(sessionDone)
+			}
+		}()
 		for req := range reqs {
 			switch req.Type {
 			case "pty-req":
@@ -397,10 +560,11 @@ func (g *Gateway) handleLXCSession(nodeConn *ssh.Client, channel ssh.Channel, re
 				}
 				var msg ptyReqMsg
 				ssh.Unmarshal(req.Payload, &msg)
-				cols := int(msg.Columns)
-				rows := int(msg.Rows)
+				cols = int(msg.Columns)
+				rows = int(msg.Rows)
 				if cols <= 0 { cols = 80 }
 				if rows <= 0 { rows = 24 }
+				if msg.Term != "" { term = msg.Term }
 				err := session.RequestPty("xterm-256color", cols, rows, ssh.TerminalModes{
 					ssh.ECHO:          1,
 					ssh.TTY_OP_ISPEED: 14400,
@@ -412,7 +576,33 @@ func (g *Gateway) handleLXCSession(nodeConn *ssh.Client, channel ssh.Channel, re
 			case "shell":
 				if !shellStarted {
 					shellStarted = true
-					cmd := fmt.Sprintf("lxc exec %s -- bash -c 'cat /etc/motd 2>/dev/null || true; sudo -u %s -i'", containerID, username)
+					var out io.Writer = countingWriter{w: channel, ns: []*int64{&bytesOut, &meta.live.bytesOut}}
+					id := g.openSessionRecord(meta)
+					recordMu.Lock()
+					sessionID = id
+					if g.recordSessions {
+						rec, err := newSessionRecorder(cols, rows, term)
+						if err != nil {
+							log.Printf("failed to start session recording for %s: %v", meta.username, err)
+						} else {
+							recorder = rec
+							out = io.MultiWriter(out, rec)
+						}
+					}
+					recordMu.Unlock()
+					session.Stdout = out
+					session.Stderr = out
+					session.Stdin = countingReader{r: channel, ns: []*int64{&bytesIn, &meta.live.bytesIn}}
+					if motd := g.renderMessage(MessageMOTD, MessageData{
+						Username:        meta.username,
+						ContainerID:     meta.containerID,
+						ContainerStatus: "RUNNING",
+						NodeHostname:    meta.nodeHostname,
+						MaxSessions:     g.cfg.MaxSessionsPerUser,
+					}); motd != "" {
+						out.Write([]byte(motd))
+					}
+					cmd := fmt.Sprintf("lxc exec %s -- bash -c 'cat /etc/motd 2>/dev/null || true; sudo -u %s -i'", meta.containerID, meta.username)
 					log.Printf("starting container shell: %s", cmd)
 					
 					go func() {
@@ -422,7 +612,7 @@ func (g *Gateway) handleLXCSession(nodeConn *ssh.Client, channel ssh.Channel, re
 						}
 						// yeet that shit outta here
 						log.Printf("container shell exited, closing ssh connection")
-						sessionDone <- true
+						close(sessionDone)
 					}()
 					
 					if req.WantReply {
@@ -445,6 +635,11 @@ func (g *Gateway) handleLXCSession(nodeConn *ssh.Client, channel ssh.Channel, re
 				if err := session.WindowChange(int(wc.Rows), int(wc.Columns)); err != nil {
 					log.Printf("failed to apply window-change: %v", err)
 				}
+				recordMu.Lock()
+				if recorder != nil {
+					recorder.Resize(int(wc.Columns), int(wc.Rows))
+				}
+				recordMu.Unlock()
 				if req.WantReply { req.Reply(true, nil) }
 			default:
 				if req.WantReply {
@@ -455,7 +650,10 @@ func (g *Gateway) handleLXCSession(nodeConn *ssh.Client, channel ssh.Channel, re
 	}()
 
 	// wait :thumbsup:
-	<-sessionDone
+	select {
+	case <-sessionDone:
+	case <-connGone:
+	}
 }
 
 func (g *Gateway) forwardRequests(from <-chan *ssh.Request, to ssh.Channel) {
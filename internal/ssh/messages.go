@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Messages the gateway shows to users. Admins can override any of them in
+// gateway_messages; the defaults below are used otherwise.
+const (
+	MessageBanner          = "banner"
+	MessageMOTD            = "motd"
+	MessageNoContainer     = "no_container"
+	MessageOffline         = "offline"
+	MessageNoSSHSetup      = "no_ssh_setup"
+	MessageTooManySessions = "too_many_sessions"
+	MessageSuspended       = "suspended"
+	MessageRecording       = "recording"
+)
+
+var defaultMessages = map[string]string{
+	// shown before authentication, so only .Username and .DashboardURL are set
+	MessageBanner: "",
+	// shown after the banner when session recording is on; same data as the banner
+	MessageRecording: "\nheads up: sessions on this server are recorded.\n\n",
+	// shown when a shell starts, before the container's own /etc/motd
+	MessageMOTD: "",
+	MessageNoContainer: "hey there {{.Username}}!\n\nyou don't have an account yet.\n" +
+		"please visit {{.DashboardURL}}/user/dashboard to create one.\n\n",
+	MessageOffline: "\nhey you! yeah you!\n\ndid you know that your environment is offline?\n" +
+		"to get it back up and running again, just head to the dashboard at {{.DashboardURL}}!\n\n" +
+		"(current status: {{.ContainerStatus}})\n\n",
+	MessageNoSSHSetup: "\noops! looks like you have no way of getting into this environment yet...\n\n" +
+		"not to worry! you can easily fix this by going to {{.DashboardURL}}/user/ssh-setup\n\nhope that helps!\n\n",
+	MessageTooManySessions: "\nwhoa there {{.Username}}, you already have {{.MaxSessions}} open sessions.\n" +
+		"close one of them and try again.\n\n",
+	MessageSuspended: "\nyour den account ({{.Username}}) has been suspended.\n\n" +
+		"{{if .SuspensionReason}}reason: {{.SuspensionReason}}\n{{end}}" +
+		"{{if .SuspendedUntil}}the suspension ends {{.SuspendedUntil}}.\n{{else}}the suspension has no end date.\n{{end}}" +
+		"\nif you think this is a mistake, get in touch with the admins.\n\n",
+}
+
+// MessageData is what message templates are rendered with.
+type MessageData struct {
+	Username        string
+	ContainerID     string
+	ContainerStatus string
+	NodeHostname    string
+	DashboardURL    string
+	MaxSessions     int
+	// only set for the suspended message
+	SuspensionReason string
+	SuspendedUntil   string
+}
+
+// GatewayMessageNames lists every message that can be customised.
+func GatewayMessageNames() []string {
+	names := make([]string, 0, len(defaultMessages))
+	for name := range defaultMessages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultGatewayMessage returns the built-in template for name.
+func DefaultGatewayMessage(name string) (string, bool) {
+	body, ok := defaultMessages[name]
+	return body, ok
+}
+
+// ValidateGatewayMessage checks that body parses and renders against sample data.
+func ValidateGatewayMessage(body string) error {
+	tmpl, err := template.New("message").Parse(body)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(&bytes.Buffer{}, MessageData{
+		Username:         "user",
+		ContainerID:      "den-user",
+		ContainerStatus:  "STOPPED",
+		NodeHostname:     "node",
+		DashboardURL:     "https://example.com",
+		MaxSessions:      1,
+		SuspensionReason: "abuse",
+		SuspendedUntil:   "Mon, 02 Jan 2006 15:04:05 UTC",
+	})
+}
+
+// renderMessage renders the admin override for name, falling back to the
+// default if there is none or it fails to render. Line endings are
+// normalised to CRLF since the output goes straight to a terminal.
+func (g *Gateway) renderMessage(name string, data MessageData) string {
+	data.DashboardURL = g.cfg.DashboardURL
+	var body string
+	err := g.db.QueryRow(`SELECT body FROM gateway_messages WHERE name = $1`, name).Scan(&body)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("failed to load gateway message %s: %v", name, err)
+		}
+		body = defaultMessages[name]
+	}
+	out, err := executeMessage(body, data)
+	if err != nil {
+		log.Printf("gateway message %s failed to render, using default: %v", name, err)
+		out, _ = executeMessage(defaultMessages[name], data)
+	}
+	out = strings.ReplaceAll(out, "\r\n", "\n")
+	return strings.ReplaceAll(out, "\n", "\r\n")
+}
+
+func executeMessage(body string, data MessageData) (string, error) {
+	tmpl, err := template.New("message").Parse(body)
+	if err != nil {
+		return "", fmt.Errorf("parse: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,21 @@
+package ssh
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	poolConnections = prometheus.NewGauge(prometheus.GaugeOpts{Name: "den_ssh_pool_connections", Help: "Open pooled SSH connections to nodes"})
+	poolDialTotal   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "den_ssh_pool_dial_total", Help: "SSH dials from the gateway to nodes"}, []string{"result"})
+	poolReuseTotal  = prometheus.NewCounter(prometheus.CounterOpts{Name: "den_ssh_pool_reuse_total", Help: "User connections served by an existing node connection"})
+	poolDropTotal   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "den_ssh_pool_drop_total", Help: "Pooled node connections dropped"}, []string{"reason"})
+	nodeDialSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "den_ssh_node_dial_seconds", Help: "Time to establish an SSH connection from the gateway to a node", Buckets: prometheus.DefBuckets}, []string{"node"})
+	poolSessions    = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "den_ssh_pool_sessions", Help: "Sessions open on the pooled SSH connection to each node"}, []string{"node"})
+
+	activeSessions    = prometheus.NewGauge(prometheus.GaugeOpts{Name: "den_ssh_active_sessions", Help: "Authenticated SSH connections currently open on the gateway"})
+	authFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "den_ssh_auth_failures_total", Help: "Failed SSH authentication attempts"}, []string{"method"})
+	killedTotal       = prometheus.NewCounter(prometheus.CounterOpts{Name: "den_ssh_sessions_killed_total", Help: "SSH connections force-closed by an admin"})
+)
+
+func init() {
+	prometheus.MustRegister(poolConnections, poolDialTotal, poolReuseTotal, poolDropTotal, nodeDialSeconds, poolSessions,
+		activeSessions, authFailuresTotal, killedTotal)
+}
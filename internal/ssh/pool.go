@@ -0,0 +1,218 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	masterKeyPath         = "/root/.ssh/den_master_key"
+	nodeKeepaliveInterval = 30 * time.Second
+	nodeDialTimeout       = 10 * time.Second
+)
+
+// NodePool keeps a single authenticated SSH client per node and multiplexes
+// user sessions over it. Dead clients are detected by keepalives (or by a
+// NewSession that fails at the transport level) and redialled on the next Get.
+type NodePool struct {
+	mu      sync.Mutex
+	signer  ssh.Signer
+	clients map[string]*nodeClient
+}
+
+type nodeClient struct {
+	mu       sync.Mutex
+	hostname string
+	client   *ssh.Client
+	sessions int // open sessions on client
+	done     chan struct{}
+}
+
+func NewNodePool() *NodePool {
+	return &NodePool{
+		clients: make(map[string]*nodeClient),
+	}
+}
+
+func (p *NodePool) loadSigner() (ssh.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.signer != nil {
+		return p.signer, nil
+	}
+	keyBytes, err := os.ReadFile(masterKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse master key: %w", err)
+	}
+	p.signer = signer
+	return signer, nil
+}
+
+func (p *NodePool) entry(hostname string) *nodeClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nc, ok := p.clients[hostname]
+	if !ok {
+		nc = &nodeClient{hostname: hostname}
+		p.clients[hostname] = nc
+	}
+	return nc
+}
+
+// Get returns the pooled client for a node, dialling it if there isn't a live one.
+func (p *NodePool) Get(hostname string) (*ssh.Client, error) {
+	nc := p.entry(hostname)
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.client != nil {
+		poolReuseTotal.Inc()
+		return nc.client, nil
+	}
+
+	signer, err := p.loadSigner()
+	if err != nil {
+		return nil, err
+	}
+	dialStart := time.Now()
+	client, err := ssh.Dial("tcp", hostname+":22", &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         nodeDialTimeout,
+	})
+	if err != nil {
+		poolDialTotal.WithLabelValues("fail").Inc()
+		return nil, fmt.Errorf("failed to connect to node %s: %w", hostname, err)
+	}
+	poolDialTotal.WithLabelValues("success").Inc()
+	nodeDialSeconds.WithLabelValues(hostname).Observe(time.Since(dialStart).Seconds())
+	poolConnections.Inc()
+
+	nc.client = client
+	nc.done = make(chan struct{})
+	go p.keepalive(nc, client, nc.done)
+	go func(done chan struct{}) {
+		client.Wait()
+		p.drop(nc, client, "closed")
+	}(nc.done)
+	return client, nil
+}
+
+// NewSession opens a session on the node's pooled client. If the pooled
+// client turns out to be dead it is dropped and redialled once. A node that
+// refuses the channel (e.g. sshd's MaxSessions) only fails this session; the
+// client and everyone else's sessions on it are left alone.
+func (p *NodePool) NewSession(hostname string) (*ssh.Session, func(), error) {
+	nc := p.entry(hostname)
+	for attempt := 0; attempt < 2; attempt++ {
+		client, err := p.Get(hostname)
+		if err != nil {
+			return nil, nil, err
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			var rejected *ssh.OpenChannelError
+			if errors.As(err, &rejected) {
+				return nil, nil, fmt.Errorf("node %s refused session: %w", hostname, err)
+			}
+			log.Printf("pooled connection to %s unusable, redialling: %v", hostname, err)
+			p.drop(nc, client, "session_failed")
+			continue
+		}
+		nc.mu.Lock()
+		if nc.client == client {
+			nc.sessions++
+			poolSessions.WithLabelValues(hostname).Set(float64(nc.sessions))
+		}
+		nc.mu.Unlock()
+		release := func() {
+			nc.mu.Lock()
+			// sessions on a client that has since been dropped were
+			// already cleared with it
+			if nc.client == client && nc.sessions > 0 {
+				nc.sessions--
+				poolSessions.WithLabelValues(hostname).Set(float64(nc.sessions))
+			}
+			nc.mu.Unlock()
+		}
+		return session, release, nil
+	}
+	return nil, nil, fmt.Errorf("failed to open session on node %s", hostname)
+}
+
+func (p *NodePool) keepalive(nc *nodeClient, client *ssh.Client, done chan struct{}) {
+	ticker := time.NewTicker(nodeKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			result := make(chan error, 1)
+			go func() {
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				result <- err
+			}()
+			select {
+			case err := <-result:
+				if err != nil {
+					log.Printf("keepalive to node %s failed: %v", nc.hostname, err)
+					p.drop(nc, client, "keepalive")
+					return
+				}
+			case <-time.After(nodeDialTimeout):
+				log.Printf("keepalive to node %s timed out", nc.hostname)
+				p.drop(nc, client, "keepalive")
+				return
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// drop closes client and clears it from the pool, unless it has already
+// been replaced by a newer connection.
+func (p *NodePool) drop(nc *nodeClient, client *ssh.Client, reason string) {
+	nc.mu.Lock()
+	if nc.client != client {
+		nc.mu.Unlock()
+		return
+	}
+	nc.client = nil
+	nc.sessions = 0
+	poolSessions.WithLabelValues(nc.hostname).Set(0)
+	close(nc.done)
+	nc.mu.Unlock()
+
+	client.Close()
+	poolConnections.Dec()
+	poolDropTotal.WithLabelValues(reason).Inc()
+}
+
+func (p *NodePool) Close() {
+	p.mu.Lock()
+	entries := make([]*nodeClient, 0, len(p.clients))
+	for _, nc := range p.clients {
+		entries = append(entries, nc)
+	}
+	p.mu.Unlock()
+	for _, nc := range entries {
+		nc.mu.Lock()
+		client := nc.client
+		nc.mu.Unlock()
+		if client != nil {
+			p.drop(nc, client, "shutdown")
+		}
+	}
+}
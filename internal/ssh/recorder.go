@@ -0,0 +1,139 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/den/internal/storage"
+)
+
+// sessionRecorder writes terminal output as an asciicast v2 stream
+// (https://docs.asciinema.org/manual/asciicast/v2/) into a temp file which
+// is uploaded to object storage once the session ends.
+type sessionRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	pending []byte
+	closed  bool
+}
+
+func newSessionRecorder(width, height int, term string) (*sessionRecorder, error) {
+	f, err := os.CreateTemp("", "den-session-*.cast")
+	if err != nil {
+		return nil, err
+	}
+	r := &sessionRecorder{file: f, w: bufio.NewWriter(f), start: time.Now()}
+	header := map[string]interface{}{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": r.start.Unix(),
+		"env":       map[string]string{"TERM": term, "SHELL": "/bin/bash"},
+	}
+	b, _ := json.Marshal(header)
+	r.w.Write(b)
+	r.w.WriteByte('\n')
+	return r, nil
+}
+
+// Write records an output event. Incomplete UTF-8 sequences at the end of p
+// are held back until the next write so events always carry valid text.
+func (r *sessionRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return len(p), nil
+	}
+	data := append(r.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.event("o", string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+func (r *sessionRecorder) Resize(cols, rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *sessionRecorder) event(kind, data string) {
+	b, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), kind, data})
+	r.w.Write(b)
+	r.w.WriteByte('\n')
+}
+
+// Upload flushes the recording, stores it under objectKey and removes the temp file.
+func (r *sessionRecorder) Upload(ctx context.Context, store *storage.R2Client, objectKey string) error {
+	r.mu.Lock()
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	r.closed = true
+	err := r.w.Flush()
+	r.mu.Unlock()
+	defer os.Remove(r.file.Name())
+	defer r.file.Close()
+	if err != nil {
+		return err
+	}
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return store.PutObject(ctx, objectKey, r.file, info.Size(), "application/x-asciicast")
+}
+
+// countingReader and countingWriter add the bytes they pass through to every counter in ns.
+type countingReader struct {
+	r  io.Reader
+	ns []*int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for _, counter := range c.ns {
+		atomic.AddInt64(counter, int64(n))
+	}
+	return n, err
+}
+
+type countingWriter struct {
+	w  io.Writer
+	ns []*int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	for _, counter := range c.ns {
+		atomic.AddInt64(counter, int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,227 @@
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/ssh"
+)
+
+// sessionHeartbeatInterval is how often open ssh_sessions rows get their
+// last_seen_at refreshed. Rows that stop being refreshed belong to a gateway
+// that went away without closing them, and the idle check ends them.
+const sessionHeartbeatInterval = time.Minute
+
+type sessionMeta struct {
+	userID       int
+	username     string
+	containerID  string
+	nodeHostname string
+	clientIP     string
+	live         *liveConn
+}
+
+// liveConn is an authenticated connection currently held open by the
+// gateway. Byte counters are shared by all of its shell sessions.
+type liveConn struct {
+	id        string
+	meta      sessionMeta
+	conn      *ssh.ServerConn
+	startedAt time.Time
+	bytesIn   int64
+	bytesOut  int64
+}
+
+// LiveSession is a snapshot of a liveConn for the admin API.
+type LiveSession struct {
+	ID           string    `json:"id"`
+	UserID       int       `json:"user_id"`
+	Username     string    `json:"username"`
+	ClientIP     string    `json:"client_ip"`
+	NodeHostname string    `json:"node_hostname"`
+	ContainerID  string    `json:"container_id"`
+	StartedAt    time.Time `json:"started_at"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+}
+
+func (g *Gateway) registerLive(conn *ssh.ServerConn, meta sessionMeta) *liveConn {
+	b := make([]byte, 8)
+	rand.Read(b)
+	lc := &liveConn{id: hex.EncodeToString(b), meta: meta, conn: conn, startedAt: time.Now()}
+	g.liveMu.Lock()
+	g.live[lc.id] = lc
+	g.liveMu.Unlock()
+	activeSessions.Inc()
+	return lc
+}
+
+func (g *Gateway) unregisterLive(lc *liveConn) {
+	g.liveMu.Lock()
+	delete(g.live, lc.id)
+	g.liveMu.Unlock()
+	activeSessions.Dec()
+}
+
+// LiveSessions lists open connections, oldest first.
+func (g *Gateway) LiveSessions() []LiveSession {
+	g.liveMu.Lock()
+	out := make([]LiveSession, 0, len(g.live))
+	for _, lc := range g.live {
+		out = append(out, LiveSession{
+			ID:           lc.id,
+			UserID:       lc.meta.userID,
+			Username:     lc.meta.username,
+			ClientIP:     lc.meta.clientIP,
+			NodeHostname: lc.meta.nodeHostname,
+			ContainerID:  lc.meta.containerID,
+			StartedAt:    lc.startedAt,
+			BytesIn:      atomic.LoadInt64(&lc.bytesIn),
+			BytesOut:     atomic.LoadInt64(&lc.bytesOut),
+		})
+	}
+	g.liveMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+func (g *Gateway) liveSessionCount(userID int) int {
+	g.liveMu.Lock()
+	defer g.liveMu.Unlock()
+	n := 0
+	for _, lc := range g.live {
+		if lc.meta.userID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// KillSession force-closes a live connection. It reports whether one was found.
+func (g *Gateway) KillSession(id string) bool {
+	g.liveMu.Lock()
+	lc, ok := g.live[id]
+	g.liveMu.Unlock()
+	if !ok {
+		return false
+	}
+	log.Printf("ssh: killing session %s for %s", id, lc.meta.username)
+	killedTotal.Inc()
+	lc.conn.Close()
+	return true
+}
+
+// KillUserSessions force-closes every live connection for a user and returns how many were closed.
+func (g *Gateway) KillUserSessions(userID int) int {
+	g.liveMu.Lock()
+	var targets []*liveConn
+	for _, lc := range g.live {
+		if lc.meta.userID == userID {
+			targets = append(targets, lc)
+		}
+	}
+	g.liveMu.Unlock()
+	for _, lc := range targets {
+		log.Printf("ssh: killing session %s for %s", lc.id, lc.meta.username)
+		killedTotal.Inc()
+		lc.conn.Close()
+	}
+	return len(targets)
+}
+
+// openSessionRecord indexes a new shell session in ssh_sessions and returns its id (0 on failure).
+func (g *Gateway) openSessionRecord(meta sessionMeta) int {
+	var userID sql.NullInt64
+	if meta.userID > 0 {
+		userID = sql.NullInt64{Int64: int64(meta.userID), Valid: true}
+	}
+	var id int
+	err := g.db.QueryRow(`
+		INSERT INTO ssh_sessions (user_id, username, container_id, node_hostname, client_ip)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, userID, meta.username, meta.containerID, meta.nodeHostname, meta.clientIP).Scan(&id)
+	if err != nil {
+		log.Printf("failed to record ssh session for %s: %v", meta.username, err)
+		return 0
+	}
+	g.recordsMu.Lock()
+	g.records[id] = meta.live
+	g.recordsMu.Unlock()
+	return id
+}
+
+func (g *Gateway) closeSessionRecord(id int, meta sessionMeta, bytesIn, bytesOut int64, recorder *sessionRecorder) {
+	g.recordsMu.Lock()
+	delete(g.records, id)
+	g.recordsMu.Unlock()
+	var recordingKey *string
+	var recordingExpires *time.Time
+	if recorder != nil {
+		key := fmt.Sprintf("recordings/%s/%d-%d.cast", meta.containerID, id, time.Now().Unix())
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		if err := recorder.Upload(ctx, g.recordingStore, key); err != nil {
+			log.Printf("failed to upload session recording for %s: %v", meta.username, err)
+		} else {
+			expires := time.Now().Add(g.recordingRetention)
+			recordingKey = &key
+			recordingExpires = &expires
+		}
+		cancel()
+	}
+	if id == 0 {
+		return
+	}
+	_, err := g.db.Exec(`
+		UPDATE ssh_sessions
+		SET ended_at = NOW(), bytes_in = $2, bytes_out = $3, recording_key = $4, recording_expires_at = $5
+		WHERE id = $1
+	`, id, bytesIn, bytesOut, recordingKey, recordingExpires)
+	if err != nil {
+		log.Printf("failed to close ssh session record %d: %v", id, err)
+	}
+}
+
+// heartbeatSessionRecords marks the gateway's open sessions as still open, so
+// the idle check can tell a long quiet session from one a dead gateway left
+// behind. Only rows whose connection is still live are refreshed; anything
+// else is dropped so a leaked row goes stale instead of pinning its container.
+func (g *Gateway) heartbeatSessionRecords() {
+	ticker := time.NewTicker(sessionHeartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids := g.liveSessionRecords()
+		if len(ids) == 0 {
+			continue
+		}
+		if _, err := g.db.Exec(`UPDATE ssh_sessions SET last_seen_at = NOW() WHERE id = ANY($1) AND ended_at IS NULL`, pq.Array(ids)); err != nil {
+			log.Printf("failed to refresh open ssh sessions: %v", err)
+		}
+	}
+}
+
+// liveSessionRecords returns the ids of open ssh_sessions rows that belong to
+// a connection still in g.live, forgetting the rest.
+func (g *Gateway) liveSessionRecords() []int64 {
+	g.recordsMu.Lock()
+	defer g.recordsMu.Unlock()
+	g.liveMu.Lock()
+	defer g.liveMu.Unlock()
+	ids := make([]int64, 0, len(g.records))
+	for id, lc := range g.records {
+		if lc == nil || g.live[lc.id] != lc {
+			delete(g.records, id)
+			continue
+		}
+		ids = append(ids, int64(id))
+	}
+	return ids
+}
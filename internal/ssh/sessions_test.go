@@ -0,0 +1,31 @@
+package ssh
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestLiveSessionRecords(t *testing.T) {
+	live := &liveConn{id: "live"}
+	gone := &liveConn{id: "gone"}
+	g := &Gateway{
+		live:    map[string]*liveConn{live.id: live},
+		records: map[int]*liveConn{1: live, 2: gone, 3: nil, 4: live},
+	}
+
+	ids := g.liveSessionRecords()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 4 {
+		t.Fatalf("ids = %v, want [1 4]", ids)
+	}
+	// rows without a live connection are forgotten, so they go stale
+	if len(g.records) != 2 || g.records[2] != nil || g.records[3] != nil {
+		t.Fatalf("records = %v, want only 1 and 4", g.records)
+	}
+
+	// a connection that went away takes its rows with it
+	delete(g.live, live.id)
+	if ids := g.liveSessionRecords(); len(ids) != 0 {
+		t.Fatalf("ids after disconnect = %v, want none", ids)
+	}
+}
@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// suspension reports whether the user is currently suspended, filling in
+// the reason and end date for the suspended message.
+func (g *Gateway) suspension(userID int, data *MessageData) bool {
+	var reason sql.NullString
+	var until sql.NullTime
+	err := g.db.QueryRow(`
+		SELECT suspension_reason, suspended_until FROM users WHERE id = $1 AND suspended_at IS NOT NULL
+	`, userID).Scan(&reason, &until)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		log.Printf("failed to check suspension for user %d: %v", userID, err)
+		return false
+	}
+	data.SuspensionReason = reason.String
+	if until.Valid {
+		data.SuspendedUntil = until.Time.UTC().Format(time.RFC1123)
+	}
+	return true
+}
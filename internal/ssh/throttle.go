@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	throttleWindow   = 10 * time.Minute
+	maxIPFailures    = 10
+	maxUserFailures  = 20
+	ipBanDuration    = 30 * time.Minute
+	userLockDuration = 15 * time.Minute
+)
+
+// authThrottle counts recent auth failures per client IP and per username.
+// Crossing the IP limit produces a temporary row in ip_bans (so admins can
+// see and lift it); crossing the username limit locks the account in memory.
+type authThrottle struct {
+	mu           sync.Mutex
+	ipFailures   map[string][]time.Time
+	userFailures map[string][]time.Time
+	userLocked   map[string]time.Time
+}
+
+func newAuthThrottle() *authThrottle {
+	return &authThrottle{
+		ipFailures:   make(map[string][]time.Time),
+		userFailures: make(map[string][]time.Time),
+		userLocked:   make(map[string]time.Time),
+	}
+}
+
+func pruneWindow(ts []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-throttleWindow)
+	i := 0
+	for i < len(ts) && ts[i].Before(cutoff) {
+		i++
+	}
+	return ts[i:]
+}
+
+// recordFailure registers a failure and reports whether the IP should now be banned.
+func (t *authThrottle) recordFailure(ip, username string) (banIP bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	ipTs := append(pruneWindow(t.ipFailures[ip], now), now)
+	t.ipFailures[ip] = ipTs
+	userTs := append(pruneWindow(t.userFailures[username], now), now)
+	t.userFailures[username] = userTs
+	if len(userTs) >= maxUserFailures {
+		t.userLocked[username] = now.Add(userLockDuration)
+		delete(t.userFailures, username)
+		log.Printf("ssh: locking username %s for %s after %d failures", username, userLockDuration, len(userTs))
+	}
+	if len(ipTs) >= maxIPFailures {
+		delete(t.ipFailures, ip)
+		return true
+	}
+	return false
+}
+
+func (t *authThrottle) isUserLocked(username string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.userLocked[username]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(t.userLocked, username)
+		return false
+	}
+	return true
+}
+
+func (t *authThrottle) sweep() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for k, v := range t.ipFailures {
+		if v = pruneWindow(v, now); len(v) == 0 {
+			delete(t.ipFailures, k)
+		} else {
+			t.ipFailures[k] = v
+		}
+	}
+	for k, v := range t.userFailures {
+		if v = pruneWindow(v, now); len(v) == 0 {
+			delete(t.userFailures, k)
+		} else {
+			t.userFailures[k] = v
+		}
+	}
+	for k, until := range t.userLocked {
+		if now.After(until) {
+			delete(t.userLocked, k)
+		}
+	}
+}
+
+func (t *authThrottle) run() {
+	ticker := time.NewTicker(throttleWindow)
+	defer ticker.Stop()
+	for range ticker.C {
+		t.sweep()
+	}
+}
+
+type authAttempt struct {
+	userID      int
+	username    string
+	clientIP    string
+	method      string
+	fingerprint string
+	success     bool
+	reason      string
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (g *Gateway) recordAuthAttempt(a authAttempt) {
+	var userID sql.NullInt64
+	if a.userID > 0 {
+		userID = sql.NullInt64{Int64: int64(a.userID), Valid: true}
+	}
+	var fingerprint, reason sql.NullString
+	if a.fingerprint != "" {
+		fingerprint = sql.NullString{String: a.fingerprint, Valid: true}
+	}
+	if a.reason != "" {
+		reason = sql.NullString{String: a.reason, Valid: true}
+	}
+	_, err := g.db.Exec(`
+		INSERT INTO ssh_auth_attempts (user_id, username, client_ip, method, key_fingerprint, success, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, userID, a.username, a.clientIP, a.method, fingerprint, a.success, reason)
+	if err != nil {
+		log.Printf("failed to record ssh auth attempt for %s: %v", a.username, err)
+	}
+}
+
+// authFailed records a failed attempt. Only password failures count towards
+// throttling: clients offer every key in their agent before falling back to
+// a password, so rejected public keys are normal and not a sign of guessing.
+func (g *Gateway) authFailed(a authAttempt, countsTowardsLimit bool) {
+	a.success = false
+	authFailuresTotal.WithLabelValues(a.method).Inc()
+	g.recordAuthAttempt(a)
+	if !countsTowardsLimit {
+		return
+	}
+	if g.throttle.recordFailure(a.clientIP, a.username) {
+		log.Printf("ssh: banning %s for %s after repeated auth failures", a.clientIP, ipBanDuration)
+		_, err := g.db.Exec(`INSERT INTO ip_bans (ip, reason, expires_at) VALUES ($1, $2, $3)`,
+			a.clientIP, "too many failed ssh logins", time.Now().Add(ipBanDuration))
+		if err != nil {
+			log.Printf("failed to ban %s: %v", a.clientIP, err)
+		}
+	}
+}
+
+func (g *Gateway) isIPBanned(ip string) bool {
+	var banned bool
+	err := g.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM ip_bans WHERE ip = $1 AND (expires_at IS NULL OR expires_at > NOW()))
+	`, ip).Scan(&banned)
+	if err != nil {
+		log.Printf("failed to check ip ban for %s: %v", ip, err)
+		return false
+	}
+	return banned
+}
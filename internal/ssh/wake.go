@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	wakeTimeout      = 60 * time.Second
+	wakePollInterval = 2 * time.Second
+)
+
+var wakeHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+func (g *Gateway) wakeOnConnectEnabled(userID int) bool {
+	var enabled bool
+	if err := g.db.QueryRow(`SELECT wake_on_connect FROM users WHERE id = $1`, userID).Scan(&enabled); err != nil {
+		return false
+	}
+	return enabled
+}
+
+// wakeAndRoute starts a stopped container on behalf of the connecting user.
+// The first session channel is accepted straight away so progress can be
+// shown; once the container is up it is handed to the normal session path
+// along with any further channels.
+func (g *Gateway) wakeAndRoute(sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, meta sessionMeta) {
+	go replyToGlobalRequests(reqs)
+
+	var channel ssh.Channel
+	var channelReqs <-chan *ssh.Request
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		var err error
+		channel, channelReqs, err = newChannel.Accept()
+		if err != nil {
+			log.Printf("failed to accept channel: %v", err)
+			return
+		}
+		break
+	}
+	if channel == nil {
+		return
+	}
+
+	log.Printf("waking container %s for user %s", meta.containerID, meta.username)
+	channel.Write([]byte("\r\nyour environment is asleep, waking it up for you"))
+	if err := g.wakeContainer(meta, func() { channel.Write([]byte(".")) }); err != nil {
+		log.Printf("failed to wake container %s: %v", meta.containerID, err)
+		channel.Write([]byte(fmt.Sprintf("\r\n\r\ncouldn't start your environment: %v\r\ntry starting it from the dashboard instead.\r\n\r\n", err)))
+		channel.Close()
+		return
+	}
+	channel.Write([]byte(" ready!\r\n"))
+
+	if _, err := g.pool.Get(meta.nodeHostname); err != nil {
+		log.Printf("%v", err)
+		channel.Write([]byte("\r\ncouldn't reach the node your environment lives on, please try again in a bit.\r\n\r\n"))
+		channel.Close()
+		return
+	}
+	go g.handleLXCSession(channel, channelReqs, meta)
+	g.serveSessionChannels(chans, meta)
+}
+
+// wakeContainer asks the slave to start the container and polls its status
+// until it reports RUNNING or wakeTimeout passes. tick is called on every poll.
+func (g *Gateway) wakeContainer(meta sessionMeta, tick func()) error {
+	startErr := startContainerOnNode(meta.nodeHostname, meta.containerID)
+	deadline := time.Now().Add(wakeTimeout)
+	for {
+		status, err := containerStatusOnNode(meta.nodeHostname, meta.containerID)
+		if err == nil && strings.EqualFold(status, "RUNNING") {
+			break
+		}
+		// a failed start is only fatal if the container didn't come up anyway
+		// (e.g. another connection already started it)
+		if startErr != nil {
+			return startErr
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s", wakeTimeout)
+		}
+		tick()
+		time.Sleep(wakePollInterval)
+	}
+	if _, err := g.db.Exec(`UPDATE containers SET status = 'RUNNING', idle_stopped_at = NULL, updated_at = NOW() WHERE id = $1`, meta.containerID); err != nil {
+		log.Printf("failed to update status for container %s: %v", meta.containerID, err)
+	}
+	return nil
+}
+
+func startContainerOnNode(nodeHostname, containerID string) error {
+	url := fmt.Sprintf("http://%s:8081/api/control/containers/%s", nodeHostname, containerID)
+	body, _ := json.Marshal(map[string]string{"action": "start"})
+	resp, err := wakeHTTPClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("node unreachable")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("node refused to start it (status %d)", resp.StatusCode)
+	}
+	return nil
+}
+
+func containerStatusOnNode(nodeHostname, containerID string) (string, error) {
+	resp, err := wakeHTTPClient.Get(fmt.Sprintf("http://%s:8081/api/containers/%s", nodeHostname, containerID))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status request failed: %d", resp.StatusCode)
+	}
+	var out struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.Status, nil
+}
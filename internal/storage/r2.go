@@ -3,6 +3,7 @@ package storage
 import (
     "context"
     "fmt"
+    "io"
     "net/url"
     "os"
     "time"
@@ -51,6 +52,11 @@ func (s *R2Client) PresignedGet(ctx context.Context, objectKey string, expires t
     return u.String(), nil
 }
 
+func (s *R2Client) PutObject(ctx context.Context, objectKey string, r io.Reader, size int64, contentType string) error {
+    _, err := s.client.PutObject(ctx, s.bucket, objectKey, r, size, minio.PutObjectOptions{ContentType: contentType})
+    return err
+}
+
 func (s *R2Client) DeleteObject(ctx context.Context, objectKey string) error {
     return s.client.RemoveObject(ctx, s.bucket, objectKey, minio.RemoveObjectOptions{})
 }
@@ -11,7 +11,9 @@ import (
 )
 
 func main() {
-	var mode = flag.String("mode", "", "mode to run: master or slave")
+	var mode = flag.String("mode", "", "mode to run: master, slave or migrate-wildcard-dns")
+	var dryRun = flag.Bool("dry-run", false, "migrate-wildcard-dns: only report what would change")
+	var resolver = flag.String("resolver", "", "migrate-wildcard-dns: DNS server to check resolution against")
 	flag.Parse()
 
 	switch *mode {
@@ -23,11 +25,16 @@ func main() {
 		if err := slave.Run(); err != nil {
 			log.Fatalf("slave failed: %v", err)
 		}
+	case "migrate-wildcard-dns":
+		if err := master.MigrateWildcardDNS(*dryRun, *resolver); err != nil {
+			log.Fatalf("dns migration failed: %v", err)
+		}
 	default:
 		fmt.Println("den")
 		fmt.Println("usage:")
 		fmt.Println("  den -mode=master")
 		fmt.Println("  den -mode=slave")
+		fmt.Println("  den -mode=migrate-wildcard-dns [-dry-run] [-resolver=host:port]")
 		os.Exit(1)
 	}
 }
\ No newline at end of file
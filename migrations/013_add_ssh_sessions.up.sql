@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS ssh_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    username TEXT NOT NULL,
+    container_id TEXT NOT NULL,
+    node_hostname TEXT NOT NULL,
+    client_ip TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMPTZ,
+    bytes_in BIGINT NOT NULL DEFAULT 0,
+    bytes_out BIGINT NOT NULL DEFAULT 0,
+    recording_key TEXT,
+    recording_expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_user_id ON ssh_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_container_id ON ssh_sessions(container_id);
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_started_at ON ssh_sessions(started_at);
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_recording_expires_at ON ssh_sessions(recording_expires_at);
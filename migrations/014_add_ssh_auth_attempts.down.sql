@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ip_bans;
+DROP TABLE IF EXISTS ssh_auth_attempts;
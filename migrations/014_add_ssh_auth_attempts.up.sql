@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS ssh_auth_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    username TEXT NOT NULL,
+    client_ip TEXT NOT NULL,
+    method TEXT NOT NULL,
+    key_fingerprint TEXT,
+    success BOOLEAN NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_auth_attempts_user_id ON ssh_auth_attempts(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_ssh_auth_attempts_client_ip ON ssh_auth_attempts(client_ip, created_at);
+
+CREATE TABLE IF NOT EXISTS ip_bans (
+    id SERIAL PRIMARY KEY,
+    ip TEXT NOT NULL,
+    reason TEXT,
+    expires_at TIMESTAMPTZ,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip_bans_ip ON ip_bans(ip);
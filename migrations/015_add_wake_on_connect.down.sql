@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN IF EXISTS wake_on_connect;
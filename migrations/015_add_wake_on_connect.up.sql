@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS wake_on_connect BOOLEAN NOT NULL DEFAULT FALSE;
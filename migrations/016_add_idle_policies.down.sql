@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS container_stats_samples;
+DROP TABLE IF EXISTS idle_exemptions;
+DROP TABLE IF EXISTS idle_policies;
+ALTER TABLE containers DROP COLUMN IF EXISTS idle_stopped_at;
+ALTER TABLE users DROP COLUMN IF EXISTS plan;
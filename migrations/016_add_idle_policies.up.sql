@@ -0,0 +1,34 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(50) NOT NULL DEFAULT 'default';
+ALTER TABLE containers ADD COLUMN IF NOT EXISTS idle_stopped_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS idle_policies (
+    plan VARCHAR(50) PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    idle_hours INTEGER NOT NULL DEFAULT 72 CHECK (idle_hours > 0),
+    max_cpu_percent DOUBLE PRECISION NOT NULL DEFAULT 2.0,
+    max_network_bytes_per_hour BIGINT NOT NULL DEFAULT 10485760,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO idle_policies (plan) VALUES ('default') ON CONFLICT (plan) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS idle_exemptions (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS container_stats_samples (
+    id BIGSERIAL PRIMARY KEY,
+    container_id VARCHAR(255) NOT NULL REFERENCES containers(id) ON DELETE CASCADE,
+    cpu_usage_ns BIGINT NOT NULL,
+    network_rx_bytes BIGINT NOT NULL,
+    network_tx_bytes BIGINT NOT NULL,
+    memory_usage_bytes BIGINT NOT NULL,
+    sampled_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_stats_samples_container ON container_stats_samples(container_id, sampled_at);
+CREATE INDEX IF NOT EXISTS idx_container_stats_samples_sampled_at ON container_stats_samples(sampled_at);
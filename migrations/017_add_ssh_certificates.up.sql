@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS ssh_certificates (
+    serial BIGINT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_id TEXT NOT NULL,
+    key_fingerprint TEXT NOT NULL,
+    principals TEXT[] NOT NULL,
+    valid_after TIMESTAMP WITH TIME ZONE NOT NULL,
+    valid_before TIMESTAMP WITH TIME ZONE NOT NULL,
+    issued_via VARCHAR(20) NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_certificates_user_id ON ssh_certificates(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_ssh_certificates_valid_before ON ssh_certificates(valid_before);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS gateway_messages (
+    name VARCHAR(50) PRIMARY KEY,
+    body TEXT NOT NULL,
+    updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
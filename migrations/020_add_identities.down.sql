@@ -0,0 +1,6 @@
+UPDATE users u SET github_id = 'identity:' || u.id
+WHERE u.github_id IS NULL;
+
+ALTER TABLE users ALTER COLUMN github_id SET NOT NULL;
+
+DROP TABLE IF EXISTS identities;
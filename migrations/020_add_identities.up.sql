@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_identities_user_id ON identities(user_id);
+
+INSERT INTO identities (user_id, provider, subject, email)
+SELECT id, 'github', github_id, NULLIF(email, '') FROM users
+WHERE github_id IS NOT NULL AND github_id <> ''
+ON CONFLICT (provider, subject) DO NOTHING;
+
+-- users created through other providers have no github id
+ALTER TABLE users ALTER COLUMN github_id DROP NOT NULL;
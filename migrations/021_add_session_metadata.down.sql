@@ -0,0 +1,5 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS absolute_expires_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_seen_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE sessions DROP COLUMN IF EXISTS public_id;
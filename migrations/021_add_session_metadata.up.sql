@@ -0,0 +1,10 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS public_id SERIAL UNIQUE;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE sessions SET last_seen_at = created_at, absolute_expires_at = created_at + INTERVAL '30 days'
+WHERE absolute_expires_at IS NULL;
+
+ALTER TABLE sessions ALTER COLUMN absolute_expires_at SET NOT NULL;
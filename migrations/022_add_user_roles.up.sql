@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(32) NOT NULL CHECK (role IN ('reviewer', 'operator', 'superadmin')),
+    granted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    granted_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, role)
+);
+
+-- existing admins keep full access
+INSERT INTO user_roles (user_id, role)
+SELECT id, 'superadmin' FROM users WHERE is_admin = TRUE
+ON CONFLICT DO NOTHING;
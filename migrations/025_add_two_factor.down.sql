@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS settings;
+ALTER TABLE sessions DROP COLUMN IF EXISTS reauthenticated_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS mfa_failures;
+ALTER TABLE sessions DROP COLUMN IF EXISTS mfa_pending;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
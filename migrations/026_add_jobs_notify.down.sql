@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_jobs_queued_type;
+DROP TRIGGER IF EXISTS jobs_notify_queued ON jobs;
+DROP FUNCTION IF EXISTS notify_job_queued();
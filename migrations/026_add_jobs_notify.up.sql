@@ -0,0 +1,14 @@
+-- wake the job runner as soon as work is queued instead of having it poll
+CREATE OR REPLACE FUNCTION notify_job_queued()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('den_jobs', NEW.type);
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER jobs_notify_queued AFTER INSERT OR UPDATE OF status ON jobs
+    FOR EACH ROW WHEN (NEW.status = 'queued') EXECUTE FUNCTION notify_job_queued();
+
+-- each worker pool only claims its own type
+CREATE INDEX IF NOT EXISTS idx_jobs_queued_type ON jobs(type, run_after) WHERE status = 'queued';
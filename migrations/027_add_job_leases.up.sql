@@ -0,0 +1,6 @@
+-- a running job belongs to the worker holding its lease; the lease is renewed
+-- while the handler runs and expired leases are requeued
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_running_lease ON jobs(lease_expires_at) WHERE status = 'running';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_jobs_failed;
+UPDATE jobs SET status = 'failed' WHERE status = 'cancelled';
+ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_status_check;
+ALTER TABLE jobs ADD CONSTRAINT jobs_status_check CHECK (status IN ('queued','running','success','failed'));
@@ -0,0 +1,5 @@
+ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_status_check;
+ALTER TABLE jobs ADD CONSTRAINT jobs_status_check CHECK (status IN ('queued','running','success','failed','cancelled'));
+
+-- the dead-letter view lists permanently failed jobs, newest first
+CREATE INDEX IF NOT EXISTS idx_jobs_failed ON jobs(type, updated_at DESC) WHERE status = 'failed';
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS jobs_notify_status ON jobs;
+DROP TRIGGER IF EXISTS job_events_notify ON job_events;
+DROP FUNCTION IF EXISTS notify_job_event();
+DROP TABLE IF EXISTS job_events;
+DROP INDEX IF EXISTS idx_jobs_user;
+ALTER TABLE jobs DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,34 @@
+-- who a job is for, so users can follow their own jobs
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+UPDATE jobs j SET user_id = (j.payload->>'user_id')::int
+WHERE j.user_id IS NULL AND j.payload ? 'user_id'
+  AND EXISTS (SELECT 1 FROM users u WHERE u.id = (j.payload->>'user_id')::int);
+CREATE INDEX IF NOT EXISTS idx_jobs_user ON jobs(user_id, id DESC);
+
+CREATE TABLE IF NOT EXISTS job_events (
+    id BIGSERIAL PRIMARY KEY,
+    job_id INTEGER NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    level TEXT NOT NULL DEFAULT 'info' CHECK (level IN ('info','warn','error')),
+    step TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_job_events_job ON job_events(job_id, id);
+
+-- event streams wake on new events and on status changes
+CREATE OR REPLACE FUNCTION notify_job_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'job_events' THEN
+        PERFORM pg_notify('den_job_events', NEW.job_id::text);
+    ELSE
+        PERFORM pg_notify('den_job_events', NEW.id::text);
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER job_events_notify AFTER INSERT ON job_events
+    FOR EACH ROW EXECUTE FUNCTION notify_job_event();
+CREATE TRIGGER jobs_notify_status AFTER UPDATE OF status ON jobs
+    FOR EACH ROW EXECUTE FUNCTION notify_job_event();
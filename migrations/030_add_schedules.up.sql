@@ -0,0 +1,24 @@
+-- recurring jobs: each due schedule enqueues one job of job_type per tick
+CREATE TABLE IF NOT EXISTS schedules (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    cron TEXT NOT NULL,
+    job_type TEXT NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    paused BOOLEAN NOT NULL DEFAULT false,
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    last_job_id INTEGER REFERENCES jobs(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_schedules_due ON schedules(next_run_at) WHERE NOT paused;
+
+-- the periodic work that used to run in goroutines on every master
+INSERT INTO schedules (name, cron, job_type, next_run_at) VALUES
+    ('cleanup exports', '*/30 * * * *', 'cleanup_exports', NOW()),
+    ('cleanup recordings', '*/30 * * * *', 'cleanup_recordings', NOW()),
+    ('cleanup ssh auth history', '*/30 * * * *', 'cleanup_ssh_auth_history', NOW()),
+    ('purge expired sessions', '*/30 * * * *', 'purge_sessions', NOW()),
+    ('mark nodes offline', '* * * * *', 'mark_nodes_offline', NOW())
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS job_steps;
+ALTER TABLE jobs DROP COLUMN IF EXISTS state;
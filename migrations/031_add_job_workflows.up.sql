@@ -0,0 +1,16 @@
+-- workflow jobs save state shared between steps, and each step's progress,
+-- so a retry resumes at the step that failed
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS state JSONB;
+
+CREATE TABLE IF NOT EXISTS job_steps (
+    job_id INTEGER NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'running' CHECK (status IN ('running','done','failed','compensated','compensation_failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    PRIMARY KEY (job_id, position)
+);
+CREATE INDEX IF NOT EXISTS idx_job_steps_open ON job_steps(job_id) WHERE status IN ('running','failed','done');
@@ -0,0 +1,3 @@
+DELETE FROM schedules WHERE name = 'check custom domains';
+DROP TABLE IF EXISTS plan_limits;
+DROP TABLE IF EXISTS custom_domains;
@@ -0,0 +1,40 @@
+-- hostnames users bring themselves, routed to their container once they
+-- prove they control the name
+CREATE TABLE IF NOT EXISTS custom_domains (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hostname VARCHAR(253) NOT NULL,
+    target_port INTEGER NOT NULL,
+    verification_token VARCHAR(64) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'verified', 'failed')),
+    verification_error TEXT,
+    -- unverified domains are deleted a week after this
+    pending_since TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    verified_at TIMESTAMPTZ,
+    last_checked_at TIMESTAMPTZ,
+    -- failed ownership re-checks in a row since the domain was verified
+    ownership_failures INTEGER NOT NULL DEFAULT 0,
+    cert_status VARCHAR(20) NOT NULL DEFAULT 'none' CHECK (cert_status IN ('none', 'pending', 'issued', 'failed')),
+    cert_error TEXT,
+    cert_expires_at TIMESTAMPTZ,
+    cert_checked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_domains_user_hostname ON custom_domains(user_id, hostname);
+-- anyone may claim a name, but only one claim can be verified: the first to
+-- prove ownership gets it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_domains_verified_hostname ON custom_domains(hostname) WHERE status = 'verified';
+
+-- per-plan quotas; plans without a row get the default row's limits
+CREATE TABLE IF NOT EXISTS plan_limits (
+    plan VARCHAR(50) PRIMARY KEY,
+    max_custom_domains INTEGER NOT NULL DEFAULT 1 CHECK (max_custom_domains >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+INSERT INTO plan_limits (plan) VALUES ('default') ON CONFLICT (plan) DO NOTHING;
+
+INSERT INTO schedules (name, cron, job_type, next_run_at) VALUES
+    ('check custom domains', '*/10 * * * *', 'check_custom_domains', NOW())
+ON CONFLICT (name) DO NOTHING;
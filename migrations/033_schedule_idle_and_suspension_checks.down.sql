@@ -0,0 +1 @@
+DELETE FROM schedules WHERE name IN ('idle check', 'suspension expiry');
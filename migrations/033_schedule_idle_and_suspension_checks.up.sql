@@ -0,0 +1,5 @@
+-- the idle engine and suspension expiry ran on a ticker in every master
+INSERT INTO schedules (name, cron, job_type, next_run_at) VALUES
+    ('idle check', '*/10 * * * *', 'idle_check', NOW()),
+    ('suspension expiry', '* * * * *', 'suspension_expiry', NOW())
+ON CONFLICT (name) DO NOTHING;
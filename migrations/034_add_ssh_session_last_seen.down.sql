@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_ssh_sessions_open;
+ALTER TABLE ssh_sessions DROP COLUMN IF EXISTS last_seen_at;
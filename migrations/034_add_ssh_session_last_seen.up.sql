@@ -0,0 +1,4 @@
+-- refreshed by the gateway while a session is open, so sessions a dead
+-- gateway never closed can be told apart from long quiet ones
+ALTER TABLE ssh_sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_open ON ssh_sessions(last_seen_at) WHERE ended_at IS NULL;
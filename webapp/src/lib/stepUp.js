@@ -0,0 +1,23 @@
+// fetchWithStepUp is fetch for sensitive actions. When the server answers
+// step_up_required it asks for a two-factor code, re-checks it and retries
+// the request once.
+export async function fetchWithStepUp(url, options = {}) {
+	const res = await fetch(url, options)
+	if (res.status !== 403) return res
+	let data
+	try {
+		data = await res.clone().json()
+	} catch (_) {
+		return res
+	}
+	if (!data.step_up_required) return res
+	const code = prompt('Enter a code from your authenticator app (or a recovery code) to continue:')
+	if (!code) return res
+	const check = await fetch('/user/2fa/reauth', {
+		method: 'POST',
+		headers: { 'Content-Type': 'application/json' },
+		body: JSON.stringify({ code })
+	})
+	if (!check.ok) return check
+	return fetch(url, options)
+}